@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"notary-admission/pkg/audit"
+	log "notary-admission/pkg/logging"
+)
+
+const usage = `Usage:
+  audit verify [--output text|json] <audit log file>...
+
+Files are verified in the order given, rotated files oldest first, then the current file.
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	log.Build("error", "")
+	if log.Start() != nil {
+		panic("could not start logging")
+	}
+
+	os.Exit(verify(os.Args[2:]))
+}
+
+// verify verifies the hash chain of audit log files, exiting 1 if it is broken
+func verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	output := fs.String("output", "text", "output format, text or json (string)")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage); fs.PrintDefaults() }
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s, err := audit.Verify(fs.Args())
+
+	switch *output {
+	case "json":
+		result := struct {
+			*audit.Summary
+			Error string `json:"error,omitempty"`
+		}{Summary: s}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		b, merr := json.MarshalIndent(result, "", "  ")
+		if merr != nil {
+			fmt.Fprintln(os.Stderr, merr)
+			return 2
+		}
+		fmt.Println(string(b))
+	default:
+		if err != nil {
+			fmt.Printf("verification failed after %d entries: %v\n", s.Entries, err)
+			break
+		}
+		fmt.Printf("%d entries verified, seq %d to %d\n", s.Entries, s.First, s.Last)
+		if s.PrevHash != "" {
+			fmt.Printf("first entry follows hash %s\n", s.PrevHash)
+		}
+		fmt.Printf("last hash %s\n", s.Hash)
+	}
+
+	if err != nil {
+		return 1
+	}
+	return 0
+}
@@ -31,25 +31,32 @@ func main() {
 		panic("input config file path not specified")
 	}
 
-	e := model.ServerConfig.LoadConfig(model.ConfigFile)
+	cfg := &model.Config{}
+	e := cfg.LoadConfig(model.ConfigFile)
 	if e != nil {
 		panic(fmt.Errorf("error ingesting config file: %v", e))
 	}
+	model.SetServerConfig(cfg)
 
 	// Reinitialize logging with ingested settings
-	log.Build(model.ServerConfig.Log.Level, model.ServerConfig.Log.Encoding)
+	log.Build(model.ServerConfig().Log.Level, model.ServerConfig().Log.Encoding)
 	if log.Start() != nil {
 		panic("could not restart logging")
 	}
 
 	log.Log.Debugf("config file (%s) ingested successfully", model.ConfigFile)
 
-	homeDir = model.ServerConfig.Notation.HomeDir
-	xdgHomeVal = model.ServerConfig.Notation.XdgHomeVal
+	e = notation.ValidatePolicies(cfg)
+	if e != nil {
+		panic(fmt.Errorf("invalid namespace policies: %v", e))
+	}
+
+	homeDir = model.ServerConfig().Notation.HomeDir
+	xdgHomeVal = model.ServerConfig().Notation.XdgHomeVal
 
 	// Verify files/dirs exist
-	files := []string{model.ServerConfig.Notation.BinarySrc,
-		model.ServerConfig.Notation.RootCert, xdgHomeVal, "signer/" + model.ServerConfig.Notation.PluginFile}
+	files := []string{model.ServerConfig().Notation.BinarySrc,
+		model.ServerConfig().Notation.RootCert, xdgHomeVal, "signer/" + model.ServerConfig().Notation.PluginFile}
 	fv := utils.VerifyFiles(files)
 
 	for _, f := range fv.VerifiedFiles {
@@ -89,7 +96,7 @@ func main() {
 	}
 
 	// Get and log config YAML
-	b, err := model.ServerConfig.Yaml()
+	b, err := model.ServerConfig().Yaml()
 	if err != nil {
 		panic(fmt.Sprintf("error reading config: %v", err))
 	}
@@ -103,7 +110,7 @@ func main() {
 	log.Log.Debugf("Trust policy:\n%s", string(b))
 
 	// Write trust policy
-	trustPolicyPath := homeDir + "/" + model.ServerConfig.Notation.TrustPolicy
+	trustPolicyPath := homeDir + "/" + model.ServerConfig().Notation.TrustPolicy
 	err = utils.CreateFile(trustPolicyPath, b)
 	if err != nil {
 		panic(fmt.Sprintf("error writing trust policy: %v", err))
@@ -111,21 +118,21 @@ func main() {
 
 	var out string
 	// Tree config dir
-	out, err = utils.Tree(model.ServerConfig.Notation.XdgHomeVal)
+	out, err = utils.Tree(model.ServerConfig().Notation.XdgHomeVal)
 	if err != nil {
-		log.Log.Errorf("tree of %s failed: %v", model.ServerConfig.Notation.XdgHomeVal, err)
+		log.Log.Errorf("tree of %s failed: %v", model.ServerConfig().Notation.XdgHomeVal, err)
 	}
-	log.Log.Debugf("tree of %s:\n%s", model.ServerConfig.Notation.XdgHomeVal, out)
+	log.Log.Debugf("tree of %s:\n%s", model.ServerConfig().Notation.XdgHomeVal, out)
 
 	// Read TP
 	b, err = utils.ReadFile(trustPolicyPath)
 	if err != nil {
-		log.Log.Errorf("could not read file: %s, %v", model.ServerConfig.Notation.XdgHomeVal, err)
+		log.Log.Errorf("could not read file: %s, %v", model.ServerConfig().Notation.XdgHomeVal, err)
 	}
 	log.Log.Debugf("read trust policy: %s", string(b))
 
 	// Create notation bin dir
-	binaryDir := model.ServerConfig.Notation.BinaryDir
+	binaryDir := model.ServerConfig().Notation.BinaryDir
 	err = utils.CreateDirectory(binaryDir)
 	if err != nil {
 		panic(fmt.Sprintf("could not create binary dir: %s", binaryDir))
@@ -137,7 +144,7 @@ func main() {
 	}
 
 	// Copy notation binary
-	binarySrc := model.ServerConfig.Notation.BinarySrc
+	binarySrc := model.ServerConfig().Notation.BinarySrc
 	binaryPath := binaryDir + "/notation"
 	if !utils.CopyFile(binarySrc, binaryPath) {
 		panic(fmt.Sprintf("could not copy %s to %s", binarySrc, binaryPath))
@@ -156,8 +163,8 @@ func main() {
 	}
 
 	// Check/upsert XDG_CONFIG_HOME ENV variable
-	xdgVar := model.ServerConfig.Notation.XdgHomeVar
-	xdgVal := model.ServerConfig.Notation.XdgHomeVal
+	xdgVar := model.ServerConfig().Notation.XdgHomeVar
+	xdgVal := model.ServerConfig().Notation.XdgHomeVal
 	osVal := os.Getenv(xdgVar)
 	if xdgVal != osVal {
 		log.Log.Infof("trying to set %s env var to %s", xdgVar, xdgVal)
@@ -168,7 +175,7 @@ func main() {
 	}
 
 	// Create notation plugin dir
-	pluginDir := model.ServerConfig.Notation.PluginDir
+	pluginDir := model.ServerConfig().Notation.PluginDir
 	err = utils.CreateDirectory(pluginDir)
 	if err != nil {
 		panic(fmt.Sprintf("could not create plugin dir: %s", pluginDir))
@@ -180,7 +187,7 @@ func main() {
 	}
 
 	// Copy signer plugin
-	pluginFile := model.ServerConfig.Notation.PluginFile
+	pluginFile := model.ServerConfig().Notation.PluginFile
 	pluginPath := pluginDir + "/" + pluginFile
 	if !utils.CopyFile("signer/"+pluginFile, pluginPath) {
 		panic(fmt.Sprintf("could not copy %s to %s", "signer/"+pluginFile, pluginPath))
@@ -191,6 +198,14 @@ func main() {
 		panic(fmt.Sprintf("could not set %s file mode to %s", pluginPath, fm))
 	}
 
+	// Write namespace trust policies
+	for _, np := range model.ServerConfig().Notation.NamespacePolicies {
+		err = writeNamespacePolicy(np)
+		if err != nil {
+			panic(fmt.Sprintf("could not write %s namespace policy: %v", np.Name, err))
+		}
+	}
+
 	// Tree config dir
 	out, err = utils.Tree(xdgVal)
 	if err != nil {
@@ -200,3 +215,33 @@ func main() {
 
 	log.Log.Info("Init completed successfully...")
 }
+
+// writeNamespacePolicy writes the namespace trust policy to its own notation home, linked to the
+// trust store and plugins of the notation home, and adds its root cert, if any, to the trust store
+func writeNamespacePolicy(np model.NamespacePolicy) error {
+	var tp model.TrustPolicyModel
+	err := tp.LoadTrustpolicy(np.TrustPolicy)
+	if err != nil {
+		return fmt.Errorf("could not ingest trust policy file: %w", err)
+	}
+
+	b, err := tp.Json()
+	if err != nil {
+		return fmt.Errorf("could not read trust policy: %w", err)
+	}
+	log.Log.Debugf("%s namespace trust policy:\n%s", np.Name, string(b))
+
+	err = notation.WritePolicy(np.Name, b)
+	if err != nil {
+		return err
+	}
+
+	if np.TrustStore != "" {
+		out, err := notation.AddCertificate(np.TrustStore, np.RootCert)
+		if err != nil {
+			return fmt.Errorf("could not add %s to %s trust store: %s, %w", np.RootCert, np.TrustStore, out, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/policycheck"
+)
+
+const usage = `Usage:
+  policy lint --file <config> --trustPolicyFile <trust policy> [--home <notation home>] [--output text|json]
+  policy explain --file <config> --trustPolicyFile <trust policy> [--namespace <ns>] [--labels k=v,...] <image>
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Library warnings only, output is written to stdout
+	log.Build("error", "")
+	if log.Start() != nil {
+		panic("could not start logging")
+	}
+
+	switch os.Args[1] {
+	case "lint":
+		os.Exit(lint(os.Args[2:]))
+	case "explain":
+		os.Exit(explain(os.Args[2:]))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// lint prints the findings of the config and trust policies, exiting 1 if any is an error
+func lint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configFile := fs.String("file", "", "config file path (string)")
+	trustPolicyFile := fs.String("trustPolicyFile", "", "trust policy file path (string)")
+	home := fs.String("home", "", "notation home, whose existing trust stores are known (string)")
+	output := fs.String("output", "text", "output format, text or json (string)")
+	_ = fs.Parse(args)
+
+	p, err := load(*configFile, *trustPolicyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	p.Home = *home
+
+	findings := policycheck.Lint(p)
+
+	switch *output {
+	case "json":
+		if findings == nil {
+			findings = []policycheck.Finding{}
+		}
+		b, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		fmt.Println(string(b))
+	default:
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		if len(findings) == 0 {
+			fmt.Println("no problems found")
+		}
+	}
+
+	if policycheck.HasErrors(findings) {
+		return 1
+	}
+	return 0
+}
+
+// explain prints the verification of an image admitted in a namespace
+func explain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	configFile := fs.String("file", "", "config file path (string)")
+	trustPolicyFile := fs.String("trustPolicyFile", "", "trust policy file path (string)")
+	namespace := fs.String("namespace", "default", "namespace of the workload (string)")
+	labels := fs.String("labels", "", "namespace labels, k=v,... (string)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	p, err := load(*configFile, *trustPolicyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	l, err := parseLabels(*labels)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	e, err := policycheck.Explain(p, fs.Arg(0), *namespace, l)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err = e.Write(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+func load(configFile string, trustPolicyFile string) (*policycheck.Policies, error) {
+	if configFile == "" || trustPolicyFile == "" {
+		return nil, fmt.Errorf("config and trust policy file paths not specified")
+	}
+	return policycheck.Load(configFile, trustPolicyFile)
+}
+
+// parseLabels parses comma separated k=v labels
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("malformed label %q, must be k=v", kv)
+		}
+		labels[k] = v
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/replay"
+)
+
+const usage = `Usage:
+  replay --file <config> --trustPolicyFile <trust policy> [--notationHome <dir>] [--mode library|binary]
+         [--objects <file or dir>,...] [--output text|json] [--logLevel <level>] <review file or dir>...
+`
+
+func main() {
+	o := replay.Options{}
+	var objects, output, logLevel string
+
+	flag.StringVar(&o.ConfigFile, "file", "", "config file path (string)")
+	flag.StringVar(&o.TrustPolicyFile, "trustPolicyFile", "", "trust policy file path (string)")
+	flag.StringVar(&o.NotationHome, "notationHome", defaultNotationHome(), "local notation home, with the trust store and plugins (string)")
+	flag.StringVar(&o.Mode, "mode", model.LibraryMode, "notation verification mode, library or binary, config mode if empty (string)")
+	flag.StringVar(&objects, "objects", "", "comma separated Kubernetes object files or dirs (string)")
+	flag.StringVar(&output, "output", "text", "output format, text or json (string)")
+	flag.StringVar(&logLevel, "logLevel", "fatal", "server log level, debug, info, warn, error or fatal (string)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage); flag.PrintDefaults() }
+	flag.Parse()
+
+	if o.ConfigFile == "" || o.TrustPolicyFile == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if objects != "" {
+		o.Objects = strings.Split(objects, ",")
+	}
+
+	log.Build(logLevel, "")
+	if log.Start() != nil {
+		panic("could not start logging")
+	}
+
+	files, err := replay.Files(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Namespaces not in the objects are replayed unlabeled
+	o.Namespaces, err = replay.Namespaces(files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	hook, cleanup, err := replay.Setup(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var reviews []replay.Review
+	for _, f := range files {
+		r, err := replay.Replay(hook, f)
+		if err != nil {
+			reviews = append(reviews, replay.Review{File: f, Error: err.Error()})
+			continue
+		}
+		reviews = append(reviews, r...)
+	}
+
+	code := 0
+	for _, r := range reviews {
+		if r.Error != "" {
+			code = 2
+		} else if r.Changed() && code == 0 {
+			code = 1
+		}
+	}
+
+	switch output {
+	case "json":
+		b, err := json.MarshalIndent(reviews, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+			break
+		}
+		fmt.Println(string(b))
+	default:
+		for _, r := range reviews {
+			if err = r.Write(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 2
+			}
+		}
+	}
+
+	cleanup()
+	os.Exit(code)
+}
+
+// defaultNotationHome returns the notation CLI user config directory
+func defaultNotationHome() string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ".notation"
+	}
+	return filepath.Join(d, "notation")
+}
@@ -6,10 +6,19 @@ import (
 	"fmt"
 	"golang.org/x/exp/maps"
 	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/audit"
+	"notary-admission/pkg/decision"
+	"notary-admission/pkg/exemption"
 	"notary-admission/pkg/handlers"
+	"notary-admission/pkg/imagepolicy"
+	"notary-admission/pkg/kube"
 	log "notary-admission/pkg/logging"
 	"notary-admission/pkg/model"
 	"notary-admission/pkg/notation"
+	"notary-admission/pkg/reload"
+	"notary-admission/pkg/rescan"
+	"notary-admission/pkg/tracing"
 	"notary-admission/pkg/utils"
 	"os"
 	"os/signal"
@@ -38,26 +47,49 @@ func main() {
 		panic("input config file path not specified")
 	}
 
-	e := model.ServerConfig.LoadConfig(model.ConfigFile)
+	cfg := &model.Config{}
+	e := cfg.LoadConfig(model.ConfigFile)
 	if e != nil {
 		panic(fmt.Errorf("error ingesting config file: %v", e))
 	}
 
+	// Verify IRSA ENV, before the config is shared
+	region := os.Getenv("AWS_REGION")
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	tokenFilePath := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	cfg.AwsRegion = region
+	cfg.AwsRole = roleArn
+	cfg.AwsAccountId = utils.AccountFromRole(roleArn)
+	cfg.AwsTokenFilePath = tokenFilePath
+
+	model.SetServerConfig(cfg)
+
 	// Reinitialize logging with ingested settings
-	log.Build(model.ServerConfig.Log.Level, model.ServerConfig.Log.Encoding)
+	log.Build(model.ServerConfig().Log.Level, model.ServerConfig().Log.Encoding)
 	if log.Start() != nil {
 		panic("could not restart logging")
 	}
 
 	log.Log.Debugf("config file (%s) ingested successfully", model.ConfigFile)
 
-	//port = model.ServerConfig.Network.Ports.Https
-	tlsKey = model.ServerConfig.Network.TLS.KeyFile
-	tlsCrt = model.ServerConfig.Network.TLS.CertFile
-	xdgHomeVal = model.ServerConfig.Notation.XdgHomeVal
+	// Export admission request traces
+	err := tracing.InitTracing()
+	if err != nil {
+		panic(fmt.Sprintf("could not start tracing: %v", err))
+	}
+
+	//port = model.ServerConfig().Network.Ports.Https
+	tlsKey = model.ServerConfig().Network.TLS.KeyFile
+	tlsCrt = model.ServerConfig().Network.TLS.CertFile
+	xdgHomeVal = model.ServerConfig().Notation.XdgHomeVal
+
+	libraryMode := model.ServerConfig().Notation.Mode == model.LibraryMode
 
 	// Verify files/dirs exist
-	files := []string{tlsKey, tlsCrt, model.ServerConfig.Notation.BinaryDst, xdgHomeVal}
+	files := []string{tlsKey, tlsCrt, xdgHomeVal}
+	if !libraryMode {
+		files = append(files, model.ServerConfig().Notation.BinaryDst)
+	}
 	fv := utils.VerifyFiles(files)
 
 	for _, f := range fv.VerifiedFiles {
@@ -66,27 +98,32 @@ func main() {
 		}
 	}
 
-	// Test notation version
-	nc := notation.Command{
-		Args: []string{model.ServerConfig.Notation.VersionCommand},
-	}
-	nc.Execute()
-	if nc.Error != nil {
-		panic(fmt.Sprintf("notation version failed: %s, %s, %v", nc.Out, nc.Err, nc.Error))
+	err = notation.ValidatePolicies(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("invalid namespace policies: %v", err))
 	}
-	log.Log.Debugf("notation version: %s", nc.Out)
 
-	// Verify IRSA ENV
-	region := os.Getenv("AWS_REGION")
-	roleArn := os.Getenv("AWS_ROLE_ARN")
-	tokenFilePath := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
-	model.ServerConfig.AwsRegion = region
-	model.ServerConfig.AwsRole = roleArn
-	model.ServerConfig.AwsAccountId = utils.AccountFromRole(roleArn)
-	model.ServerConfig.AwsTokenFilePath = tokenFilePath
+	if libraryMode {
+		// Build in-process notation verifier
+		err = notation.InitLibrary()
+		if err != nil {
+			panic(fmt.Sprintf("notation library init failed: %v", err))
+		}
+		log.Log.Info("notation library mode enabled")
+	} else {
+		// Test notation version
+		nc := notation.Command{
+			Args: []string{model.ServerConfig().Notation.VersionCommand},
+		}
+		nc.Execute()
+		if nc.Error != nil {
+			panic(fmt.Sprintf("notation version failed: %s, %s, %v", nc.Out, nc.Err, nc.Error))
+		}
+		log.Log.Debugf("notation version: %s", nc.Out)
+	}
 
 	// Get and log config YAML
-	b, err := model.ServerConfig.Yaml()
+	b, err := model.ServerConfig().Yaml()
 	if err != nil {
 		panic(fmt.Sprintf("error reading config: %v", err))
 	}
@@ -98,20 +135,101 @@ func main() {
 		panic("ECR auth not initialized")
 	}
 
-	if model.ServerConfig.Ecr.CredentialCache.Enabled {
-		err = verifier.Ecrv.LoadPreAuthRegistries()
+	// Refresh cached ECR creds in the background, failures are logged and retried
+	stop := make(chan struct{})
+	if model.ServerConfig().Ecr.CredentialCache.Enabled {
+		err = ecrv.LoadPreAuthRegistries()
 		if err != nil {
 			panic("could not load pre-auth registries")
 		}
+		go ecrv.StartRefresh(stop)
+		go verifier.GetEcrpv().StartRefresh(stop)
+	}
+
+	// Register verifiers by registry pattern
+	err = verifier.InitVerifiers()
+	if err != nil {
+		panic(fmt.Sprintf("could not register verifiers: %v", err))
+	}
+
+	// Setup verification result cache
+	verifier.InitVerificationCache()
+
+	// Load break-glass exemption admin keys
+	err = exemption.InitExemptions()
+	if err != nil {
+		panic(fmt.Sprintf("could not load exemption keys: %v", err))
+	}
+
+	// Emit Kubernetes Events for denials and bypasses
+	err = decision.InitEvents(stop)
+	if err != nil {
+		panic(fmt.Sprintf("could not start event recorder: %v", err))
 	}
 
-	if len(model.BypassRegistries) > 0 {
-		log.Log.Infof("Bypassed registries: %v", maps.Keys(model.BypassRegistries))
+	// Open the audit log of admission decisions
+	err = audit.InitAudit()
+	if err != nil {
+		panic(fmt.Sprintf("could not open audit log: %v", err))
+	}
+
+	// Validate the global enforcement mode
+	err = workloads.ValidateEnforcement(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("invalid enforcement config: %v", err))
+	}
+
+	// Sync namespace labels for namespace policy selection and enforcement mode
+	if notation.UsesNamespaceLabels() || model.ServerConfig().Enforcement.NamespaceLabel != "" {
+		err = kube.StartNamespaceInformer()
+		if err != nil {
+			panic(fmt.Sprintf("could not start namespace informer: %v", err))
+		}
+	}
+
+	// Cache ServiceAccounts and pull secrets for registry auth
+	if model.ServerConfig().Kubernetes.PullSecrets.Enabled {
+		err = kube.StartPullSecretInformers()
+		if err != nil {
+			panic(fmt.Sprintf("could not start pull secret informers: %v", err))
+		}
+	}
+
+	// Watch ImageVerificationPolicy objects, applied without restart
+	if model.ServerConfig().ImageVerificationPolicies.Enabled {
+		err = kube.StartNamespaceInformer()
+		if err != nil {
+			panic(fmt.Sprintf("could not start namespace informer: %v", err))
+		}
+
+		err = imagepolicy.Start(stop)
+		if err != nil {
+			panic(fmt.Sprintf("could not watch image verification policies: %v", err))
+		}
+	}
+
+	// Watch config, trust policy and trust store files, swapping in valid changes
+	if cfg.Reload.Enabled {
+		w, err := reload.NewWatcher(model.ConfigFile, model.TrustPolicyFile)
+		if err != nil {
+			panic(fmt.Sprintf("could not watch config: %v", err))
+		}
+		go w.Start(time.Duration(cfg.Reload.Interval)*time.Second, stop)
+	}
+
+	// Re-verify running pods in the background
+	err = rescan.Start(stop)
+	if err != nil {
+		panic(fmt.Sprintf("could not start re-verification scanner: %v", err))
+	}
+
+	if len(model.ServerConfig().BypassRegistries) > 0 {
+		log.Log.Infof("Bypassed registries: %v", maps.Keys(model.ServerConfig().BypassRegistries))
 	}
 
 	// Check/upsert XDG_CONFIG_HOME ENV variable
-	xdgVar := model.ServerConfig.Notation.XdgHomeVar
-	xdgVal := model.ServerConfig.Notation.XdgHomeVal
+	xdgVar := model.ServerConfig().Notation.XdgHomeVar
+	xdgVal := model.ServerConfig().Notation.XdgHomeVal
 	osVal := os.Getenv(xdgVar)
 	if xdgVal != osVal {
 		log.Log.Infof("trying to set %s env var to %s", xdgVar, xdgVal)
@@ -149,22 +267,30 @@ func main() {
 
 	<-done
 	fmt.Println("server stopping...")
+	close(stop)
+	if l := audit.Al(); l != nil {
+		_ = l.Close()
+	}
 
-	_, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
 		cancel()
 	}()
 
+	if err = tracing.Shutdown(ctx); err != nil {
+		log.Log.Errorf("could not export remaining spans: %v", err)
+	}
+
 	fmt.Println("server exited gracefully")
 }
 
-// run starts 3 Go routines with a common error channel
+// run starts 2 Go routines with a common error channel
 func run(tlsCrt string, tlsKey string) chan error {
 	errs := make(chan error)
 
 	// Starting HTTP server
 	go func() {
-		port := model.ServerConfig.Network.Ports.Http
+		port := model.ServerConfig().Network.Ports.Http
 		log.Log.Infof("starting HTTP listener at %s", port)
 
 		server := handlers.NewServer(port)
@@ -175,23 +301,12 @@ func run(tlsCrt string, tlsKey string) chan error {
 
 	// Starting HTTPS server
 	go func() {
-		port := model.ServerConfig.Network.Ports.Https
+		port := model.ServerConfig().Network.Ports.Https
 		log.Log.Infof("starting HTTPS listener at %s", port)
 		server := handlers.NewTlsServer(port)
 		if err := server.ListenAndServeTLS(tlsCrt, tlsKey); err != nil {
 			errs <- err
 		}
 	}()
-
-	// Start cron job
-	go func() {
-		for model.ServerConfig.Ecr.CredentialCache.Enabled {
-			time.Sleep(time.Duration(model.ServerConfig.Ecr.CredentialCache.CacheRefreshInterval) * time.Second)
-			log.Log.Info("Waking up to refresh cached ECR creds")
-			if err := verifier.Ecrv.RefreshCredsCache(); err != nil {
-				errs <- err
-			}
-		}
-	}()
 	return errs
 }
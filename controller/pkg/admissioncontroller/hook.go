@@ -1,19 +1,46 @@
 package admissioncontroller
 
 import (
+	"context"
 	"fmt"
 	v1 "k8s.io/api/admission/v1"
 )
 
+const (
+	PatchOpReplace = "replace"
+)
+
 // Result contains the result of an admission request
 type Result struct {
-	Allowed  bool
-	Msg      string
-	Warnings []string
+	Allowed          bool
+	Msg              string
+	Warnings         []string
+	Patch            []byte
+	AuditAnnotations map[string]string
+	// Images are the verification results of the workload images, not part of the admission response
+	Images []ImageResult
+}
+
+// ImageResult is the verification result of a single image
+type ImageResult struct {
+	Image    string `json:"image"`
+	Digest   string `json:"digest,omitempty"`
+	Verified bool   `json:"verified"`
+	Bypassed bool   `json:"bypassed,omitempty"`
+	Signer   string `json:"signer,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// PatchOperation is a single JSONPatch operation returned by mutating hooks
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
 }
 
-// AdmitFunc defines how to process an admission request
-type AdmitFunc func(request *v1.AdmissionRequest) (*Result, error)
+// AdmitFunc defines how to process an admission request, ctx carrying the request trace
+type AdmitFunc func(ctx context.Context, request *v1.AdmissionRequest) (*Result, error)
 
 // Hook represents the set of functions for each operation in an admission webhook.
 type Hook struct {
@@ -24,26 +51,26 @@ type Hook struct {
 }
 
 // Execute evaluates the request and try to execute the function for operation specified in the request.
-func (h *Hook) Execute(r *v1.AdmissionRequest) (*Result, error) {
+func (h *Hook) Execute(ctx context.Context, r *v1.AdmissionRequest) (*Result, error) {
 	switch r.Operation {
 	case v1.Create:
-		return wrapperExecution(h.Create, r)
+		return wrapperExecution(ctx, h.Create, r)
 	case v1.Update:
-		return wrapperExecution(h.Update, r)
+		return wrapperExecution(ctx, h.Update, r)
 	case v1.Delete:
-		return wrapperExecution(h.Delete, r)
+		return wrapperExecution(ctx, h.Delete, r)
 	case v1.Connect:
-		return wrapperExecution(h.Connect, r)
+		return wrapperExecution(ctx, h.Connect, r)
 	}
 
 	return &Result{Msg: fmt.Sprintf("Invalid operation: %s", r.Operation)}, nil
 }
 
 // wrapperExecution handles function execution
-func wrapperExecution(fn AdmitFunc, r *v1.AdmissionRequest) (*Result, error) {
+func wrapperExecution(ctx context.Context, fn AdmitFunc, r *v1.AdmissionRequest) (*Result, error) {
 	if fn == nil {
 		return nil, fmt.Errorf("operation %s is not registered", r.Operation)
 	}
 
-	return fn(r)
+	return fn(ctx, r)
 }
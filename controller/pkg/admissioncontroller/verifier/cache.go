@@ -0,0 +1,178 @@
+package verifier
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	notationgo "github.com/notaryproject/notation-go"
+
+	"notary-admission/pkg/cosign"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+)
+
+const (
+	CacheResultVerified = "verified"
+	CacheResultFailed   = "failed"
+)
+
+type cacheEntry struct {
+	response Response
+	expires  time.Time
+}
+
+// VerificationCache caches verification responses by digest reference and trust policy hash
+type VerificationCache struct {
+	lock       sync.RWMutex
+	entries    map[string]cacheEntry
+	policyHash string
+	metric     *metrics.PrometheusCacheMetric
+}
+
+// Vc Singleton used to hold single instance
+var Vc *VerificationCache
+
+// InitVerificationCache creates the singleton VerificationCache, if enabled
+func InitVerificationCache() {
+	if !model.ServerConfig().Notation.Cache.Enabled {
+		return
+	}
+
+	Vc = &VerificationCache{
+		entries: make(map[string]cacheEntry),
+		metric:  metrics.InitPrometheusCacheMetric(model.ServerConfig().Prometheus.Name),
+	}
+
+	err := notation.RefreshPolicyHash()
+	if err != nil {
+		log.Log.Errorf("could not hash trust policies, verification cache skipped: %v", err)
+	}
+}
+
+// key builds the cache key from digest reference and the stored trust policy hash,
+// purging the cache if the trust policy has changed
+func (c *VerificationCache) key(ref string) (string, bool) {
+	h, err := notation.PolicyHash()
+	if err != nil {
+		log.Log.Debugf("verification cache skipped: %v", err)
+		return "", false
+	}
+
+	c.lock.Lock()
+	if c.policyHash != h {
+		if c.policyHash != "" {
+			log.Log.Infof("trust policy changed, purging %d cached verifications", len(c.entries))
+		}
+		c.entries = make(map[string]cacheEntry)
+		c.policyHash = h
+	}
+	c.lock.Unlock()
+
+	return ref + "|" + h, true
+}
+
+// Purge removes all cached verifications, when verification inputs other than the trust policies change
+func (c *VerificationCache) Purge(reason string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	log.Log.Infof("%s, purging %d cached verifications", reason, len(c.entries))
+	c.entries = make(map[string]cacheEntry)
+}
+
+// Get returns the cached response for digest reference, if present and not expired
+func (c *VerificationCache) Get(ref string) (Response, bool) {
+	k, ok := c.key(ref)
+	if !ok {
+		return Response{}, false
+	}
+
+	c.lock.RLock()
+	e, found := c.entries[k]
+	c.lock.RUnlock()
+
+	if !found || time.Now().After(e.expires) {
+		c.metric.Misses.Inc()
+		return Response{}, false
+	}
+
+	if e.response.Error != nil {
+		c.metric.Hits.WithLabelValues(CacheResultFailed).Inc()
+	} else {
+		c.metric.Hits.WithLabelValues(CacheResultVerified).Inc()
+	}
+
+	log.Log.Debugf("verification cache hit for %s", ref)
+
+	return e.response, true
+}
+
+// Put caches the response for digest reference, using the positive or negative TTL
+func (c *VerificationCache) Put(ref string, r Response) {
+	ttl := model.ServerConfig().Notation.Cache.PositiveTTL
+	if r.Error != nil {
+		if !cacheableFailure(r.Error, r.ErrorMessage) {
+			return
+		}
+		ttl = model.ServerConfig().Notation.Cache.NegativeTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	k, ok := c.key(ref)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if max := model.ServerConfig().Notation.Cache.MaxEntries; max > 0 && len(c.entries) >= max {
+		for ek, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, ek)
+			}
+		}
+		if len(c.entries) >= max {
+			log.Log.Debugf("verification cache full, %s not cached", ref)
+			return
+		}
+	}
+
+	c.entries[k] = cacheEntry{
+		response: r,
+		expires:  now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// cacheableFailure determines if a failed verification, with error message msg, can be negatively
+// cached. Only signature failures are cached, not registry or credential errors. Library mode and
+// cosign return typed errors; binary mode failures are classified by the notation stderr.
+func cacheableFailure(err error, msg string) bool {
+	var cf cosign.ErrorVerificationFailed
+	if errors.As(err, &cf) {
+		return true
+	}
+
+	if model.ServerConfig().Notation.Mode != model.LibraryMode {
+		switch failureReason(err, msg) {
+		case ReasonSignatureInvalid, ReasonSignatureNotFound:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var vf notationgo.ErrorVerificationFailed
+	var vi notationgo.ErrorVerificationInconclusive
+	var np notationgo.ErrorNoApplicableTrustPolicy
+
+	return errors.As(err, &vf) || errors.As(err, &vi) || errors.As(err, &np)
+}
@@ -0,0 +1,129 @@
+package verifier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"notary-admission/pkg/cosign"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+)
+
+const cachedRef = "registry.example.com/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+var cacheMetric = metrics.InitPrometheusCacheMetric("cache_test")
+
+// newTestCache returns an empty verification cache, with positive and negative TTLs, and the default
+// trust policy written to a temporary notation home
+func newTestCache(t *testing.T, positiveTTL int, negativeTTL int) *VerificationCache {
+	t.Helper()
+
+	c := &model.Config{}
+	c.Notation.HomeDir = t.TempDir()
+	c.Notation.TrustPolicy = "trustpolicy.json"
+	c.Notation.Cache.Enabled = true
+	c.Notation.Cache.PositiveTTL = positiveTTL
+	c.Notation.Cache.NegativeTTL = negativeTTL
+	model.SetServerConfig(c)
+
+	writeTestPolicy(t, `{"version":"1.0"}`)
+
+	return &VerificationCache{entries: make(map[string]cacheEntry), metric: cacheMetric}
+}
+
+// writeTestPolicy writes the default trust policy and refreshes the trust policy hash
+func writeTestPolicy(t *testing.T, trustPolicy string) {
+	t.Helper()
+
+	if err := notation.WritePolicy("", []byte(trustPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := notation.RefreshPolicyHash(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerificationCacheTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		negativeTTL int
+		response    Response
+		want        bool
+	}{
+		{name: "verified", negativeTTL: 60, response: Response{Digest: "sha256:0123"}, want: true},
+		{
+			name:        "signature failure",
+			negativeTTL: 60,
+			response:    Response{Error: cosign.ErrorVerificationFailed{Msg: "no valid signature"}},
+			want:        true,
+		},
+		{
+			name:        "registry failure",
+			negativeTTL: 60,
+			response:    Response{Error: errors.New("could not resolve: 503 Service Unavailable")},
+		},
+		{
+			name:     "negative caching disabled",
+			response: Response{Error: cosign.ErrorVerificationFailed{Msg: "no valid signature"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, 300, tt.negativeTTL)
+			c.Put(cachedRef, tt.response)
+
+			r, ok := c.Get(cachedRef)
+			if ok != tt.want {
+				t.Fatalf("Get found = %t, want %t", ok, tt.want)
+			}
+			if ok && (r.Digest != tt.response.Digest || (r.Error == nil) != (tt.response.Error == nil)) {
+				t.Errorf("Get = %+v, want %+v", r, tt.response)
+			}
+		})
+	}
+}
+
+func TestVerificationCacheExpiry(t *testing.T) {
+	c := newTestCache(t, 300, 0)
+	c.Put(cachedRef, Response{Digest: "sha256:0123"})
+
+	for k, e := range c.entries {
+		e.expires = time.Now().Add(-time.Second)
+		c.entries[k] = e
+	}
+
+	if _, ok := c.Get(cachedRef); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestVerificationCacheInvalidation(t *testing.T) {
+	c := newTestCache(t, 300, 0)
+	c.Put(cachedRef, Response{Digest: "sha256:0123"})
+	if _, ok := c.Get(cachedRef); !ok {
+		t.Fatal("entry not cached")
+	}
+
+	// Unchanged trust policy content keeps the hash
+	writeTestPolicy(t, `{"version":"1.0"}`)
+	if _, ok := c.Get(cachedRef); !ok {
+		t.Fatal("entry purged when trust policy was rewritten unchanged")
+	}
+
+	writeTestPolicy(t, `{"version":"1.0","trustPolicies":[]}`)
+	if _, ok := c.Get(cachedRef); ok {
+		t.Fatal("entry returned after trust policy changed")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("%d entries kept after trust policy changed", len(c.entries))
+	}
+
+	c.Put(cachedRef, Response{Digest: "sha256:0123"})
+	c.Purge("test")
+	if _, ok := c.Get(cachedRef); ok {
+		t.Error("entry returned after purge")
+	}
+}
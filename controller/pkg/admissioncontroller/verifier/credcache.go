@@ -0,0 +1,214 @@
+package verifier
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	log "notary-admission/pkg/logging"
+)
+
+const (
+	DefaultRefreshInterval = 5 * time.Minute
+	RefreshJitter          = 0.1
+	RefreshBackoffBase     = 5 * time.Second
+	FetchTimeout           = 30 * time.Second
+
+	FetchResultSuccess = "success"
+)
+
+type credentialEntry struct {
+	token    EcrAuthToken
+	failures int
+	retryAt  time.Time
+}
+
+// CredentialCache caches registry auth tokens by registry. Misses are fetched once per registry,
+// however many requests are waiting, and expiring tokens are refreshed in the background.
+type CredentialCache struct {
+	lock    sync.RWMutex
+	entries map[string]*credentialEntry
+	group   singleflight.Group
+	fetch   func(ctx context.Context, registry string) (EcrAuthToken, error)
+}
+
+// NewCredentialCache creates a CredentialCache that fetches tokens with fetch
+func NewCredentialCache(fetch func(ctx context.Context, registry string) (EcrAuthToken, error)) *CredentialCache {
+	c := &CredentialCache{
+		entries: make(map[string]*credentialEntry),
+		fetch:   fetch,
+	}
+	registerCache(c)
+
+	return c
+}
+
+// Get returns the cached, unexpired token for registry, or fetches it
+func (c *CredentialCache) Get(ctx context.Context, registry string) (EcrAuthToken, error) {
+	c.lock.RLock()
+	e, ok := c.entries[registry]
+	var token EcrAuthToken
+	if ok {
+		token = e.token
+	}
+	c.lock.RUnlock()
+
+	if ok && time.Now().Before(token.Expiry()) {
+		tokenMetric.Hits.WithLabelValues(registryHostLabel(registry)).Inc()
+		return token, nil
+	}
+
+	tokenMetric.Misses.WithLabelValues(registryHostLabel(registry)).Inc()
+	return c.load(ctx, registry)
+}
+
+// Contains determines if a token for registry is cached
+func (c *CredentialCache) Contains(registry string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.entries[registry]
+	return ok
+}
+
+// Registries returns the registries with cached tokens
+func (c *CredentialCache) Registries() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	registries := make([]string, 0, len(c.entries))
+	for r := range c.entries {
+		registries = append(registries, r)
+	}
+	return registries
+}
+
+// Expiries returns the expiry of the cached token of each registry
+func (c *CredentialCache) Expiries() map[string]time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	expiries := make(map[string]time.Time, len(c.entries))
+	for r, e := range c.entries {
+		expiries[r] = e.token.Expiry()
+	}
+	return expiries
+}
+
+// load fetches and caches the token for registry, sharing in-flight fetches of the same registry.
+// Shared fetches are not cancelled with the first caller, but time out after FetchTimeout.
+func (c *CredentialCache) load(ctx context.Context, registry string) (EcrAuthToken, error) {
+	t, err, shared := c.group.Do(registry, func() (interface{}, error) {
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), FetchTimeout)
+		defer cancel()
+
+		label := registryHostLabel(registry)
+		start := time.Now()
+		token, err := c.fetch(fctx, registry)
+		if err != nil {
+			tokenMetric.FetchDuration.WithLabelValues(label, ResultError).Observe(time.Since(start).Seconds())
+			tokenMetric.FetchErrors.WithLabelValues(label, failureReason(err, "")).Inc()
+			return nil, err
+		}
+		tokenMetric.FetchDuration.WithLabelValues(label, FetchResultSuccess).Observe(time.Since(start).Seconds())
+
+		c.lock.Lock()
+		c.entries[registry] = &credentialEntry{token: token}
+		c.lock.Unlock()
+
+		return token, nil
+	})
+	if err != nil {
+		return EcrAuthToken{}, err
+	}
+
+	if shared {
+		log.Log.Debugf("shared in-flight token fetch for %s", registry)
+	}
+
+	return t.(EcrAuthToken), nil
+}
+
+// Refresh refreshes the tokens that expire within timeout. Failed refreshes are logged, and
+// retried with exponential backoff, up to interval; the cached token is kept until it expires.
+func (c *CredentialCache) Refresh(timeout time.Duration, interval time.Duration) {
+	now := time.Now()
+
+	c.lock.RLock()
+	var due []string
+	for r, e := range c.entries {
+		if now.Add(timeout).After(e.token.Expiry()) && !now.Before(e.retryAt) {
+			due = append(due, r)
+		}
+	}
+	c.lock.RUnlock()
+
+	for _, r := range due {
+		if _, err := c.load(context.Background(), r); err != nil {
+			c.lock.Lock()
+			if e, ok := c.entries[r]; ok {
+				e.failures++
+				backoff := RefreshBackoffBase << min(e.failures-1, 16)
+				if backoff > interval {
+					backoff = interval
+				}
+				e.retryAt = time.Now().Add(backoff)
+				log.Log.Errorf("could not refresh token for %s, attempt %d, retrying in %s: %v",
+					r, e.failures, backoff, err)
+			}
+			c.lock.Unlock()
+			continue
+		}
+
+		c.lock.RLock()
+		expiry := c.entries[r].token.Expiry()
+		c.lock.RUnlock()
+		log.Log.Debugf("refreshed token for %s, expires at %s", r, expiry.Format(time.RFC3339))
+	}
+}
+
+// StartRefresh refreshes tokens every interval, with jitter, until stop is closed. Registries
+// with failed refreshes are retried sooner, per their backoff.
+func (c *CredentialCache) StartRefresh(timeout time.Duration, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+
+	for {
+		wait := c.next(interval)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		log.Log.Info("Waking up to refresh cached ECR creds")
+		c.Refresh(timeout, interval)
+	}
+}
+
+// next returns the jittered refresh interval, or the time to the earliest backoff retry if sooner
+func (c *CredentialCache) next(interval time.Duration) time.Duration {
+	jitter := time.Duration((rand.Float64()*2 - 1) * RefreshJitter * float64(interval))
+	wait := interval + jitter
+
+	now := time.Now()
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, e := range c.entries {
+		if e.failures > 0 && e.retryAt.Sub(now) < wait {
+			wait = e.retryAt.Sub(now)
+		}
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+
+	return wait
+}
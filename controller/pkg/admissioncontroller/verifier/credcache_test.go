@@ -0,0 +1,138 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+
+	"notary-admission/pkg/model"
+)
+
+const tokenRegistry = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+
+// testToken returns a token expiring at expiry
+func testToken(expiry time.Time) EcrAuthToken {
+	auth := "QVdTOnRva2Vu"
+	return EcrAuthToken{AuthData: types.AuthorizationData{AuthorizationToken: &auth, ExpiresAt: &expiry}}
+}
+
+// newTestCredentialCache returns a credential cache fetching tokens with fetch
+func newTestCredentialCache(fetch func(ctx context.Context, registry string) (EcrAuthToken, error)) *CredentialCache {
+	if model.ServerConfig() == nil {
+		model.SetServerConfig(&model.Config{})
+	}
+	return NewCredentialCache(fetch)
+}
+
+func TestCredentialCacheSharedFetch(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	c := newTestCredentialCache(func(ctx context.Context, _ string) (EcrAuthToken, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		if err := ctx.Err(); err != nil {
+			return EcrAuthToken{}, err
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			return EcrAuthToken{}, errors.New("fetch without timeout")
+		}
+		return testToken(time.Now().Add(time.Hour)), nil
+	})
+
+	first, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 5)
+	var wg sync.WaitGroup
+	get := func(ctx context.Context) {
+		defer wg.Done()
+		_, err := c.Get(ctx, tokenRegistry)
+		errs <- err
+	}
+
+	wg.Add(1)
+	go get(first)
+	<-started
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go get(context.Background())
+	}
+
+	// The first caller going away does not fail the fetch the others are waiting on
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Get: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+}
+
+func TestCredentialCacheRefreshBackoff(t *testing.T) {
+	var calls atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+
+	c := newTestCredentialCache(func(context.Context, string) (EcrAuthToken, error) {
+		calls.Add(1)
+		if fail.Load() {
+			return EcrAuthToken{}, errors.New("ThrottlingException")
+		}
+		return testToken(time.Now().Add(12 * time.Hour)), nil
+	})
+
+	expiring := time.Now().Add(time.Minute)
+	c.entries[tokenRegistry] = &credentialEntry{token: testToken(expiring)}
+
+	c.Refresh(5*time.Minute, time.Minute)
+	e := c.entries[tokenRegistry]
+	if calls.Load() != 1 || e.failures != 1 {
+		t.Fatalf("%d fetches, %d failures, want 1 and 1", calls.Load(), e.failures)
+	}
+	if d := time.Until(e.retryAt); d <= 0 || d > RefreshBackoffBase {
+		t.Errorf("retry in %s, want within %s", d, RefreshBackoffBase)
+	}
+	if d := c.next(time.Minute); d > RefreshBackoffBase {
+		t.Errorf("next refresh in %s, want within %s", d, RefreshBackoffBase)
+	}
+
+	// Registries are not retried before their backoff, and keep their token until it expires
+	c.Refresh(5*time.Minute, time.Minute)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d fetches during backoff, want 1", n)
+	}
+	token, err := c.Get(context.Background(), tokenRegistry)
+	if err != nil || !token.Expiry().Equal(expiring) {
+		t.Errorf("Get = %s, %v, want the cached token", token.Expiry(), err)
+	}
+
+	// Backoff is capped at the refresh interval
+	e.failures = 10
+	e.retryAt = time.Time{}
+	c.Refresh(5*time.Minute, time.Minute)
+	if d := time.Until(e.retryAt); d > time.Minute {
+		t.Errorf("retry in %s, want within the refresh interval", d)
+	}
+
+	fail.Store(false)
+	e.retryAt = time.Time{}
+	c.Refresh(5*time.Minute, time.Minute)
+	e = c.entries[tokenRegistry]
+	if e.failures != 0 || !e.token.Expiry().After(expiring) {
+		t.Errorf("refreshed entry has %d failures, expires at %s", e.failures, e.token.Expiry())
+	}
+}
@@ -0,0 +1,194 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cpv1 "k8s.io/kubelet/pkg/apis/credentialprovider/v1"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	CredentialProviderApiVersion = "credentialprovider.kubelet.k8s.io/v1"
+	CredentialProviderTimeout    = time.Minute
+	DefaultProviderCacheDuration = 5 * time.Minute
+)
+
+type providerCacheEntry struct {
+	keyring *Keyring
+	expires time.Time
+}
+
+// CredentialProvider executes a kubelet credential provider plugin, and caches the returned
+// auths per the response cacheKeyType and cacheDuration
+type CredentialProvider struct {
+	name            string
+	path            string
+	args            []string
+	env             []string
+	apiVersion      string
+	defaultDuration time.Duration
+	lock            sync.RWMutex
+	cache           map[string]providerCacheEntry
+	group           singleflight.Group
+}
+
+// NewCredentialProvider creates a CredentialProvider from verifier config
+func NewCredentialProvider(vc model.VerifierConfig) (*CredentialProvider, error) {
+	pc := vc.Auth.CredentialProvider
+
+	if !utils.FileExists(pc.Path) {
+		return nil, fmt.Errorf("credential provider %s not found: %s", pc.Name, pc.Path)
+	}
+
+	cp := CredentialProvider{
+		name:            pc.Name,
+		path:            pc.Path,
+		args:            pc.Args,
+		apiVersion:      pc.ApiVersion,
+		defaultDuration: DefaultProviderCacheDuration,
+		cache:           make(map[string]providerCacheEntry),
+	}
+
+	if cp.apiVersion == "" {
+		cp.apiVersion = CredentialProviderApiVersion
+	}
+
+	if pc.DefaultCacheDuration != "" {
+		d, err := time.ParseDuration(pc.DefaultCacheDuration)
+		if err != nil {
+			return nil, fmt.Errorf("malformed credential provider default cache duration: %w", err)
+		}
+		cp.defaultDuration = d
+	}
+
+	for _, e := range pc.Env {
+		cp.env = append(cp.env, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+
+	return &cp, nil
+}
+
+// Credentials returns the provider credentials for image, from cache or by executing the plugin
+func (c *CredentialProvider) Credentials(image string) ([]string, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+	registry := ref.Registry
+	repository := ref.Name()
+
+	// Lookup in kubelet order, image then registry then global
+	c.lock.RLock()
+	for _, k := range []string{repository, registry, ""} {
+		if e, ok := c.cache[k]; ok && time.Now().Before(e.expires) {
+			c.lock.RUnlock()
+			return c.lookup(e.keyring, image)
+		}
+	}
+	c.lock.RUnlock()
+
+	r, err, _ := c.group.Do(repository, func() (interface{}, error) {
+		return c.execute(image)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := r.(*cpv1.CredentialProviderResponse)
+	keyring := Keyring{auths: make(map[string]DockerAuth)}
+	for k, a := range response.Auth {
+		keyring.auths[k] = DockerAuth{Username: a.Username, Password: a.Password}
+	}
+
+	duration := c.defaultDuration
+	if response.CacheDuration != nil {
+		duration = response.CacheDuration.Duration
+	}
+
+	var key string
+	switch response.CacheKeyType {
+	case cpv1.ImagePluginCacheKeyType:
+		key = repository
+	case cpv1.RegistryPluginCacheKeyType:
+		key = registry
+	case cpv1.GlobalPluginCacheKeyType:
+		key = ""
+	default:
+		return nil, fmt.Errorf("credential provider %s returned invalid cacheKeyType: %s", c.name, response.CacheKeyType)
+	}
+
+	if duration > 0 {
+		c.lock.Lock()
+		c.cache[key] = providerCacheEntry{keyring: &keyring, expires: time.Now().Add(duration)}
+		c.lock.Unlock()
+	}
+
+	return c.lookup(&keyring, image)
+}
+
+// lookup returns the provider auth matching image. Images without auth are accessed
+// anonymously, as with kubelet.
+func (c *CredentialProvider) lookup(keyring *Keyring, image string) ([]string, error) {
+	creds, ok := keyring.Lookup(image)
+	if !ok {
+		log.Log.Debugf("credential provider %s returned no auth for %s, using anonymous access", c.name, image)
+		return []string{"", ""}, nil
+	}
+	return creds, nil
+}
+
+// execute runs the plugin with a CredentialProviderRequest on stdin
+func (c *CredentialProvider) execute(image string) (*cpv1.CredentialProviderResponse, error) {
+	request := cpv1.CredentialProviderRequest{
+		TypeMeta: meta.TypeMeta{
+			APIVersion: c.apiVersion,
+			Kind:       "CredentialProviderRequest",
+		},
+		Image: image,
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal credential provider request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CredentialProviderTimeout)
+	defer cancel()
+
+	var stderr, stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path, c.args...)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+
+	log.Log.Debugf("executing credential provider %s for %s", c.name, image)
+
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential provider %s failed: %s, %w", c.name, stderr.String(), err)
+	}
+
+	var response cpv1.CredentialProviderResponse
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("could not parse credential provider %s response: %w", c.name, err)
+	}
+
+	if response.APIVersion != c.apiVersion || response.Kind != "CredentialProviderResponse" {
+		return nil, fmt.Errorf("credential provider %s returned unexpected %s %s", c.name,
+			response.APIVersion, response.Kind)
+	}
+
+	return &response, nil
+}
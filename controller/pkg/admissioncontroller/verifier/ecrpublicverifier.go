@@ -0,0 +1,118 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/tracing"
+)
+
+const (
+	EcrPublicRegistry = "public.ecr.aws"
+	// EcrPublicRegion is the only region serving the ECR Public auth API
+	EcrPublicRegion = "us-east-1"
+)
+
+var ecrpvOnce sync.Once
+
+// EcrPublicVerifier verifies images in Amazon ECR Public, using ecr-public auth token credentials
+type EcrPublicVerifier struct {
+	Tokens *CredentialCache
+}
+
+// Ecrpv Singleton used to hold single instance
+var Ecrpv *EcrPublicVerifier
+
+// GetEcrpv creates singleton of EcrPublicVerifier
+func GetEcrpv() *EcrPublicVerifier {
+	ecrpvOnce.Do(func() {
+		e := &EcrPublicVerifier{}
+		e.Tokens = NewCredentialCache(e.getEcrPublicAuthToken)
+		Ecrpv = e
+	})
+
+	return Ecrpv
+}
+
+// getEcrPublicAuthToken gets an ECR Public auth token from IRSA config. ECR Public tokens are
+// not registry specific, so are cached under the ECR Public registry.
+func (e *EcrPublicVerifier) getEcrPublicAuthToken(ctx context.Context, registry string) (EcrAuthToken, error) {
+	ctx, span := tracing.Start(ctx, "verifier.getEcrPublicAuthToken", tracing.AttrRegistry.String(registry))
+	defer span.End()
+
+	cfg, err := loadIrsaConfig(ctx)
+	if err != nil {
+		return EcrAuthToken{}, err
+	}
+	cfg.Region = EcrPublicRegion
+
+	client := ecrpublic.NewFromConfig(cfg)
+	out, err := client.GetAuthorizationToken(ctx, &ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Log.Errorf("Error getting ECR Public Auth Token: %v", err)
+		return EcrAuthToken{}, fmt.Errorf("could not retrieve ECR Public auth token: %w", err)
+	}
+
+	if out.AuthorizationData == nil || out.AuthorizationData.AuthorizationToken == nil ||
+		out.AuthorizationData.ExpiresAt == nil {
+		return EcrAuthToken{}, fmt.Errorf("no ECR Public auth token returned")
+	}
+
+	endpoint := "https://" + registry
+
+	return EcrAuthToken{AuthData: types.AuthorizationData{
+		AuthorizationToken: out.AuthorizationData.AuthorizationToken,
+		ExpiresAt:          out.AuthorizationData.ExpiresAt,
+		ProxyEndpoint:      &endpoint,
+	}}, nil
+}
+
+// StartRefresh refreshes the cached ECR Public creds in the background, until stop is closed
+func (e *EcrPublicVerifier) StartRefresh(stop <-chan struct{}) {
+	cc := model.ServerConfig().Ecr.CredentialCache
+	e.Tokens.StartRefresh(time.Duration(cc.CacheTimeoutInterval)*time.Second,
+		time.Duration(cc.CacheRefreshInterval)*time.Second, stop)
+}
+
+// Name returns the verifier name
+func (e *EcrPublicVerifier) Name() string {
+	return model.VerifierTypeEcrPublic
+}
+
+// Verify verifies image using ECR Public auth token credentials
+func (e *EcrPublicVerifier) Verify(ctx context.Context, image string, creds []string, policy *Policy) (Response, error) {
+	if creds != nil {
+		return verifyImage(ctx, image, creds, policy), nil
+	}
+
+	creds, err := e.Credentials(ctx, image)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+
+	return verifyImage(ctx, image, creds, policy), nil
+}
+
+// Credentials returns the ECR Public auth token credentials
+func (e *EcrPublicVerifier) Credentials(ctx context.Context, image string) ([]string, error) {
+	token, err := e.Tokens.Get(ctx, EcrPublicRegistry)
+	if err != nil {
+		return nil, fmt.Errorf("could not get ECR Public token: %w", err)
+	}
+
+	creds, err := token.BasicAuthCreds()
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ECR Public token: %w", err)
+	}
+
+	return creds, nil
+}
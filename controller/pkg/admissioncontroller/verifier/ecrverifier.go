@@ -12,8 +12,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	log "notary-admission/pkg/logging"
-	"notary-admission/pkg/notation"
+	"notary-admission/pkg/tracing"
 	"notary-admission/pkg/utils"
 	"os"
 	"strings"
@@ -21,12 +23,10 @@ import (
 )
 
 const (
-	Session         = "IRSA_CREDS_SESSION"
-	EcrPattern      = "<ACCOUNT>.dkr.ecr.<REGION>.amazonaws.com"
-	MsgVerifyBypass = "image verification bypassed"
+	Session = "IRSA_CREDS_SESSION"
 )
 
-var lock = &sync.Mutex{}
+var ecrvOnce sync.Once
 
 // EcrAuthToken provides helper functions for ECR auth token data
 type EcrAuthToken struct {
@@ -49,7 +49,7 @@ func (e EcrAuthToken) BasicAuthCreds() ([]string, error) {
 }
 
 type EcrVerifier struct {
-	Tokens map[string]EcrAuthToken
+	Tokens *CredentialCache
 	Error  error
 }
 
@@ -58,14 +58,11 @@ var Ecrv *EcrVerifier
 
 // GetEcrv creates singleton of EcrVerifier
 func GetEcrv() *EcrVerifier {
-	if Ecrv == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		//if Ecrv == nil {
-		Ecrv = &EcrVerifier{}
-		Ecrv.Tokens = make(map[string]EcrAuthToken)
-		//}
-	}
+	ecrvOnce.Do(func() {
+		e := &EcrVerifier{}
+		e.Tokens = NewCredentialCache(e.getEcrAuthToken)
+		Ecrv = e
+	})
 
 	return Ecrv
 }
@@ -73,70 +70,139 @@ func GetEcrv() *EcrVerifier {
 // LoadPreAuthRegistries loads registries to be pre-authorized, needed for cross region access
 func (e *EcrVerifier) LoadPreAuthRegistries() error {
 	// Pre-auth registries
-	for _, r := range model.ServerConfig.Ecr.CredentialCache.PreAuthRegistries {
-		err := Ecrv.getEcrAuthToken(r)
-		if err != nil {
+	for _, r := range model.ServerConfig().Ecr.CredentialCache.PreAuthRegistries {
+		if _, err := e.Tokens.Get(context.Background(), r); err != nil {
 			return err
 		}
 	}
 
-	r := strings.Replace(strings.Replace(EcrPattern, "<ACCOUNT>",
-		model.ServerConfig.AwsAccountId, 1), "<REGION>", model.ServerConfig.AwsRegion, 1)
+	r := utils.EcrRegistryHost(model.ServerConfig().AwsAccountId, model.ServerConfig().AwsRegion)
 
 	log.Log.Debugf("Derived registry = %s", r)
 
-	if _, ok := Ecrv.Tokens[r]; !ok {
-		// Get ECR token for registry
-		err := Ecrv.getEcrAuthToken(r)
-		if err != nil {
-			return err
-		}
+	// Get ECR token for registry
+	if _, err := e.Tokens.Get(context.Background(), r); err != nil {
+		return err
 	}
 
-	log.Log.Debugf("Mapped ECR auth tokens: %+v", Ecrv.Tokens)
+	log.Log.Debugf("Cached ECR auth tokens: %v", e.Tokens.Registries())
 
 	return nil
 }
 
-// RefreshCredsCache refreshes the cached ECR creds
-func (e *EcrVerifier) RefreshCredsCache() error {
-	for k, v := range Ecrv.Tokens {
-		t := time.Now().Add(time.Second * time.Duration(model.ServerConfig.Ecr.CredentialCache.CacheTimeoutInterval))
-		if t.After(v.Expiry()) || time.Now().After(v.Expiry()) {
-			err := Ecrv.getEcrAuthToken(k)
-			if err != nil {
-				return err
-			}
-			log.Log.Debugf("refreshed creds for %s", k)
-		}
-		log.Log.Debugf("creds for %s expires at %s", k, v.Expiry().Format(time.RFC3339))
-
-	}
-	return nil
+// StartRefresh refreshes cached ECR creds in the background, until stop is closed
+func (e *EcrVerifier) StartRefresh(stop <-chan struct{}) {
+	cc := model.ServerConfig().Ecr.CredentialCache
+	e.Tokens.StartRefresh(time.Duration(cc.CacheTimeoutInterval)*time.Second,
+		time.Duration(cc.CacheRefreshInterval)*time.Second, stop)
 }
 
 // getEcrAuthToken get ECR auth token from IAM Roles for Service Account (IRSA) config
-func (e *EcrVerifier) getEcrAuthToken(registry string) error {
+func (e *EcrVerifier) getEcrAuthToken(ctx context.Context, registry string) (EcrAuthToken, error) {
 	podName := os.Getenv("POD_NAME")
 	podNamespace := os.Getenv("POD_NAMESPACE")
-	region := model.ServerConfig.AwsRegion
-	roleArn := model.ServerConfig.AwsRole
-	tokenFilePath := model.ServerConfig.AwsTokenFilePath
+
+	ctx, span := tracing.Start(ctx, "verifier.getEcrAuthToken", tracing.AttrRegistry.String(registry))
+	defer span.End()
+
+	cfg, err := loadIrsaConfig(ctx)
+	if err != nil {
+		return EcrAuthToken{}, err
+	}
+
+	er, err := utils.ParseEcrRegistry(registry)
+	if err != nil {
+		return EcrAuthToken{}, err
+	}
+	cfg.Region = er.Region
+
+	// VPC endpoint DNS names have no account, so it is mapped from the endpoint ID
+	if er.Account == "" {
+		account, ok := model.ServerConfig().Ecr.VpceAccounts[er.VpcEndpoint]
+		if !ok {
+			err = fmt.Errorf("ECR VPC endpoint registry %s requires an ecr.vpceAccounts account for %s",
+				registry, er.VpcEndpoint)
+			tracing.Fail(span, err)
+			return EcrAuthToken{}, err
+		}
+		er.Account = account
+	}
+
+	// Scope the token to the registry account, assuming the account role if configured
+	input := ecr.GetAuthorizationTokenInput{RegistryIds: []string{er.Account}}
+	if accountRole, ok := model.ServerConfig().Ecr.AccountRoles[er.Account]; ok {
+		log.Log.Debugf("assuming %s for %s ECR auth", accountRole, er.Account)
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg),
+			accountRole, func(options *stscreds.AssumeRoleOptions) {
+				options.RoleSessionName = Session
+			}))
+	}
+
+	// Use the API endpoint variant of the registry endpoint
+	ecrClient := ecr.NewFromConfig(cfg, func(options *ecr.Options) {
+		if er.Fips {
+			options.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		}
+		if er.Variant == utils.EcrVariantDualStack {
+			options.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+		}
+	})
+	authOutput, err := ecrClient.GetAuthorizationToken(ctx, &input)
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Log.Errorf("Error getting ECR Auth Token for %s: %v", registry, err)
+		return EcrAuthToken{}, fmt.Errorf("could not retrieve ECR auth token collection: %w", err)
+	}
+
+	authData, err := authDataForRegistry(authOutput.AuthorizationData, registry)
+	if err != nil {
+		return EcrAuthToken{}, err
+	}
+
+	log.Log.Debugf("ECR auth enabled with IRSA - %s pod in the %s namespace",
+		podName, podNamespace)
+
+	return EcrAuthToken{AuthData: authData}, nil
+}
+
+// authDataForRegistry returns the auth data with a proxy endpoint matching registry,
+// or the only auth data returned
+func authDataForRegistry(data []types.AuthorizationData, registry string) (types.AuthorizationData, error) {
+	for _, d := range data {
+		if d.ProxyEndpoint == nil {
+			continue
+		}
+		if strings.TrimPrefix(*d.ProxyEndpoint, "https://") == registry {
+			return d, nil
+		}
+	}
+
+	if len(data) == 1 {
+		return data[0], nil
+	}
+
+	return types.AuthorizationData{}, fmt.Errorf("no ECR auth token returned for %s", registry)
+}
+
+// loadIrsaConfig loads the AWS config from IAM Roles for Service Account (IRSA) config,
+// with the API override endpoint, if any
+func loadIrsaConfig(ctx context.Context) (aws.Config, error) {
+	region := model.ServerConfig().AwsRegion
+	roleArn := model.ServerConfig().AwsRole
+	tokenFilePath := model.ServerConfig().AwsTokenFilePath
 	apiOverrideEndpoint := os.Getenv("AWS_API_OVERRIDE_ENDPOINT")
 	apiOverridePartition := os.Getenv("AWS_API_OVERRIDE_PARTITION")
 	apiOverrideRegion := os.Getenv("AWS_API_OVERRIDE_REGION")
 
 	// Verify IRSA ENV is present
 	if region == "" || roleArn == "" || tokenFilePath == "" {
-		return fmt.Errorf("required environment variables not set, AWS_REGION: %s, AWS_ROLE_ARN: %s, AWS_WEB_IDENTITY_TOKEN_FILE: %s", region, roleArn, tokenFilePath)
+		return aws.Config{}, fmt.Errorf("required environment variables not set, AWS_REGION: %s, AWS_ROLE_ARN: %s, AWS_WEB_IDENTITY_TOKEN_FILE: %s", region, roleArn, tokenFilePath)
 	}
 	log.Log.Debugf("AWS_REGION: %s, AWS_ROLE_ARN: %s, AWS_WEB_IDENTITY_TOKEN_FILE: %s", region, roleArn, tokenFilePath)
 
-	ctx := context.Background()
-
 	// Custom resolver in case custom endpoints are used
 	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		if service == ecr.ServiceID && region == apiOverrideRegion {
+		if (service == ecr.ServiceID || service == ecrpublic.ServiceID) && region == apiOverrideRegion {
 			log.Log.Debug("AWS ECR basic auth using custom endpoint resolver...")
 			log.Log.Debugf("AWS ECR basic auth API override endpoint: %s", apiOverrideEndpoint)
 			log.Log.Debugf("AWS ECR basic auth API override partition: %s", apiOverridePartition)
@@ -158,129 +224,45 @@ func (e *EcrVerifier) getEcrAuthToken(registry string) error {
 
 	if err != nil {
 		log.Log.Errorf("Error getting cfg: %v", err)
-		return fmt.Errorf("failed to load default AWS basic auth config: %w", err)
+		return aws.Config{}, fmt.Errorf("failed to load default AWS basic auth config: %w", err)
 	}
 
-	//log.Log.Debugf("registry=%s", registry)
+	return cfg, nil
+}
 
-	cfg.Region = utils.RegionFromRegistry(registry)
-	ecrClient := ecr.NewFromConfig(cfg)
-	authOutput, err := ecrClient.GetAuthorizationToken(ctx, nil)
+// Name returns the verifier name
+func (e *EcrVerifier) Name() string {
+	return model.VerifierTypeEcr
+}
 
-	//input := ecr.GetAuthorizationTokenInput{}
-	//input.RegistryIds = []string{ecrRegistry}
-	//authOutput, err := ecrClient.GetAuthorizationToken(ctx, &input)
+// Verify verifies image using ECR auth token credentials
+func (e *EcrVerifier) Verify(ctx context.Context, image string, creds []string, policy *Policy) (Response, error) {
+	if creds != nil {
+		return verifyImage(ctx, image, creds, policy), nil
+	}
 
+	creds, err := e.Credentials(ctx, image)
 	if err != nil {
-		log.Log.Errorf("Error getting ECR Auth Token for %s: %v", registry, err)
-		return fmt.Errorf("could not retrieve ECR auth token collection: %w", err)
+		log.Log.Error(err)
+		return Response{}, err
 	}
 
-	e.Tokens[registry] = EcrAuthToken{AuthData: authOutput.AuthorizationData[0]}
-
-	log.Log.Debugf("ECR auth enabled with IRSA - %s pod in the %s namespace",
-		podName, podNamespace)
-
-	return nil
-}
-
-//type Subjects struct {
-//	Images []string
-//}
-
-type Response struct {
-	ErrorMessage string
-	Error        error
-	Message      string
-	Image        string
-	ByPassed     bool
-	Warning      string
+	return verifyImage(ctx, image, creds, policy), nil
 }
 
-type Verification struct {
-	Responses []Response
-	Message   string
-	Error     error
-}
-
-// VerifySubjects verifies images (subjects)
-func (e *EcrVerifier) VerifySubjects(images []string) Verification {
-	ecrv := GetEcrv()
-	v := Verification{}
-
-	for _, i := range images {
-		response := Response{}
-		registry := utils.RegistryFromImage(i)
-		if _, ok := model.BypassRegistries[registry]; ok {
-			// bypass image signature verification
-			log.Log.Infof("image %s verification was bypassed", i)
-			response.Image = i
-			response.ByPassed = true
-			response.Warning = i + " - " + MsgVerifyBypass
-			v.Responses = append(v.Responses, response)
-			continue
-		}
-
-		if _, ok := ecrv.Tokens[registry]; !ok {
-			// Get ECR token for registry
-			err := ecrv.getEcrAuthToken(registry)
-			if err != nil {
-				errMsg := fmt.Errorf("could not get ECR token for %s: %w", registry, err)
-				log.Log.Error(errMsg)
-				v.Error = errMsg
-				v.Message = errMsg.Error()
-				return v
-			}
-		}
-
-		//rawDecodedText, err := base64.StdEncoding.DecodeString(*ecrv.Tokens[registry].AuthData.AuthorizationToken)
-		creds, err := ecrv.Tokens[registry].BasicAuthCreds()
-		if err != nil {
-			errMsg := fmt.Errorf("could not decode ECR token: %w", err)
-			log.Log.Error(errMsg)
-			v.Error = errMsg
-			v.Message = errMsg.Error()
-			return v
-		}
-
-		log.Log.Debugf("Decoded ECR token: %v", creds)
-
-		//creds := strings.Split(string(rawDecodedText), ":")
-
-		nc := notation.Command{}
-		args := []string{model.ServerConfig.Notation.VerifyCommand, "-u", creds[0], "-p", creds[1]}
-
-		nc.Subject = i
-
-		//if model.ServerConfig.Notation.Mode == model.BinaryMode {
-		args = append(args, i)
-
-		if model.ServerConfig.Notation.DebugEnabled {
-			args = append(args, model.ServerConfig.Notation.DebugFlag)
-		}
+// Credentials returns the ECR auth token credentials for the image registry
+func (e *EcrVerifier) Credentials(ctx context.Context, image string) ([]string, error) {
+	registry := utils.RegistryFromImage(image)
 
-		if model.ServerConfig.Notation.SignerEndpoint != "" {
-			args = append(args, "--plugin-config", fmt.Sprintf("signer-endpoint-url=%s",
-				model.ServerConfig.Notation.SignerEndpoint))
-		}
-
-		if model.ServerConfig.Notation.SignerDebug {
-			args = append(args, "--plugin-config", "debug=true")
-		}
-
-		nc.Args = args
-
-		nc.Execute()
-
-		response.Image = nc.Subject
-		response.ErrorMessage = nc.Err
-		response.Error = nc.Error
-		v.Responses = append(v.Responses, response)
+	token, err := e.Tokens.Get(ctx, registry)
+	if err != nil {
+		return nil, fmt.Errorf("could not get ECR token for %s: %w", registry, err)
+	}
 
-		if response.Error != nil {
-			return v
-		}
+	creds, err := token.BasicAuthCreds()
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ECR token: %w", err)
 	}
 
-	return v
+	return creds, nil
 }
@@ -0,0 +1,214 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	notationgo "github.com/notaryproject/notation-go"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	"notary-admission/pkg/cosign"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	ResultVerified = "verified"
+	ResultFailed   = "failed"
+	ResultBypassed = "bypassed"
+	ResultError    = "error"
+
+	// Failed verification reasons, the image signatures did not verify
+	ReasonSignatureInvalid  = "signature_invalid"
+	ReasonSignatureNotFound = "signature_not_found"
+	ReasonInconclusive      = "verification_inconclusive"
+	ReasonNoTrustPolicy     = "no_trust_policy"
+
+	// Errored verification reasons, verification could not be attempted
+	ReasonThrottled    = "throttled"
+	ReasonUnauthorized = "unauthorized"
+	ReasonNotFound     = "not_found"
+	ReasonTimeout      = "timeout"
+	ReasonRegistry     = "registry"
+	ReasonUnknown      = "unknown"
+
+	SignaturesLabelNotation = "notation"
+	SignaturesLabelCosign   = "cosign"
+
+	// RegistryLabelOther is the registry label of images matching no verifier, or bypass, registry
+	RegistryLabelOther = "other"
+)
+
+var (
+	metricsOnce  sync.Once
+	verifyMetric *metrics.PrometheusVerifierMetric
+	tokenMetric  *metrics.PrometheusTokenMetric
+
+	cachesLock sync.Mutex
+	caches     []*CredentialCache
+
+	// throttleCodes are the AWS API error codes of throttled requests
+	throttleCodes = map[string]bool{
+		"ThrottlingException":      true,
+		"Throttling":               true,
+		"TooManyRequestsException": true,
+		"RequestLimitExceeded":     true,
+	}
+	// unauthorizedCodes are the AWS API error codes of unauthenticated, or unauthorized, requests
+	unauthorizedCodes = map[string]bool{
+		"AccessDenied":                true,
+		"AccessDeniedException":       true,
+		"UnrecognizedClientException": true,
+		"ExpiredToken":                true,
+		"ExpiredTokenException":       true,
+		"InvalidIdentityToken":        true,
+	}
+)
+
+// initMetrics creates the verification and token metrics, once
+func initMetrics() {
+	metricsOnce.Do(func() {
+		prefix := model.ServerConfig().Prometheus.Name
+		verifyMetric = metrics.InitPrometheusVerifierMetric(prefix)
+		tokenMetric = metrics.InitPrometheusTokenMetric(prefix, tokenExpiries)
+	})
+}
+
+// registerCache adds c to the credential caches whose token expiries are reported
+func registerCache(c *CredentialCache) {
+	initMetrics()
+
+	cachesLock.Lock()
+	defer cachesLock.Unlock()
+	caches = append(caches, c)
+}
+
+// tokenExpiries returns the expiry of the cached token of each registry
+func tokenExpiries() map[string]time.Time {
+	cachesLock.Lock()
+	defer cachesLock.Unlock()
+
+	expiries := make(map[string]time.Time)
+	for _, c := range caches {
+		for r, t := range c.Expiries() {
+			expiries[r] = t
+		}
+	}
+	return expiries
+}
+
+// observeVerification records the verification time of digest reference ref with signature format signatures
+func observeVerification(ref string, signatures string, start time.Time) {
+	initMetrics()
+	verifyMetric.Duration.WithLabelValues(registryLabel(ref), signatures).
+		Observe(time.Since(start).Seconds())
+}
+
+// recordOutcome counts the verification of image by result, and failures by reason
+func recordOutcome(image string, r Response, err error) {
+	initMetrics()
+
+	registry := registryLabel(image)
+	result, reason := outcome(r, err)
+
+	verifyMetric.Verifications.WithLabelValues(registry, result).Inc()
+	if reason != "" {
+		verifyMetric.Failures.WithLabelValues(registry, reason).Inc()
+	}
+}
+
+// registryLabel returns the registry label of image, bounded by configuration, as images come from
+// pod specs: the registry pattern of its verifier, its registry if bypassed, or other
+func registryLabel(image string) string {
+	return registryHostLabel(utils.RegistryFromImage(image))
+}
+
+// registryHostLabel returns the registry label of registry, bounded by configuration
+func registryHostLabel(registry string) string {
+	if _, ok := model.ServerConfig().BypassRegistries[registry]; ok {
+		return registry
+	}
+	if _, p, ok := lookup(registry); ok {
+		return p
+	}
+	return RegistryLabelOther
+}
+
+// outcome returns the result of a verification, and the reason it failed or errored, if it did
+func outcome(r Response, err error) (string, string) {
+	if err != nil {
+		return ResultError, failureReason(err, "")
+	}
+	if r.ByPassed {
+		return ResultBypassed, ""
+	}
+	if r.Error == nil {
+		return ResultVerified, ""
+	}
+
+	reason := failureReason(r.Error, r.ErrorMessage)
+	switch reason {
+	case ReasonSignatureInvalid, ReasonSignatureNotFound, ReasonInconclusive, ReasonNoTrustPolicy:
+		return ResultFailed, reason
+	default:
+		return ResultError, reason
+	}
+}
+
+// failureReason classifies a verification, or token fetch, error. Throttling is checked first, as
+// notation reports registry errors as signature retrieval failures. Binary mode errors, and errors
+// notation does not wrap, are classified by message.
+func failureReason(err error, msg string) string {
+	text := strings.ToLower(err.Error() + " " + msg)
+
+	var api smithy.APIError
+	var res *errcode.ErrorResponse
+	hasApi := errors.As(err, &api)
+	hasRes := errors.As(err, &res)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || strings.Contains(text, "deadline exceeded") ||
+		strings.Contains(text, "timeout"):
+		return ReasonTimeout
+	case hasApi && throttleCodes[api.ErrorCode()],
+		hasRes && res.StatusCode == http.StatusTooManyRequests,
+		strings.Contains(text, "toomanyrequests"), strings.Contains(text, "too many requests"),
+		strings.Contains(text, "throttl"), strings.Contains(text, "rate exceeded"):
+		return ReasonThrottled
+	case hasApi && unauthorizedCodes[api.ErrorCode()],
+		hasRes && (res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden),
+		strings.Contains(text, "unauthorized"), strings.Contains(text, "forbidden"),
+		strings.Contains(text, "access denied"):
+		return ReasonUnauthorized
+	}
+
+	var np notationgo.ErrorNoApplicableTrustPolicy
+	var vi notationgo.ErrorVerificationInconclusive
+	var vf notationgo.ErrorVerificationFailed
+	var sr notationgo.ErrorSignatureRetrievalFailed
+	var cf cosign.ErrorVerificationFailed
+
+	switch {
+	case errors.As(err, &np), strings.Contains(text, "no applicable trust policy"):
+		return ReasonNoTrustPolicy
+	case strings.Contains(text, "no signature is associated"), strings.Contains(text, "no cosign signature found"):
+		return ReasonSignatureNotFound
+	case errors.As(err, &vi):
+		return ReasonInconclusive
+	case errors.As(err, &vf), errors.As(err, &cf), strings.Contains(text, "signature verification failed"):
+		return ReasonSignatureInvalid
+	case errors.Is(err, errdef.ErrNotFound), hasRes && res.StatusCode == http.StatusNotFound:
+		return ReasonNotFound
+	case errors.As(err, &sr), hasRes:
+		return ReasonRegistry
+	default:
+		return ReasonUnknown
+	}
+}
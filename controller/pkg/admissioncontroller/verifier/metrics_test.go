@@ -0,0 +1,38 @@
+package verifier
+
+import (
+	"testing"
+
+	"notary-admission/pkg/model"
+)
+
+func TestRegistryHostLabel(t *testing.T) {
+	useVerifier(t, &fakeVerifier{}, 1)
+	model.ServerConfig().BypassRegistries = map[string]string{"registry.k8s.io": "registry.k8s.io"}
+
+	regLock.Lock()
+	registrations = []registration{
+		{patterns: []string{EcrPublicRegistry}, verifier: &fakeVerifier{}, policy: DefaultPolicy},
+		{patterns: []string{"*.dkr.ecr.*.amazonaws.com"}, verifier: &fakeVerifier{}, policy: DefaultPolicy},
+	}
+	regLock.Unlock()
+
+	tests := []struct {
+		registry string
+		want     string
+	}{
+		{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", want: "*.dkr.ecr.*.amazonaws.com"},
+		{registry: "210987654321.dkr.ecr.eu-west-1.amazonaws.com", want: "*.dkr.ecr.*.amazonaws.com"},
+		{registry: EcrPublicRegistry, want: EcrPublicRegistry},
+		{registry: "registry.k8s.io", want: "registry.k8s.io"},
+		{registry: "ghcr.io", want: RegistryLabelOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			if got := registryHostLabel(tt.registry); got != tt.want {
+				t.Errorf("registryHostLabel(%q) = %q, want %q", tt.registry, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package verifier
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+	"strings"
+)
+
+// DockerConfig stores the auths of a docker config.json file
+type DockerConfig struct {
+	Auths map[string]DockerAuth `json:"auths"`
+}
+
+// DockerAuth stores a single docker config.json auth entry
+type DockerAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// BasicAuthCreds provides the auth entry credentials in a slice
+func (d DockerAuth) BasicAuthCreds() ([]string, error) {
+	if d.Auth == "" {
+		return []string{d.Username, d.Password}, nil
+	}
+
+	rawDecodedAuth, err := base64.StdEncoding.DecodeString(d.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode docker config auth: %w", err)
+	}
+
+	creds := strings.SplitN(string(rawDecodedAuth), ":", 2)
+	if len(creds) != 2 {
+		return nil, fmt.Errorf("malformed docker config auth")
+	}
+
+	return creds, nil
+}
+
+// OciVerifier verifies images in generic OCI registries, using static, docker config,
+// or kubelet credential provider plugin credentials
+type OciVerifier struct {
+	name     string
+	creds    []string
+	config   *DockerConfig
+	provider *CredentialProvider
+}
+
+// NewOciVerifier creates an OciVerifier from verifier config
+func NewOciVerifier(vc model.VerifierConfig) (*OciVerifier, error) {
+	ov := OciVerifier{name: vc.Name}
+
+	switch {
+	case vc.Auth.CredentialProvider.Path != "":
+		cp, err := NewCredentialProvider(vc)
+		if err != nil {
+			return nil, err
+		}
+		ov.provider = cp
+	case vc.Auth.DockerConfig != "":
+		b, err := utils.ReadFile(vc.Auth.DockerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not read docker config: %w", err)
+		}
+
+		var dc DockerConfig
+		if err = json.Unmarshal(b, &dc); err != nil {
+			return nil, fmt.Errorf("could not parse docker config: %w", err)
+		}
+		ov.config = &dc
+	case vc.Auth.PasswordFile != "":
+		b, err := utils.ReadFile(vc.Auth.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read password file: %w", err)
+		}
+		ov.creds = []string{vc.Auth.Username, strings.TrimSpace(string(b))}
+	default:
+		ov.creds = []string{vc.Auth.Username, vc.Auth.Password}
+	}
+
+	return &ov, nil
+}
+
+// Name returns the verifier name
+func (o *OciVerifier) Name() string {
+	return o.name
+}
+
+// Verify verifies image using the configured credentials
+func (o *OciVerifier) Verify(ctx context.Context, image string, creds []string, policy *Policy) (Response, error) {
+	if creds != nil {
+		return verifyImage(ctx, image, creds, policy), nil
+	}
+
+	creds, err := o.Credentials(ctx, image)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+
+	return verifyImage(ctx, image, creds, policy), nil
+}
+
+// Credentials returns the credential provider or static credentials, or the docker config
+// auth entry for the image registry. Registries without credentials are accessed anonymously.
+func (o *OciVerifier) Credentials(ctx context.Context, image string) ([]string, error) {
+	creds, err := o.credentials(image)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s credentials for %s: %w", o.name, image, err)
+	}
+	return creds, nil
+}
+
+// credentials returns the configured credentials for image
+func (o *OciVerifier) credentials(image string) ([]string, error) {
+	if o.provider != nil {
+		return o.provider.Credentials(image)
+	}
+
+	if o.config == nil {
+		return o.creds, nil
+	}
+
+	registry := utils.RegistryFromImage(image)
+
+	for _, k := range []string{registry, "https://" + registry, "http://" + registry} {
+		if a, ok := o.config.Auths[k]; ok {
+			return a.BasicAuthCreds()
+		}
+	}
+
+	log.Log.Debugf("no docker config auth for %s, using anonymous access", registry)
+	return []string{"", ""}, nil
+}
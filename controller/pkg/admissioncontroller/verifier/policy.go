@@ -0,0 +1,174 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"notary-admission/pkg/cosign"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/tracing"
+	"notary-admission/pkg/utils"
+)
+
+// Policy decides which signature formats are required of the images of a verifier's registries,
+// where their signatures are looked up, and the notation trust policy they are verified with
+type Policy struct {
+	name        string
+	signatures  string
+	cosign      *cosign.Verifier
+	sigRepos    []model.SignatureRepository
+	trustPolicy string
+}
+
+// DefaultPolicy requires notation signatures
+var DefaultPolicy = &Policy{name: "default", signatures: model.SignaturesNotation}
+
+// NewPolicy creates a Policy from verifier config
+func NewPolicy(vc model.VerifierConfig) (*Policy, error) {
+	p := Policy{name: vc.Name, signatures: vc.Signatures}
+
+	for _, m := range vc.SignatureRepositories {
+		if m.Prefix == "" || m.Repository == "" {
+			return nil, fmt.Errorf("verifier %s signature repositories require prefix and repository", vc.Name)
+		}
+		prefix, err := normalizePrefix(m.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("verifier %s has malformed signature repository prefix %s: %w", vc.Name, m.Prefix, err)
+		}
+		m.Prefix = prefix
+		m.Repository = strings.TrimSuffix(m.Repository, "/")
+		p.sigRepos = append(p.sigRepos, m)
+	}
+
+	switch p.signatures {
+	case "":
+		p.signatures = model.SignaturesNotation
+		return &p, nil
+	case model.SignaturesNotation:
+		return &p, nil
+	case model.SignaturesCosign, model.SignaturesEither:
+		cv, err := cosign.NewVerifier(vc.Cosign)
+		if err != nil {
+			return nil, fmt.Errorf("could not create %s cosign verifier: %w", vc.Name, err)
+		}
+		p.cosign = cv
+		return &p, nil
+	default:
+		return nil, fmt.Errorf("verifier %s has unsupported signatures: %s", vc.Name, vc.Signatures)
+	}
+}
+
+// normalizePrefix normalizes a signature repository prefix as image names are, so nginx is
+// docker.io/library/nginx. A prefix without a repository, such as registry.example.com, is a registry.
+func normalizePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.Contains(prefix, "/") && (strings.ContainsAny(prefix, ".:") || prefix == "localhost") {
+		return prefix, nil
+	}
+
+	ref, err := utils.ParseImageReference(prefix)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" || strings.HasSuffix(prefix, ":"+ref.Tag) {
+		return "", fmt.Errorf("prefix must not have a tag or digest")
+	}
+
+	return ref.Name(), nil
+}
+
+// WithTrustPolicy returns a copy of the policy using the named notation trust policy
+func (p *Policy) WithTrustPolicy(name string) *Policy {
+	c := *p
+	c.trustPolicy = name
+	return &c
+}
+
+// cacheKey scopes cached verifications of digest reference to the policy signature formats
+// and trust policy
+func (p *Policy) cacheKey(ref string) string {
+	key := ref
+	if p.signatures != model.SignaturesNotation || len(p.sigRepos) > 0 {
+		key = key + "|" + p.name + "|" + p.signatures
+	}
+	if p.trustPolicy != "" {
+		key = key + "|" + p.trustPolicy
+	}
+	return key
+}
+
+// SignatureReference returns the digest reference where the signatures of image are looked up,
+// in the signature repository mapped to the image repository, if any. Prefixes match whole
+// repository path components, so a/b matches a/b and a/b/c, but not a/bc.
+func (p *Policy) SignatureReference(image string, digest string) (string, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	name := ref.Name()
+	for _, m := range p.sigRepos {
+		if name == m.Prefix || strings.HasPrefix(name, m.Prefix+"/") {
+			sigRef := m.Repository + strings.TrimPrefix(name, m.Prefix) + "@" + digest
+			if _, err = utils.ParseImageReference(sigRef); err != nil {
+				return "", fmt.Errorf("malformed signature repository for %s: %w", image, err)
+			}
+			return sigRef, nil
+		}
+	}
+
+	return ref.WithDigest(digest), nil
+}
+
+// verify verifies the digest reference with the required signature formats.
+// With either, cosign is only verified if notation verification fails.
+func (p *Policy) verify(ctx context.Context, ref string, creds []string) Response {
+	switch p.signatures {
+	case model.SignaturesCosign:
+		return p.verifyCosign(ctx, ref, creds)
+	case model.SignaturesEither:
+		r := verify(ctx, ref, creds, p.trustPolicy)
+		if r.Error == nil {
+			return r
+		}
+
+		c := p.verifyCosign(ctx, ref, creds)
+		if c.Error == nil {
+			log.Log.Debugf("%s notation verification failed, cosign verification succeeded", ref)
+			return c
+		}
+
+		c.Error = errors.Join(r.Error, c.Error)
+		c.ErrorMessage = c.Error.Error()
+		return c
+	default:
+		return verify(ctx, ref, creds, p.trustPolicy)
+	}
+}
+
+// verifyCosign verifies the cosign signatures of the digest reference
+func (p *Policy) verifyCosign(ctx context.Context, ref string, creds []string) Response {
+	response := Response{Image: ref}
+
+	defer observeVerification(ref, SignaturesLabelCosign, time.Now())
+
+	ctx, span := tracing.Start(ctx, "cosign.verify", semconv.ContainerImageName(ref))
+	defer span.End()
+
+	signer, err := p.cosign.Verify(ctx, ref, creds[0], creds[1])
+	if err != nil {
+		tracing.Fail(span, err)
+		response.Error = err
+		response.ErrorMessage = err.Error()
+		return response
+	}
+	response.Signer = signer
+
+	return response
+}
@@ -0,0 +1,58 @@
+package verifier
+
+import (
+	"testing"
+
+	"notary-admission/pkg/model"
+)
+
+func TestSignatureReference(t *testing.T) {
+	const (
+		digest   = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		registry = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	)
+
+	p, err := NewPolicy(model.VerifierConfig{Name: "ecr", SignatureRepositories: []model.SignatureRepository{
+		{Prefix: registry + "/docker-hub/", Repository: "docker.io/"},
+		{Prefix: registry + "/apps/payments", Repository: registry + "/signatures/payments"},
+		{Prefix: "nginx", Repository: "registry.example.com/signatures/nginx"},
+	}})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: registry + "/docker-hub/library/nginx:1.27", want: "docker.io/library/nginx@" + digest},
+		{image: registry + "/apps/payments:v1", want: registry + "/signatures/payments@" + digest},
+		{image: registry + "/apps/payments/api:v1", want: registry + "/signatures/payments/api@" + digest},
+		{image: registry + "/apps/payments-v2:v1", want: registry + "/apps/payments-v2@" + digest},
+		{image: "nginx", want: "registry.example.com/signatures/nginx@" + digest},
+		{image: "ghcr.io/org/app:v1", want: "ghcr.io/org/app@" + digest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := p.SignatureReference(tt.image, digest)
+			if err != nil {
+				t.Fatalf("SignatureReference(%q): %v", tt.image, err)
+			}
+			if got != tt.want {
+				t.Errorf("SignatureReference(%q) = %s, want %s", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicyMalformedPrefix(t *testing.T) {
+	for _, prefix := range []string{"Nginx", "ghcr.io/org/app:v1", "ghcr.io/org/app@sha256:abc"} {
+		_, err := NewPolicy(model.VerifierConfig{Name: "oci", SignatureRepositories: []model.SignatureRepository{
+			{Prefix: prefix, Repository: "ghcr.io/org/signatures"},
+		}})
+		if err == nil {
+			t.Errorf("NewPolicy with %s prefix error = nil, want malformed prefix", prefix)
+		}
+	}
+}
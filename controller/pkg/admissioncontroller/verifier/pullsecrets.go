@@ -0,0 +1,209 @@
+package verifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	pv1 "k8s.io/api/core/v1"
+
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultServiceAccount = "default"
+)
+
+// Keyring holds the docker config auths of a workload's pull secrets
+type Keyring struct {
+	auths map[string]DockerAuth
+	// secrets are the names of the pull secrets of the workload, and of its ServiceAccount
+	secrets []string
+}
+
+// LoadKeyring reads the workload imagePullSecrets, and those attached to the workload ServiceAccount, as
+// kubelet would, from the informer caches. Missing or malformed secrets are logged and skipped.
+func LoadKeyring(_ context.Context, s Subjects) *Keyring {
+	k := Keyring{auths: make(map[string]DockerAuth)}
+
+	if !model.ServerConfig().Kubernetes.PullSecrets.Enabled {
+		return &k
+	}
+
+	names := append([]string{}, s.PullSecrets...)
+
+	sa := s.ServiceAccount
+	if sa == "" {
+		sa = DefaultServiceAccount
+	}
+
+	account, err := kube.ServiceAccount(s.Namespace, sa)
+	if err != nil {
+		log.Log.Warnf("could not load service account pull secrets: %v", err)
+	} else {
+		for _, ref := range account.ImagePullSecrets {
+			names = append(names, ref.Name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		k.secrets = append(k.secrets, name)
+
+		secret, err := kube.PullSecret(s.Namespace, name)
+		if err != nil {
+			log.Log.Warnf("could not load pull secret: %v", err)
+			continue
+		}
+
+		dc, err := dockerConfigFromSecret(secret)
+		if err != nil {
+			log.Log.Warnf("could not read %s pull secret in %s namespace: %v", name, s.Namespace, err)
+			continue
+		}
+
+		// First secret with an entry wins, as with kubelet
+		for key, auth := range dc.Auths {
+			if _, ok := k.auths[key]; !ok {
+				k.auths[key] = auth
+			}
+		}
+	}
+
+	log.Log.Debugf("loaded %d pull secret auths for %s namespace", len(k.auths), s.Namespace)
+
+	return &k
+}
+
+// Credentials returns the pull secret credentials of image, or nil to use the registry verifier
+// credentials. Verifier credentials are only used for workloads with pull secrets if fallback is
+// enabled, otherwise images matching none of them cannot be verified.
+func (k *Keyring) Credentials(image string) ([]string, error) {
+	creds, ok := k.Lookup(image)
+	if ok {
+		return creds, nil
+	}
+
+	if k != nil && len(k.secrets) > 0 && !model.ServerConfig().Kubernetes.PullSecrets.Fallback {
+		return nil, fmt.Errorf("no pull secret, of %v, has auth for %s", k.secrets, image)
+	}
+
+	return nil, nil
+}
+
+// Lookup returns the credentials of the most specific auth entry matching image
+func (k *Keyring) Lookup(image string) ([]string, bool) {
+	if k == nil || len(k.auths) == 0 {
+		return nil, false
+	}
+
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return nil, false
+	}
+	target := ref.Name()
+
+	var match string
+	for key := range k.auths {
+		if len(key) > len(match) && authMatches(key, target) {
+			match = key
+		}
+	}
+
+	if match == "" {
+		return nil, false
+	}
+
+	creds, err := k.auths[match].BasicAuthCreds()
+	if err != nil {
+		log.Log.Warnf("could not decode pull secret auth for %s: %v", match, err)
+		return nil, false
+	}
+
+	log.Log.Debugf("using pull secret auth %s for %s", match, image)
+
+	return creds, true
+}
+
+// dockerConfigFromSecret reads docker config auths from dockerconfigjson and dockercfg secrets
+func dockerConfigFromSecret(secret *pv1.Secret) (*DockerConfig, error) {
+	var dc DockerConfig
+
+	switch secret.Type {
+	case pv1.SecretTypeDockerConfigJson:
+		if err := json.Unmarshal(secret.Data[pv1.DockerConfigJsonKey], &dc); err != nil {
+			return nil, err
+		}
+	case pv1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[pv1.DockerConfigKey], &dc.Auths); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported secret type %s", secret.Type)
+	}
+
+	return &dc, nil
+}
+
+// authMatches matches an auth entry key against a registry/repository target, as kubelet does.
+// Hosts may contain glob patterns per label, ports must be equal, and the key path is a prefix.
+func authMatches(key string, target string) bool {
+	if !strings.Contains(key, "://") {
+		key = "https://" + key
+	}
+	ku, err := url.Parse(key)
+	if err != nil {
+		return false
+	}
+	tu, err := url.Parse("https://" + target)
+	if err != nil {
+		return false
+	}
+
+	kh, kp := splitPort(ku.Host)
+	th, tp := splitPort(tu.Host)
+	if kh == "index.docker.io" {
+		kh = "docker.io"
+	}
+	if kp != tp {
+		return false
+	}
+
+	kl := strings.Split(kh, ".")
+	tl := strings.Split(th, ".")
+	if len(kl) != len(tl) {
+		return false
+	}
+	for i := range kl {
+		if ok, _ := path.Match(kl[i], tl[i]); !ok {
+			return false
+		}
+	}
+
+	kpath := strings.TrimSuffix(ku.Path, "/")
+	if kh == "docker.io" && kpath == "/v1" {
+		kpath = ""
+	}
+
+	return strings.HasPrefix(tu.Path, kpath)
+}
+
+// splitPort splits host and port, if any
+func splitPort(host string) (string, string) {
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return host, ""
+	}
+	return h, p
+}
@@ -0,0 +1,155 @@
+package verifier
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	pv1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"notary-admission/pkg/kube"
+	"notary-admission/pkg/model"
+)
+
+func TestAuthMatches(t *testing.T) {
+	tests := []struct {
+		key    string
+		target string
+		want   bool
+	}{
+		{key: "registry.example.com", target: "registry.example.com/app", want: true},
+		{key: "https://registry.example.com", target: "registry.example.com/app", want: true},
+		{key: "registry.example.com/team", target: "registry.example.com/team/app", want: true},
+		{key: "registry.example.com/team", target: "registry.example.com/other/app", want: false},
+		{key: "*.example.com", target: "registry.example.com/app", want: true},
+		{key: "*.example.com", target: "a.registry.example.com/app", want: false},
+		{key: "*.dkr.ecr.*.amazonaws.com", target: "123456789012.dkr.ecr.us-east-1.amazonaws.com/app", want: true},
+		{key: "registry.example.com:5000", target: "registry.example.com:5000/app", want: true},
+		{key: "registry.example.com:5000", target: "registry.example.com/app", want: false},
+		{key: "registry.example.com", target: "registry.example.com:5000/app", want: false},
+		{key: "https://index.docker.io/v1/", target: "docker.io/library/nginx", want: true},
+		{key: "other.example.com", target: "registry.example.com/app", want: false},
+		{key: "%zz", target: "registry.example.com/app", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"|"+tt.target, func(t *testing.T) {
+			if got := authMatches(tt.key, tt.target); got != tt.want {
+				t.Errorf("authMatches(%q, %q) = %t, want %t", tt.key, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringLookup(t *testing.T) {
+	auth := func(user string) DockerAuth {
+		return DockerAuth{Auth: base64.StdEncoding.EncodeToString([]byte(user + ":secret"))}
+	}
+
+	k := &Keyring{auths: map[string]DockerAuth{
+		"registry.example.com":       auth("registry"),
+		"registry.example.com/team":  auth("team"),
+		"https://index.docker.io/v1": {Username: "hub", Password: "secret"},
+		"broken.example.com":         {Auth: "!"},
+	}}
+
+	tests := []struct {
+		name   string
+		image  string
+		want   string
+		wantOk bool
+	}{
+		{name: "registry", image: "registry.example.com/app:v1", want: "registry", wantOk: true},
+		{name: "longest match", image: "registry.example.com/team/app:v1", want: "team", wantOk: true},
+		{name: "docker hub", image: "nginx", want: "hub", wantOk: true},
+		{name: "no match", image: "ghcr.io/org/app", wantOk: false},
+		{name: "malformed auth", image: "broken.example.com/app", wantOk: false},
+		{name: "malformed image", image: "Nginx", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, ok := k.Lookup(tt.image)
+			if ok != tt.wantOk {
+				t.Fatalf("Lookup(%q) ok = %t, want %t", tt.image, ok, tt.wantOk)
+			}
+			if ok && (creds[0] != tt.want || creds[1] != "secret") {
+				t.Errorf("Lookup(%q) = %v, want %s:secret", tt.image, creds, tt.want)
+			}
+		})
+	}
+
+	var empty *Keyring
+	if _, ok := empty.Lookup("nginx"); ok {
+		t.Error("nil Keyring Lookup ok = true, want false")
+	}
+}
+
+func TestLoadKeyringCredentials(t *testing.T) {
+	auth := func(user string) string {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":secret"))
+	}
+
+	kube.SetClients(fake.NewClientset(
+		&pv1.ServiceAccount{
+			ObjectMeta:       meta.ObjectMeta{Name: "default", Namespace: "team"},
+			ImagePullSecrets: []pv1.LocalObjectReference{{Name: "sa-secret"}},
+		},
+		&pv1.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: "pod-secret", Namespace: "team"},
+			Type:       pv1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{pv1.DockerConfigJsonKey: []byte(
+				`{"auths":{"registry.example.com":{"auth":"` + auth("pod") + `"}}}`)},
+		},
+		&pv1.Secret{
+			ObjectMeta: meta.ObjectMeta{Name: "sa-secret", Namespace: "team"},
+			Type:       pv1.SecretTypeDockercfg,
+			Data:       map[string][]byte{pv1.DockerConfigKey: []byte(`{"ghcr.io":{"auth":"` + auth("sa") + `"}}`)},
+		},
+	), nil)
+
+	c := &model.Config{}
+	c.Kubernetes.PullSecrets.Enabled = true
+	model.SetServerConfig(c)
+
+	if err := kube.StartPullSecretInformers(); err != nil {
+		t.Fatal(err)
+	}
+
+	team := LoadKeyring(context.Background(), Subjects{Namespace: "team", PullSecrets: []string{"pod-secret"}})
+	other := LoadKeyring(context.Background(), Subjects{Namespace: "other"})
+
+	tests := []struct {
+		name     string
+		keyring  *Keyring
+		image    string
+		fallback bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "workload secret", keyring: team, image: "registry.example.com/app", want: "pod"},
+		{name: "service account secret", keyring: team, image: "ghcr.io/org/app", want: "sa"},
+		{name: "no matching secret", keyring: team, image: "quay.io/org/app", wantErr: true},
+		{name: "no matching secret, fallback", keyring: team, image: "quay.io/org/app", fallback: true},
+		{name: "no secrets", keyring: other, image: "quay.io/org/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.Kubernetes.PullSecrets.Fallback = tt.fallback
+
+			creds, err := tt.keyring.Credentials(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Credentials(%q) error = %v, want error %t", tt.image, err, tt.wantErr)
+			}
+			if tt.want == "" && creds != nil {
+				t.Errorf("Credentials(%q) = %v, want verifier credentials", tt.image, creds)
+			}
+			if tt.want != "" && (len(creds) != 2 || creds[0] != tt.want) {
+				t.Errorf("Credentials(%q) = %v, want %s", tt.image, creds, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package verifier
+
+import (
+	"context"
+
+	"notary-admission/pkg/imagepolicy"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+// ResolveSubjects resolves images (subjects) to their manifest digests, by image, with the credentials
+// verification would use. Images are not verified, and no outcome is recorded. Images of bypassed
+// registries, images exempted by an ImageVerificationPolicy, and images that could not be resolved,
+// are left out.
+func ResolveSubjects(ctx context.Context, s Subjects) map[string]string {
+	keyring := LoadKeyring(ctx, s)
+
+	subjects := uniqueImages(s.Images)
+	resolved := make([]string, len(subjects))
+
+	forEach(len(subjects), func(j int) {
+		resolved[j] = resolveSubject(ctx, subjects[j], s.Namespace, keyring)
+	})
+
+	digests := make(map[string]string)
+	for j, d := range resolved {
+		if d != "" {
+			digests[subjects[j]] = d
+		}
+	}
+
+	return digests
+}
+
+// resolveSubject resolves a single image to its manifest digest, or "" if it is not verified or
+// could not be resolved
+func resolveSubject(ctx context.Context, image string, namespace string, keyring *Keyring) string {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return ""
+	}
+
+	if _, ok := model.ServerConfig().BypassRegistries[ref.Registry]; ok {
+		return ""
+	}
+
+	if model.ServerConfig().ImageVerificationPolicies.Enabled {
+		p, err := imagepolicy.For(namespace, image)
+		if err != nil || (p != nil && p.Exempts(image)) {
+			return ""
+		}
+	}
+
+	verifier, _, err := Lookup(ref.Registry)
+	if err != nil {
+		return ""
+	}
+
+	creds, err := keyring.Credentials(image)
+	if err != nil {
+		log.Log.Debugf("%s not resolved: %v", image, err)
+		return ""
+	}
+	if creds == nil {
+		creds, err = verifier.Credentials(ctx, image)
+		if err != nil {
+			log.Log.Debugf("%s not resolved: %v", image, err)
+			return ""
+		}
+	}
+
+	desc, err := notation.Resolve(ctx, image, creds[0], creds[1])
+	if err != nil {
+		log.Log.Debugf("%s not resolved: %v", image, err)
+		return ""
+	}
+
+	return desc.Digest.String()
+}
@@ -0,0 +1,566 @@
+package verifier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"notary-admission/pkg/imagepolicy"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/tracing"
+	"notary-admission/pkg/utils"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MsgVerifyBypass   = "image verification bypassed"
+	MsgVerifyExempted = "image verification exempted"
+
+	DefaultMaxConcurrency = 4
+)
+
+// Verifier verifies image signatures for the registries it is registered with
+type Verifier interface {
+	// Name returns the verifier name
+	Name() string
+	// Verify verifies image with the signature formats required by policy, returning an error
+	// if verification could not be attempted. Credentials, if provided, take precedence over
+	// the verifier's own credentials.
+	Verify(ctx context.Context, image string, creds []string, policy *Policy) (Response, error)
+	// Credentials returns the verifier's registry credentials for image
+	Credentials(ctx context.Context, image string) ([]string, error)
+}
+
+type registration struct {
+	patterns []string
+	verifier Verifier
+	policy   *Policy
+}
+
+var (
+	regLock       = &sync.RWMutex{}
+	registrations []registration
+	inflight      singleflight.Group
+)
+
+// Subjects contains the images to verify, and the workload context needed to pull them
+type Subjects struct {
+	Images         []string
+	Namespace      string
+	ServiceAccount string
+	PullSecrets    []string
+}
+
+type Response struct {
+	ErrorMessage string
+	Error        error
+	Message      string
+	Image        string
+	Digest       string
+	ByPassed     bool
+	Warning      string
+	// Signer is the identity of the signer of the verified signature, if known
+	Signer string
+	// Policy and Enforcement are the name and enforcement mode of the ImageVerificationPolicy applied, if any
+	Policy      string
+	Enforcement string
+}
+
+type Verification struct {
+	Responses []Response
+	Message   string
+	Error     error
+}
+
+// InitVerifiers builds the verifier registry from config, in config order.
+// ECR verifies all registries if no verifiers are configured.
+func InitVerifiers() error {
+	swap, err := PrepareVerifiers(model.ServerConfig())
+	if err != nil {
+		return err
+	}
+	swap()
+	return nil
+}
+
+// PrepareVerifiers builds the verifier registry of config c, returning the function that swaps it in
+func PrepareVerifiers(c *model.Config) (func(), error) {
+	var regs []registration
+
+	for _, vc := range c.Verifiers {
+		var v Verifier
+		switch vc.Type {
+		case model.VerifierTypeEcr:
+			v = GetEcrv()
+			if vc.Auth.CredentialProvider.Path == "" {
+				break
+			}
+			// ECR credentials from a kubelet credential provider plugin, instead of IRSA
+			fallthrough
+		case model.VerifierTypeOci:
+			ov, err := NewOciVerifier(vc)
+			if err != nil {
+				return nil, fmt.Errorf("could not create %s verifier: %w", vc.Name, err)
+			}
+			v = ov
+		case model.VerifierTypeEcrPublic:
+			v = GetEcrpv()
+		default:
+			return nil, fmt.Errorf("verifier %s has unsupported type: %s", vc.Name, vc.Type)
+		}
+
+		for _, p := range vc.Registries {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("verifier %s has malformed registry pattern %s: %w", vc.Name, p, err)
+			}
+		}
+
+		policy, err := NewPolicy(vc)
+		if err != nil {
+			return nil, err
+		}
+
+		regs = append(regs, registration{patterns: vc.Registries, verifier: v, policy: policy})
+		log.Log.Infof("%s verifier (%s) registered for registries: %v, requiring %s signatures",
+			vc.Name, vc.Type, vc.Registries, policy.signatures)
+	}
+
+	if len(regs) == 0 {
+		regs = append(regs,
+			registration{patterns: []string{EcrPublicRegistry}, verifier: GetEcrpv(), policy: DefaultPolicy},
+			registration{patterns: []string{"*"}, verifier: GetEcrv(), policy: DefaultPolicy})
+		log.Log.Info("no verifiers configured, ECR Public verifier registered for public.ecr.aws, " +
+			"ECR verifier registered for all other registries")
+	}
+
+	return func() {
+		regLock.Lock()
+		defer regLock.Unlock()
+		registrations = regs
+	}, nil
+}
+
+// Lookup returns the first registered verifier, and its policy, with a pattern matching registry
+func Lookup(registry string) (Verifier, *Policy, error) {
+	r, _, ok := lookup(registry)
+	if !ok {
+		return nil, nil, fmt.Errorf("no verifier registered for registry %s", registry)
+	}
+	return r.verifier, r.policy, nil
+}
+
+// lookup returns the first registration, and its pattern, matching registry, if any
+func lookup(registry string) (registration, string, bool) {
+	regLock.RLock()
+	defer regLock.RUnlock()
+
+	for _, r := range registrations {
+		for _, p := range r.patterns {
+			if ok, _ := path.Match(p, registry); ok {
+				return r, p, true
+			}
+		}
+	}
+
+	return registration{}, "", false
+}
+
+// VerifySubjects verifies images (subjects) concurrently, with a bounded worker pool.
+// Duplicate images are verified once, and responses are ordered as the images were provided.
+func VerifySubjects(ctx context.Context, s Subjects) Verification {
+	v := Verification{}
+
+	trustPolicy, err := notation.PolicyFor(s.Namespace)
+	if err != nil {
+		log.Log.Error(err)
+		v.Error = err
+		v.Message = err.Error()
+		return v
+	}
+	if trustPolicy != "" {
+		log.Log.Debugf("using %s trust policy for %s namespace", trustPolicy, s.Namespace)
+	}
+
+	keyring := LoadKeyring(ctx, s)
+
+	subjects := uniqueImages(s.Images)
+	responses := make([]Response, len(subjects))
+	errs := make([]error, len(subjects))
+
+	forEach(len(subjects), func(j int) {
+		sctx, span := tracing.Start(ctx, "verifier.verifySubject", semconv.ContainerImageName(subjects[j]),
+			tracing.AttrRegistry.String(utils.RegistryFromImage(subjects[j])))
+		responses[j], errs[j] = verifyPolicySubject(sctx, subjects[j], s.Namespace, keyring, trustPolicy)
+		endSpan(span, responses[j], errs[j])
+	})
+
+	if OutcomesRecorded(ctx) {
+		for j := range subjects {
+			recordOutcome(subjects[j], responses[j], errs[j])
+		}
+	}
+
+	for j := range subjects {
+		if errs[j] != nil {
+			v.Error = errs[j]
+			v.Message = errs[j].Error()
+			return v
+		}
+		v.Responses = append(v.Responses, responses[j])
+	}
+
+	return v
+}
+
+// uniqueImages returns images without duplicates, in the order they were provided
+func uniqueImages(images []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, i := range images {
+		if !seen[i] {
+			seen[i] = true
+			unique = append(unique, i)
+		}
+	}
+	return unique
+}
+
+// forEach calls f with every index below n, with a bounded worker pool
+func forEach(n int, f func(j int)) {
+	workers := model.ServerConfig().Notation.MaxConcurrency
+	if workers <= 0 {
+		workers = DefaultMaxConcurrency
+	}
+	if workers > n {
+		workers = n
+	}
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				f(j)
+			}
+		}()
+	}
+
+	for j := 0; j < n; j++ {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// endSpan ends the span of an image verification, with its outcome
+func endSpan(span trace.Span, r Response, err error) {
+	defer span.End()
+
+	result, reason := outcome(r, err)
+	span.SetAttributes(tracing.AttrResult.String(result))
+	if r.Digest != "" {
+		span.SetAttributes(tracing.AttrDigest.String(r.Digest))
+	}
+	if r.Policy != "" {
+		span.SetAttributes(tracing.AttrPolicy.String(r.Policy))
+	}
+
+	if err == nil {
+		err = r.Error
+	}
+	if err != nil {
+		span.SetAttributes(tracing.AttrReason.String(reason))
+		tracing.Fail(span, err)
+	}
+}
+
+// verifyPolicySubject verifies a single image with the trust policy of the ImageVerificationPolicy applying
+// to it, if any, otherwise the named namespace trust policy
+func verifyPolicySubject(ctx context.Context, image string, namespace string, keyring *Keyring,
+	trustPolicy string) (Response, error) {
+	if !model.ServerConfig().ImageVerificationPolicies.Enabled {
+		return verifySubject(ctx, image, keyring, trustPolicy)
+	}
+
+	p, err := imagepolicy.For(namespace, image)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+	if p == nil {
+		return verifySubject(ctx, image, keyring, trustPolicy)
+	}
+
+	if p.Exempts(image) {
+		log.Log.Infof("image %s verification was exempted by %s policy", image, p.Name)
+		return Response{
+			Image:       image,
+			ByPassed:    true,
+			Warning:     fmt.Sprintf("%s - %s by %s policy", image, MsgVerifyExempted, p.Name),
+			Policy:      p.Name,
+			Enforcement: p.Enforcement,
+		}, nil
+	}
+
+	log.Log.Debugf("using %s policy for %s in %s namespace", p.Name, image, namespace)
+
+	res, err := verifySubject(ctx, image, keyring, p.TrustPolicy)
+	res.Policy = p.Name
+	res.Enforcement = p.Enforcement
+
+	return res, err
+}
+
+// verifySubject verifies a single image with the verifier registered for its registry, and the
+// named trust policy, preferring pull secret credentials, and sharing in-flight verifications
+// of the same image. Shared verifications are not cancelled with the first caller.
+func verifySubject(ctx context.Context, image string, keyring *Keyring, trustPolicy string) (Response, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+
+	registry := ref.Registry
+	if _, ok := model.ServerConfig().BypassRegistries[registry]; ok {
+		// bypass image signature verification
+		log.Log.Infof("image %s verification was bypassed", image)
+		return Response{
+			Image:    image,
+			ByPassed: true,
+			Warning:  image + " - " + MsgVerifyBypass,
+		}, nil
+	}
+
+	verifier, policy, err := Lookup(registry)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+	policy = policy.WithTrustPolicy(trustPolicy)
+
+	type result struct {
+		response Response
+		err      error
+	}
+
+	creds, err := keyring.Credentials(image)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{}, err
+	}
+
+	// In-flight verifications are only shared by requests with the same credentials
+	key := policy.cacheKey(image)
+	if creds != nil {
+		sum := sha256.Sum256([]byte(strings.Join(creds, ":")))
+		key = key + "|" + hex.EncodeToString(sum[:])
+	}
+
+	if cacheBypassed(ctx) {
+		key = key + "|nocache"
+	}
+
+	r, _, shared := inflight.Do(key, func() (interface{}, error) {
+		response, err := verifier.Verify(context.WithoutCancel(ctx), image, creds, policy)
+		return result{response: response, err: err}, nil
+	})
+	if shared {
+		log.Log.Debugf("shared in-flight verification of %s", image)
+	}
+
+	res := r.(result)
+	return res.response, res.err
+}
+
+// bypassCacheKey is the context key of verifications that bypass the verification cache
+type bypassCacheKey struct{}
+
+// WithoutCache returns a context whose verifications do not use cached results, so that revoked
+// signatures are detected before the cached result expires. Results are still cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+// cacheBypassed determines if the verifications of ctx bypass the verification cache
+func cacheBypassed(ctx context.Context) bool {
+	b, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return b
+}
+
+// unrecordedKey is the context key of verifications whose outcomes are not recorded
+type unrecordedKey struct{}
+
+// WithoutOutcomes returns a context whose verification and exemption outcomes are not recorded on
+// the admission metrics, such as re-verifications, which record their own
+func WithoutOutcomes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedKey{}, true)
+}
+
+// OutcomesRecorded determines if the verification and exemption outcomes of ctx are recorded
+func OutcomesRecorded(ctx context.Context) bool {
+	u, _ := ctx.Value(unrecordedKey{}).(bool)
+	return !u
+}
+
+// verifyImage resolves image to its manifest digest and verifies the digest per policy,
+// reusing cached results when enabled, unless ctx bypasses the cache
+func verifyImage(ctx context.Context, image string, creds []string, policy *Policy) Response {
+	desc, err := notation.Resolve(ctx, image, creds[0], creds[1])
+	if err != nil {
+		log.Log.Error(err)
+		return Response{Image: image, Error: err, ErrorMessage: err.Error()}
+	}
+
+	digest := desc.Digest.String()
+	ref, err := notation.DigestReference(image, digest)
+	if err != nil {
+		return Response{Image: image, Error: err, ErrorMessage: err.Error()}
+	}
+
+	key := policy.cacheKey(ref)
+	if Vc != nil && !cacheBypassed(ctx) {
+		if r, ok := Vc.Get(key); ok {
+			trace.SpanFromContext(ctx).AddEvent("verification cache hit")
+			r.Image = image
+			return r
+		}
+	}
+
+	// Signatures may be looked up in another repository, but always for the digest resolved above
+	sigRef, sigCreds, err := signatureReference(ctx, image, ref, digest, creds, policy)
+	if err != nil {
+		log.Log.Error(err)
+		return Response{Image: image, Error: err, ErrorMessage: err.Error()}
+	}
+
+	// Verify the resolved digest, so the digest returned is the one that was verified
+	response := policy.verify(ctx, sigRef, sigCreds)
+	response.Image = image
+	response.Digest = digest
+
+	if Vc != nil {
+		Vc.Put(key, response)
+	}
+
+	return response
+}
+
+// signatureReference returns the signature digest reference of image per policy, and the
+// credentials of its registry
+func signatureReference(ctx context.Context, image string, ref string, digest string, creds []string,
+	policy *Policy) (string, []string, error) {
+	sigRef, err := policy.SignatureReference(image, digest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sigRef == ref {
+		return ref, creds, nil
+	}
+
+	log.Log.Debugf("signatures of %s looked up in %s", ref, sigRef)
+
+	registry := utils.RegistryFromImage(sigRef)
+	if registry == utils.RegistryFromImage(ref) {
+		return sigRef, creds, nil
+	}
+
+	v, _, err := Lookup(registry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sigCreds, err := v.Credentials(ctx, sigRef)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sigRef, sigCreds, nil
+}
+
+// verify verifies image with the configured notation mode and the named trust policy
+func verify(ctx context.Context, image string, creds []string, trustPolicy string) Response {
+	defer observeVerification(image, SignaturesLabelNotation, time.Now())
+
+	mode := model.ServerConfig().Notation.Mode
+	ctx, span := tracing.Start(ctx, "notation.verify", semconv.ContainerImageName(image), tracing.AttrMode.String(mode))
+	defer span.End()
+
+	var response Response
+	switch mode {
+	case model.LibraryMode:
+		response = verifyLibrary(ctx, image, creds, trustPolicy)
+	default:
+		response = verifyBinary(image, creds, trustPolicy)
+	}
+
+	if response.Error != nil {
+		tracing.Fail(span, response.Error)
+	}
+
+	return response
+}
+
+// verifyBinary verifies image by executing the notation binary
+func verifyBinary(image string, creds []string, trustPolicy string) Response {
+	nc := notation.Command{XdgHome: notation.PolicyXdgHome(trustPolicy)}
+	args := []string{model.ServerConfig().Notation.VerifyCommand}
+	if creds[0] != "" || creds[1] != "" {
+		args = append(args, "-u", creds[0], "-p", creds[1])
+	}
+
+	nc.Subject = image
+
+	if ref, err := utils.ParseImageReference(image); err == nil && notation.InsecureRegistry(ref.Registry) {
+		args = append(args, "--insecure-registry")
+	}
+
+	args = append(args, image)
+
+	if model.ServerConfig().Notation.DebugEnabled {
+		args = append(args, model.ServerConfig().Notation.DebugFlag)
+	}
+
+	for k, v := range notation.PluginConfig() {
+		args = append(args, "--plugin-config", fmt.Sprintf("%s=%s", k, v))
+	}
+
+	nc.Args = args
+
+	nc.Execute()
+
+	return Response{
+		Image:        nc.Subject,
+		ErrorMessage: nc.Err,
+		Error:        nc.Error,
+	}
+}
+
+// verifyLibrary verifies image in-process with the notation-go library
+func verifyLibrary(ctx context.Context, image string, creds []string, trustPolicy string) Response {
+	response := Response{Image: image}
+
+	desc, signer, err := notation.VerifyImage(ctx, image, creds[0], creds[1], trustPolicy)
+	if err != nil {
+		response.Error = err
+		response.ErrorMessage = err.Error()
+		return response
+	}
+
+	response.Digest = desc.Digest.String()
+	response.Signer = signer
+
+	return response
+}
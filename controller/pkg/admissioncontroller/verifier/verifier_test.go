@@ -0,0 +1,160 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"notary-admission/pkg/model"
+)
+
+// fakeVerifier verifies images after their delay, failing those with an error, and tracks its calls
+// and concurrency
+type fakeVerifier struct {
+	delays map[string]time.Duration
+	errs   map[string]error
+
+	calls   atomic.Int32
+	running atomic.Int32
+	lock    sync.Mutex
+	peak    int32
+}
+
+func (f *fakeVerifier) Name() string {
+	return "fake"
+}
+
+func (f *fakeVerifier) Verify(_ context.Context, image string, _ []string, _ *Policy) (Response, error) {
+	f.calls.Add(1)
+	n := f.running.Add(1)
+	defer f.running.Add(-1)
+
+	f.lock.Lock()
+	if n > f.peak {
+		f.peak = n
+	}
+	f.lock.Unlock()
+
+	time.Sleep(f.delays[image])
+	if err := f.errs[image]; err != nil {
+		return Response{}, err
+	}
+	return Response{Image: image, Digest: "sha256:" + image}, nil
+}
+
+func (f *fakeVerifier) Credentials(context.Context, string) ([]string, error) {
+	return []string{"", ""}, nil
+}
+
+// useVerifier registers v for all registries, with at most workers concurrent verifications
+func useVerifier(t *testing.T, v Verifier, workers int) {
+	t.Helper()
+
+	c := &model.Config{}
+	c.Notation.MaxConcurrency = workers
+	model.SetServerConfig(c)
+
+	regLock.Lock()
+	active := registrations
+	registrations = []registration{{patterns: []string{"*"}, verifier: v, policy: DefaultPolicy}}
+	regLock.Unlock()
+
+	t.Cleanup(func() {
+		regLock.Lock()
+		registrations = active
+		regLock.Unlock()
+	})
+}
+
+func TestVerifySubjectsOrder(t *testing.T) {
+	f := &fakeVerifier{delays: map[string]time.Duration{
+		"registry.example.com/order/slow:v1": 50 * time.Millisecond,
+		"registry.example.com/order/mid:v1":  20 * time.Millisecond,
+	}}
+	useVerifier(t, f, 2)
+
+	v := VerifySubjects(context.Background(), Subjects{Namespace: "default", Images: []string{
+		"registry.example.com/order/slow:v1",
+		"registry.example.com/order/mid:v1",
+		"registry.example.com/order/slow:v1",
+		"registry.example.com/order/fast:v1",
+	}})
+	if v.Error != nil {
+		t.Fatalf("VerifySubjects error: %v", v.Error)
+	}
+
+	var got []string
+	for _, r := range v.Responses {
+		got = append(got, r.Image)
+	}
+	want := []string{
+		"registry.example.com/order/slow:v1",
+		"registry.example.com/order/mid:v1",
+		"registry.example.com/order/fast:v1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("responses = %v, want %v", got, want)
+	}
+	if n := f.calls.Load(); n != 3 {
+		t.Errorf("%d verifications, want 3, duplicates verified once", n)
+	}
+	if f.peak > 2 {
+		t.Errorf("%d concurrent verifications, want at most 2", f.peak)
+	}
+}
+
+func TestVerifySubjectsError(t *testing.T) {
+	first := errors.New("registry unavailable")
+	f := &fakeVerifier{
+		delays: map[string]time.Duration{"registry.example.com/errors/first:v1": 30 * time.Millisecond},
+		errs: map[string]error{
+			"registry.example.com/errors/first:v1":  first,
+			"registry.example.com/errors/second:v1": errors.New("unauthorized"),
+		},
+	}
+	useVerifier(t, f, 4)
+
+	v := VerifySubjects(context.Background(), Subjects{Namespace: "default", Images: []string{
+		"registry.example.com/errors/ok:v1",
+		"registry.example.com/errors/first:v1",
+		"registry.example.com/errors/second:v1",
+	}})
+
+	// The error of the first errored image is returned, whichever finished first, once every image ran
+	if !errors.Is(v.Error, first) || v.Message != first.Error() {
+		t.Errorf("VerifySubjects error = %v, want %v", v.Error, first)
+	}
+	if n := f.calls.Load(); n != 3 {
+		t.Errorf("%d verifications, want 3", n)
+	}
+}
+
+func TestVerifySubjectsWithoutOutcomes(t *testing.T) {
+	useVerifier(t, &fakeVerifier{}, 2)
+	initMetrics()
+
+	image := "registry.example.com/outcomes/app:v1"
+	verified := verifyMetric.Verifications.WithLabelValues(registryLabel(image), ResultVerified)
+	before := testutil.ToFloat64(verified)
+
+	if v := VerifySubjects(WithoutOutcomes(context.Background()), Subjects{Namespace: "default",
+		Images: []string{image}}); v.Error != nil {
+		t.Fatalf("VerifySubjects error: %v", v.Error)
+	}
+	if n := testutil.ToFloat64(verified) - before; n != 0 {
+		t.Errorf("%v verifications recorded without outcomes, want 0", n)
+	}
+
+	if v := VerifySubjects(context.Background(), Subjects{Namespace: "default", Images: []string{image}}); v.Error != nil {
+		t.Fatalf("VerifySubjects error: %v", v.Error)
+	}
+	if n := testutil.ToFloat64(verified) - before; n != 1 {
+		t.Errorf("%v verifications recorded, want 1", n)
+	}
+}
@@ -0,0 +1,120 @@
+package workloads
+
+import (
+	"fmt"
+
+	"notary-admission/pkg/imagepolicy"
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+)
+
+const (
+	DecisionAllowed = "allowed"
+	DecisionDenied  = "denied"
+)
+
+// ValidEnforcementMode determines if mode is a supported enforcement mode
+func ValidEnforcementMode(mode string) bool {
+	switch mode {
+	case model.EnforcementEnforce, model.EnforcementWarn, model.EnforcementAudit:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidateEnforcement validates the global enforcement mode of config c
+func ValidateEnforcement(c *model.Config) error {
+	mode := c.Enforcement.Mode
+	if mode != "" && !ValidEnforcementMode(mode) {
+		return fmt.Errorf("enforcement mode %s not supported, must be %s, %s or %s", mode,
+			model.EnforcementEnforce, model.EnforcementWarn, model.EnforcementAudit)
+	}
+	return nil
+}
+
+// policyFor returns the ImageVerificationPolicy applying to image in namespace, if any
+var policyFor = imagepolicy.For
+
+// modeStrictness orders the enforcement modes, from least to most strict
+var modeStrictness = map[string]int{
+	model.EnforcementAudit:   1,
+	model.EnforcementWarn:    2,
+	model.EnforcementEnforce: 3,
+}
+
+// strictestMode returns the stricter of enforcement modes a and b, either of which may be unset
+func strictestMode(a string, b string) string {
+	if modeStrictness[b] > modeStrictness[a] {
+		return b
+	}
+	return a
+}
+
+// enforcementMode returns the enforcement mode of namespace, from the namespace enforcement label
+// if set, otherwise the global enforcement mode
+func enforcementMode(namespace string) string {
+	mode := model.ServerConfig().Enforcement.Mode
+	if mode == "" {
+		mode = model.EnforcementEnforce
+	}
+
+	label := model.ServerConfig().Enforcement.NamespaceLabel
+	if label == "" || namespace == "" {
+		return mode
+	}
+
+	labels, err := kube.NamespaceLabels(namespace)
+	if err != nil {
+		log.Log.Errorf("could not get enforcement label, using %s mode: %v", mode, err)
+		return mode
+	}
+
+	nsMode, ok := labels[label]
+	if !ok {
+		return mode
+	}
+
+	if !ValidEnforcementMode(nsMode) {
+		log.Log.Warnf("%s namespace %s label value %s not supported, using %s mode", namespace, label, nsMode, mode)
+		return mode
+	}
+
+	return nsMode
+}
+
+// errorEnforcement returns the enforcement mode of a workload in namespace whose images could not be
+// verified: the strictest mode of the ImageVerificationPolicies of images, or of namespace for images
+// without one. Images whose policy cannot be selected are enforced.
+func errorEnforcement(namespace string, images []string) string {
+	nsMode := ""
+	mode := ""
+	for _, i := range images {
+		if model.ServerConfig().ImageVerificationPolicies.Enabled {
+			p, err := policyFor(namespace, i)
+			if err != nil {
+				log.Log.Errorf("could not select image policy of %s, using %s mode: %v", i,
+					model.EnforcementEnforce, err)
+				return model.EnforcementEnforce
+			}
+			if p != nil && p.Exempts(i) {
+				continue
+			}
+			if p != nil && p.Enforcement != "" {
+				mode = strictestMode(mode, p.Enforcement)
+				continue
+			}
+		}
+
+		if nsMode == "" {
+			nsMode = enforcementMode(namespace)
+		}
+		mode = strictestMode(mode, nsMode)
+	}
+
+	if mode == "" {
+		mode = enforcementMode(namespace)
+	}
+	return mode
+}
@@ -0,0 +1,122 @@
+package workloads
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"notary-admission/pkg/imagepolicy"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+)
+
+func TestStrictestMode(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{a: "", b: model.EnforcementAudit, want: model.EnforcementAudit},
+		{a: model.EnforcementAudit, b: model.EnforcementWarn, want: model.EnforcementWarn},
+		{a: model.EnforcementEnforce, b: model.EnforcementWarn, want: model.EnforcementEnforce},
+		{a: model.EnforcementWarn, b: model.EnforcementEnforce, want: model.EnforcementEnforce},
+		{a: model.EnforcementWarn, b: "", want: model.EnforcementWarn},
+	}
+
+	for _, tt := range tests {
+		if got := strictestMode(tt.a, tt.b); got != tt.want {
+			t.Errorf("strictestMode(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// usePolicies sets the global enforcement mode, and replaces the ImageVerificationPolicies of images
+func usePolicies(t *testing.T, mode string, policies map[string]*imagepolicy.ImagePolicy) {
+	t.Helper()
+
+	c := &model.Config{}
+	c.Enforcement.Mode = mode
+	c.ImageVerificationPolicies.Enabled = policies != nil
+	model.SetServerConfig(c)
+
+	policyFor = func(_ string, image string) (*imagepolicy.ImagePolicy, error) {
+		if image == "registry.example.com/broken:v1" {
+			return nil, errors.New("namespace informer not synced")
+		}
+		return policies[image], nil
+	}
+	t.Cleanup(func() { policyFor = imagepolicy.For })
+}
+
+func TestErrorEnforcement(t *testing.T) {
+	policies := map[string]*imagepolicy.ImagePolicy{
+		"registry.example.com/enforced:v1": {Name: "enforced", Enforcement: model.EnforcementEnforce},
+		"registry.example.com/audited:v1":  {Name: "audited", Enforcement: model.EnforcementAudit},
+		"registry.example.com/default:v1":  {Name: "default"},
+	}
+
+	tests := []struct {
+		name     string
+		images   []string
+		policies map[string]*imagepolicy.ImagePolicy
+		want     string
+	}{
+		{name: "namespace mode", images: []string{"registry.example.com/enforced:v1"}, want: model.EnforcementWarn},
+		{
+			name:     "enforcing policy",
+			images:   []string{"registry.example.com/audited:v1", "registry.example.com/enforced:v1"},
+			policies: policies,
+			want:     model.EnforcementEnforce,
+		},
+		{
+			name:     "auditing policy",
+			images:   []string{"registry.example.com/audited:v1"},
+			policies: policies,
+			want:     model.EnforcementAudit,
+		},
+		{
+			name:     "policy and namespace mode",
+			images:   []string{"registry.example.com/audited:v1", "registry.example.com/other:v1"},
+			policies: policies,
+			want:     model.EnforcementWarn,
+		},
+		{
+			name:     "policy without mode",
+			images:   []string{"registry.example.com/default:v1"},
+			policies: policies,
+			want:     model.EnforcementWarn,
+		},
+		{
+			name:     "policy selection error",
+			images:   []string{"registry.example.com/audited:v1", "registry.example.com/broken:v1"},
+			policies: policies,
+			want:     model.EnforcementEnforce,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicies(t, model.EnforcementWarn, tt.policies)
+
+			if got := errorEnforcement("default", tt.images); got != tt.want {
+				t.Errorf("errorEnforcement = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWorkloadErrorEnforcement(t *testing.T) {
+	usePolicies(t, model.EnforcementWarn, map[string]*imagepolicy.ImagePolicy{
+		"registry.example.com/enforced:v1": {Name: "enforced", Enforcement: model.EnforcementEnforce},
+	})
+
+	// No verifier is registered, so the image cannot be verified
+	wl := &Workload{Kind: "Pod", Name: "app", Namespace: "default", Images: []string{"registry.example.com/enforced:v1"}}
+
+	_, denied := verifyWorkload(context.Background(), wl)
+	if denied == nil || denied.Msg != notation.ValidationFailed {
+		t.Fatalf("result = %+v, want %s denial", denied, notation.ValidationFailed)
+	}
+	if wl.Enforcement != model.EnforcementEnforce {
+		t.Errorf("enforcement = %q, want %q", wl.Enforcement, model.EnforcementEnforce)
+	}
+}
@@ -0,0 +1,120 @@
+package workloads
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/admission/v1"
+	"notary-admission/pkg/admissioncontroller"
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/exemption"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+// NewMutationHook creates a new instance of the image digest pinning hook
+func NewMutationHook() admissioncontroller.Hook {
+	return admissioncontroller.Hook{
+		Create: mutate(),
+		Update: mutate(),
+	}
+}
+
+// mutate pins workload images to the digests their tags resolve to, so that the validation hook verifies,
+// and the kubelet pulls, the same digests. Images are resolved, not verified, so that each admission is
+// verified, counted and recorded once, by the validation hook. Requests are never denied. Images that
+// are already pinned, bypassed, exempted or could not be resolved are left unpinned.
+func mutate() admissioncontroller.AdmitFunc {
+	return func(ctx context.Context, ar *v1.AdmissionRequest) (*admissioncontroller.Result, error) {
+		wl := parseRequest(ctx, ar)
+		if wl.Error != nil {
+			log.Log.Errorf("parse pod error, not pinned: %v", wl.Error)
+			return &admissioncontroller.Result{Allowed: true, Msg: wl.Error.Error()}, nil
+		}
+
+		if wl.Namespace == "" {
+			wl.Namespace = ar.Namespace
+		}
+
+		log.Log.Debugf("workload: %+v", wl)
+
+		digests := verifier.ResolveSubjects(ctx, verifier.Subjects{
+			Images:         unpinnedImages(wl),
+			Namespace:      wl.Namespace,
+			ServiceAccount: wl.ServiceAccount,
+			PullSecrets:    wl.PullSecrets,
+		})
+
+		patches, pinned := pinPatches(wl, digests)
+
+		result := &admissioncontroller.Result{
+			Allowed: true,
+			Msg:     fmt.Sprintf("%s %s in %s namespace, images pinned: %v", wl.Name, wl.Kind, wl.Namespace, pinned),
+		}
+
+		if len(patches) > 0 {
+			patch, err := json.Marshal(patches)
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal patch: %w", err)
+			}
+			result.Patch = patch
+		}
+
+		log.Log.Debug(result.Msg)
+		return result, nil
+	}
+}
+
+// unpinnedImages returns the images of workload wl without a digest, and not exempted by its exemption
+// annotation, which may name images by tag
+func unpinnedImages(wl *Workload) []string {
+	// A rejected exemption exempts no image, and is recorded by the validation hook
+	e, _ := exemptionFor(exemption.Ev(), wl)
+
+	var images []string
+	for _, i := range wl.Images {
+		ref, err := utils.ParseImageReference(i)
+		if err != nil || ref.Digest != "" {
+			continue
+		}
+		if e != nil && e.Matches(i) {
+			continue
+		}
+		images = append(images, i)
+	}
+
+	return images
+}
+
+// pinPatches returns the JSONPatch operations replacing the images of workload wl with their digest
+// references, from digests by image, and the digest references
+func pinPatches(wl *Workload, digests map[string]string) ([]admissioncontroller.PatchOperation, []string) {
+	var patches []admissioncontroller.PatchOperation
+	var pinned []string
+	for _, c := range wl.Containers {
+		digest, ok := digests[c.Image]
+		if !ok {
+			continue
+		}
+
+		ref, err := notation.DigestReference(c.Image, digest)
+		if err != nil {
+			log.Log.Errorf("could not pin %s to %s: %v", c.Image, digest, err)
+			continue
+		}
+
+		if ref == c.Image {
+			continue
+		}
+
+		patches = append(patches, admissioncontroller.PatchOperation{
+			Op:    admissioncontroller.PatchOpReplace,
+			Path:  c.Path,
+			Value: ref,
+		})
+		pinned = append(pinned, ref)
+	}
+
+	return patches, pinned
+}
@@ -0,0 +1,77 @@
+package workloads
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"notary-admission/pkg/admissioncontroller"
+)
+
+const (
+	digestA = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	digestB = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+)
+
+const podObject = `{
+  "kind": "Pod",
+  "metadata": {"name": "app", "namespace": "default"},
+  "spec": {
+    "containers": [
+      {"name": "app", "image": "registry.example.com/app:v1"},
+      {"name": "pinned", "image": "registry.example.com/app:v1@` + digestB + `"},
+      {"name": "sidecar", "image": "nginx"}
+    ],
+    "initContainers": [{"name": "init", "image": "registry.example.com/app:v1"}],
+    "ephemeralContainers": [{"name": "debug", "image": "busybox:1.36"}]
+  }
+}`
+
+func TestUnpinnedImages(t *testing.T) {
+	wl := parse([]byte(podObject))
+	if wl.Error != nil {
+		t.Fatal(wl.Error)
+	}
+
+	want := []string{"registry.example.com/app:v1", "nginx", "registry.example.com/app:v1", "busybox:1.36"}
+	if got := unpinnedImages(wl); !reflect.DeepEqual(got, want) {
+		t.Errorf("unpinnedImages = %v, want %v", got, want)
+	}
+}
+
+func TestPinPatches(t *testing.T) {
+	wl := parse([]byte(podObject))
+	if wl.Error != nil {
+		t.Fatal(wl.Error)
+	}
+
+	digests := map[string]string{
+		"registry.example.com/app:v1": digestA,
+		"busybox:1.36":                digestB,
+	}
+
+	patches, pinned := pinPatches(wl, digests)
+
+	want := []admissioncontroller.PatchOperation{
+		{Op: admissioncontroller.PatchOpReplace, Path: "/spec/containers/0/image",
+			Value: "registry.example.com/app@" + digestA},
+		{Op: admissioncontroller.PatchOpReplace, Path: "/spec/initContainers/0/image",
+			Value: "registry.example.com/app@" + digestA},
+		{Op: admissioncontroller.PatchOpReplace, Path: "/spec/ephemeralContainers/0/image",
+			Value: "docker.io/library/busybox@" + digestB},
+	}
+	if !reflect.DeepEqual(patches, want) {
+		got, _ := json.Marshal(patches)
+		t.Fatalf("patches = %s", got)
+	}
+	if len(pinned) != len(want) {
+		t.Errorf("pinned = %v", pinned)
+	}
+
+	// An image already in digest reference form is not patched
+	wl.Containers = []Container{{Path: "/spec/containers/0/image", Image: "registry.example.com/app@" + digestA}}
+	patches, _ = pinPatches(wl, map[string]string{"registry.example.com/app@" + digestA: digestA})
+	if len(patches) != 0 {
+		t.Errorf("patches = %+v, want none", patches)
+	}
+}
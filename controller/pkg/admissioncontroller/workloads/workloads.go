@@ -1,16 +1,27 @@
 package workloads
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	v1 "k8s.io/api/admission/v1"
 	a1 "k8s.io/api/apps/v1"
 	b1 "k8s.io/api/batch/v1"
 	pv1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"notary-admission/pkg/admissioncontroller"
 	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/audit"
+	"notary-admission/pkg/decision"
+	"notary-admission/pkg/exemption"
 	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
 	"notary-admission/pkg/notation"
+	"notary-admission/pkg/tracing"
+	"notary-admission/pkg/utils"
+	"strings"
+	"time"
 )
 
 // Result contains the result of an admission request
@@ -21,18 +32,37 @@ type Result struct {
 
 // Workload contains the workload processing data
 type Workload struct {
-	Kind      string
-	Name      string
-	Namespace string
-	Images    []string
-	Error     error
+	Kind       string
+	Name       string
+	Namespace  string
+	Images     []string
+	Containers []Container
+	// ServiceAccount and PullSecrets are used for registry auth, as kubelet would
+	ServiceAccount string
+	PullSecrets    []string
+	// Annotations of the workload, and of its pod template
+	Annotations map[string]string
+	// UID and Owner, the controller of the workload, if any, identify the workload in events
+	UID   string
+	Owner *meta.OwnerReference
+	// Enforcement is the enforcement mode of the image policy that denied the workload, if any
+	Enforcement string
+	Error       error
+}
+
+// Container contains the JSON pointer to a container image in the workload object
+type Container struct {
+	Path  string
+	Image string
 }
 
 // NewValidationHook creates a new instance of pods validation hook
 func NewValidationHook() admissioncontroller.Hook {
+	pdm := metrics.InitPrometheusDecisionMetric(model.ServerConfig().Prometheus.Name)
+
 	return admissioncontroller.Hook{
-		Create: validate(),
-		Update: validate(),
+		Create: validate(pdm),
+		Update: validate(pdm),
 	}
 }
 
@@ -50,7 +80,16 @@ func parse(object []byte) *Workload {
 
 	kind := result["kind"]
 	wl.Kind = kind.(string)
+
+	var om meta.PartialObjectMetadata
+	if err = json.Unmarshal(object, &om); err == nil {
+		wl.UID = string(om.UID)
+		wl.Owner = meta.GetControllerOf(&om)
+	}
+
 	var spec pv1.PodSpec
+	var annotations, templateAnnotations map[string]string
+	specPath := "/spec/template/spec"
 
 	switch wl.Kind {
 	case "Deployment":
@@ -61,6 +100,8 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = d.Name
 		wl.Namespace = d.Namespace
+		annotations = d.Annotations
+		templateAnnotations = d.Spec.Template.Annotations
 		spec = d.Spec.Template.Spec
 	case "Pod":
 		var p pv1.Pod
@@ -70,7 +111,9 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = p.Name
 		wl.Namespace = p.Namespace
+		annotations = p.Annotations
 		spec = p.Spec
+		specPath = "/spec"
 	case "ReplicaSet":
 		var r a1.ReplicaSet
 		if err = json.Unmarshal(object, &r); err != nil {
@@ -79,6 +122,8 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = r.Name
 		wl.Namespace = r.Namespace
+		annotations = r.Annotations
+		templateAnnotations = r.Spec.Template.Annotations
 		spec = r.Spec.Template.Spec
 	case "DaemonSet":
 		var d a1.DaemonSet
@@ -88,6 +133,8 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = d.Name
 		wl.Namespace = d.Namespace
+		annotations = d.Annotations
+		templateAnnotations = d.Spec.Template.Annotations
 		spec = d.Spec.Template.Spec
 	case "CronJob":
 		var c b1.CronJob
@@ -97,7 +144,10 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = c.Name
 		wl.Namespace = c.Namespace
+		annotations = c.Annotations
+		templateAnnotations = c.Spec.JobTemplate.Spec.Template.Annotations
 		spec = c.Spec.JobTemplate.Spec.Template.Spec
+		specPath = "/spec/jobTemplate/spec/template/spec"
 	case "Job":
 		var j b1.Job
 		if err = json.Unmarshal(object, &j); err != nil {
@@ -106,6 +156,8 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = j.Name
 		wl.Namespace = j.Namespace
+		annotations = j.Annotations
+		templateAnnotations = j.Spec.Template.Annotations
 		spec = j.Spec.Template.Spec
 	case "StatefulSet":
 		var s a1.StatefulSet
@@ -115,69 +167,370 @@ func parse(object []byte) *Workload {
 		}
 		wl.Name = s.Name
 		wl.Namespace = s.Namespace
+		annotations = s.Annotations
+		templateAnnotations = s.Spec.Template.Annotations
 		spec = s.Spec.Template.Spec
 	default: // unsupported kind
 		wl.Error = fmt.Errorf("kind %s not supported by validation controller", kind)
 		return &wl
 	}
 
+	setSpec(&wl, spec, specPath, annotations, templateAnnotations)
+
+	return &wl
+}
+
+// parseRequest parses the object of request ar, in a span of the request trace
+func parseRequest(ctx context.Context, ar *v1.AdmissionRequest) *Workload {
+	_, span := tracing.Start(ctx, "workloads.parse", tracing.AttrKind.String(ar.Kind.Kind))
+	defer span.End()
+
+	wl := parse(ar.Object.Raw)
+	if wl.Error != nil {
+		tracing.Fail(span, wl.Error)
+	}
+	span.SetAttributes(tracing.AttrName.String(wl.Name))
+
+	return wl
+}
+
+// setSpec sets the images, containers, ServiceAccount, annotations and pull secrets of workload wl from
+// its pod spec, at JSON pointer specPath. Ephemeral containers are only admitted by the webhooks with
+// the pods/ephemeralcontainers subresource, whose requests carry the whole Pod.
+func setSpec(wl *Workload, spec pv1.PodSpec, specPath string, annotations map[string]string,
+	templateAnnotations map[string]string) {
 	var images []string
-	for _, c := range spec.Containers {
+	var containers []Container
+	for i, c := range spec.Containers {
 		images = append(images, c.Image)
+		containers = append(containers, Container{
+			Path:  fmt.Sprintf("%s/containers/%d/image", specPath, i),
+			Image: c.Image,
+		})
 	}
-	for _, c := range spec.InitContainers {
+	for i, c := range spec.InitContainers {
 		images = append(images, c.Image)
+		containers = append(containers, Container{
+			Path:  fmt.Sprintf("%s/initContainers/%d/image", specPath, i),
+			Image: c.Image,
+		})
 	}
-	for _, c := range spec.EphemeralContainers {
+	for i, c := range spec.EphemeralContainers {
 		images = append(images, c.Image)
+		containers = append(containers, Container{
+			Path:  fmt.Sprintf("%s/ephemeralContainers/%d/image", specPath, i),
+			Image: c.Image,
+		})
 	}
 
 	wl.Images = images
+	wl.Containers = containers
+	wl.ServiceAccount = spec.ServiceAccountName
 
-	return &wl
+	// Workload annotations take precedence over pod template annotations
+	wl.Annotations = make(map[string]string)
+	for k, v := range templateAnnotations {
+		wl.Annotations[k] = v
+	}
+	for k, v := range annotations {
+		wl.Annotations[k] = v
+	}
+
+	for _, ps := range spec.ImagePullSecrets {
+		wl.PullSecrets = append(wl.PullSecrets, ps.Name)
+	}
+}
+
+// FromPod returns the workload of pod, as it would be admitted
+func FromPod(p *pv1.Pod) *Workload {
+	wl := &Workload{
+		Kind:      "Pod",
+		Name:      p.Name,
+		Namespace: p.Namespace,
+		UID:       string(p.UID),
+		Owner:     meta.GetControllerOf(p),
+	}
+	setSpec(wl, p.Spec, "/spec", p.Annotations, nil)
+
+	return wl
+}
+
+// validate validates workload operations. In warn and audit enforcement modes, denials are logged
+// and counted, but the request is allowed, with the denial returned as a warning. The decision record
+// of every request is emitted.
+func validate(pdm *metrics.PrometheusDecisionMetric) admissioncontroller.AdmitFunc {
+	return func(ctx context.Context, ar *v1.AdmissionRequest) (*admissioncontroller.Result, error) {
+		r := decision.NewRecord(ar)
+		wl := parseRequest(ctx, ar)
+
+		result := admit(ctx, pdm, wl, ar, r)
+
+		r.Allowed = result.Allowed
+		r.SetImages(result.Images)
+		decision.Emit(r)
+		audit.Record(r)
+
+		return result, nil
+	}
 }
 
-// validate validates workload operations
-func validate() admissioncontroller.AdmitFunc {
-	var w []string
-	return func(ar *v1.AdmissionRequest) (*admissioncontroller.Result, error) {
-		wl := parse(ar.Object.Raw)
-		if wl.Error != nil {
-			log.Log.Errorf("parse pod error: %v", wl.Error)
-			return &admissioncontroller.Result{Msg: wl.Error.Error()}, nil
+// admit verifies the workload of request ar, returning the admission result, and setting the workload,
+// verdict and enforcement mode of decision record r
+func admit(ctx context.Context, pdm *metrics.PrometheusDecisionMetric, wl *Workload, ar *v1.AdmissionRequest,
+	r *decision.Record) *admissioncontroller.Result {
+	r.Verdict = decision.VerdictDenied
+
+	if wl.Error != nil {
+		log.Log.Errorf("parse pod error: %v", wl.Error)
+		r.Reason = wl.Error.Error()
+		return &admissioncontroller.Result{Msg: wl.Error.Error()}
+	}
+
+	if wl.Namespace == "" {
+		wl.Namespace = ar.Namespace
+	}
+	setObject(r, wl)
+
+	log.Log.Debugf("workload: %+v", wl)
+
+	mode := enforcementMode(wl.Namespace)
+
+	v, denied := verifyWorkload(ctx, wl)
+	if denied != nil {
+		denied.Images = imageResults(v)
+		if wl.Enforcement != "" {
+			mode = wl.Enforcement
+		}
+		r.Enforcement = mode
+		r.Reason = denied.Msg
+		pdm.Decisions.WithLabelValues(wl.Namespace, mode, DecisionDenied).Inc()
+		if mode == model.EnforcementEnforce {
+			return denied
+		}
+
+		log.Log.Warnf("%s mode, %s %s in %s namespace would be denied: %s", mode, wl.Name, wl.Kind,
+			wl.Namespace, denied.Msg)
+		result := &admissioncontroller.Result{
+			Allowed:  true,
+			Msg:      denied.Msg,
+			Warnings: []string{fmt.Sprintf("%s mode, would be denied: %s", mode, denied.Msg)},
+			Images:   denied.Images,
+		}
+		if mode == model.EnforcementAudit {
+			result.AuditAnnotations = map[string]string{
+				"enforcement-mode": mode,
+				"would-deny":       denied.Msg,
+			}
 		}
+		return result
+	}
 
-		log.Log.Debugf("workload: %+v", wl)
+	r.Verdict = decision.VerdictAllowed
+	r.Enforcement = mode
+	pdm.Decisions.WithLabelValues(wl.Namespace, mode, DecisionAllowed).Inc()
 
-		log.Log.Debugf("workload images = %v", wl.Images)
-		v := verifier.GetEcrv().VerifySubjects(wl.Images)
+	var i, w []string
+	for _, res := range v.Responses {
+		i = append(i, res.Image)
+		w = append(w, res.Warning)
+	}
 
-		if v.Error != nil {
-			log.Log.Errorf("verification error: %s, %v", v.Message, v.Error)
-			return &admissioncontroller.Result{Msg: notation.ValidationFailed}, nil
+	message := fmt.Sprintf("%s %s in %s namespace, images verified: %v", wl.Name, wl.Kind, wl.Namespace, i)
+	log.Log.Debug(message)
+	return &admissioncontroller.Result{
+		Allowed:  true,
+		Msg:      message,
+		Warnings: w,
+		Images:   imageResults(v),
+	}
+}
+
+// setObject sets the workload, and its owner, of decision record r
+func setObject(r *decision.Record, wl *Workload) {
+	r.Object.Kind = wl.Kind
+	r.Object.Name = wl.Name
+	r.Object.Namespace = wl.Namespace
+	r.Object.UID = wl.UID
+
+	if wl.Owner != nil {
+		r.Owner = &decision.Object{
+			APIVersion: wl.Owner.APIVersion,
+			Kind:       wl.Owner.Kind,
+			Name:       wl.Owner.Name,
+			Namespace:  wl.Namespace,
+			UID:        string(wl.Owner.UID),
 		}
+	}
+}
 
-		var i []string
-		for _, res := range v.Responses {
-			log.Log.Debugf("notation Response for %s: %v", res.Image, res)
+// imageResults returns the image results of verification v, if any
+func imageResults(v *verifier.Verification) []admissioncontroller.ImageResult {
+	if v == nil {
+		return nil
+	}
 
-			if res.Error != nil {
-				log.Log.Debugf("%s %s , in %s namespace, notation response error: %v",
-					wl.Name, wl.Kind, wl.Namespace, res.Error)
-				return &admissioncontroller.Result{Msg: fmt.Sprintf("%s image, in %s %s, in %s namespace, failed signature validation",
-					res.Image, wl.Name, wl.Kind, wl.Namespace)}, nil
+	var results []admissioncontroller.ImageResult
+	for _, res := range v.Responses {
+		r := admissioncontroller.ImageResult{
+			Image:    res.Image,
+			Digest:   res.Digest,
+			Verified: res.Error == nil && !res.ByPassed,
+			Bypassed: res.ByPassed,
+			Signer:   res.Signer,
+			Policy:   res.Policy,
+			Message:  res.Warning,
+		}
+		if res.Error != nil {
+			r.Message = res.ErrorMessage
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// verifyWorkload verifies workload images, returning a denial result if any image failed verification,
+// with the verification, if the images were verified
+func verifyWorkload(ctx context.Context, wl *Workload) (*verifier.Verification, *admissioncontroller.Result) {
+	log.Log.Debugf("workload images = %v", wl.Images)
+
+	for _, i := range wl.Images {
+		if _, err := utils.ParseImageReference(i); err != nil {
+			log.Log.Debugf("%s %s, in %s namespace, has malformed image: %v", wl.Name, wl.Kind, wl.Namespace, err)
+			return nil, &admissioncontroller.Result{Msg: fmt.Sprintf("%s image, in %s %s, in %s namespace, is not a valid image reference",
+				i, wl.Name, wl.Kind, wl.Namespace)}
+		}
+	}
+
+	exempted, exemptErr := exemptImages(ctx, wl)
+	var images []string
+	for _, i := range wl.Images {
+		if _, ok := exempted[i]; !ok {
+			images = append(images, i)
+		}
+	}
+
+	v := verifier.VerifySubjects(ctx, verifier.Subjects{
+		Images:         images,
+		Namespace:      wl.Namespace,
+		ServiceAccount: wl.ServiceAccount,
+		PullSecrets:    wl.PullSecrets,
+	})
+
+	// Images that could not be verified are denied with the strictest enforcement mode that applies
+	if v.Error != nil {
+		log.Log.Errorf("verification error: %s, %v", v.Message, v.Error)
+		wl.Enforcement = errorEnforcement(wl.Namespace, images)
+		return nil, &admissioncontroller.Result{Msg: notation.ValidationFailed}
+	}
+
+	// Every failed image is reported, and the strictest enforcement mode of the failed images applies
+	var failed []string
+	for _, res := range v.Responses {
+		log.Log.Debugf("notation Response for %s: %v", res.Image, res)
+
+		if res.Error != nil {
+			log.Log.Debugf("%s %s , in %s namespace, notation response error: %v",
+				wl.Name, wl.Kind, wl.Namespace, res.Error)
+			msg := fmt.Sprintf("%s image, in %s %s, in %s namespace, failed signature validation",
+				res.Image, wl.Name, wl.Kind, wl.Namespace)
+			if res.Policy != "" {
+				msg = fmt.Sprintf("%s, of %s policy", msg, res.Policy)
 			}
+			failed = append(failed, msg)
 
-			i = append(i, res.Image)
-			w = append(w, res.Warning)
+			mode := res.Enforcement
+			if mode == "" {
+				mode = enforcementMode(wl.Namespace)
+			}
+			wl.Enforcement = strictestMode(wl.Enforcement, mode)
 		}
+	}
 
-		message := fmt.Sprintf("%s %s in %s namespace, images verified: %v", wl.Name, wl.Kind, wl.Namespace, i)
-		log.Log.Debug(message)
-		return &admissioncontroller.Result{
-			Allowed:  true,
-			Msg:      message,
-			Warnings: w,
-		}, nil
+	if len(failed) > 0 {
+		msg := strings.Join(failed, "; ")
+		if exemptErr != nil {
+			msg = fmt.Sprintf("%s, exemption rejected: %v", msg, exemptErr)
+		}
+		return &v, &admissioncontroller.Result{Msg: msg}
+	}
+
+	for _, i := range wl.Images {
+		if e, ok := exempted[i]; ok {
+			v.Responses = append(v.Responses, verifier.Response{
+				Image:    i,
+				ByPassed: true,
+				Warning: fmt.Sprintf("%s - %s until %s: %s", i, exemption.MsgExempted,
+					e.Expires().Format(time.RFC3339), e.Reason),
+			})
+			delete(exempted, i)
+		}
 	}
+
+	return &v, nil
+}
+
+// Verify verifies the images of workload wl as admission would, returning the denial result if any image
+// failed verification, nil otherwise, and the enforcement mode of the workload
+func Verify(ctx context.Context, wl *Workload) (*admissioncontroller.Result, string) {
+	mode := enforcementMode(wl.Namespace)
+
+	v, denied := verifyWorkload(ctx, wl)
+	if denied == nil {
+		return nil, mode
+	}
+
+	denied.Images = imageResults(v)
+	if wl.Enforcement != "" {
+		mode = wl.Enforcement
+	}
+
+	return denied, mode
+}
+
+// exemptImages returns the workload images exempted by a verified exemption annotation, if any.
+// Exemptions are counted unless the outcomes of ctx are not recorded.
+func exemptImages(ctx context.Context, wl *Workload) (map[string]*exemption.Exemption, error) {
+	exempted := make(map[string]*exemption.Exemption)
+
+	ev := exemption.Ev()
+	e, err := exemptionFor(ev, wl)
+	if err != nil {
+		log.Log.Warnf("%s %s, in %s namespace, exemption rejected: %v", wl.Name, wl.Kind, wl.Namespace, err)
+		if verifier.OutcomesRecorded(ctx) {
+			ev.Metric.Exemptions.WithLabelValues(wl.Namespace, exemption.ResultRejected).Inc()
+		}
+		return exempted, err
+	}
+	if e == nil {
+		return exempted, nil
+	}
+
+	for _, i := range wl.Images {
+		if _, ok := exempted[i]; ok || !e.Matches(i) {
+			continue
+		}
+		exempted[i] = e
+		log.Log.Infof("image %s, in %s %s, in %s namespace, exempted by %s (%s) until %s: %s", i, wl.Name, wl.Kind,
+			wl.Namespace, e.Subject, e.Key, e.Expires().Format(time.RFC3339), e.Reason)
+		if verifier.OutcomesRecorded(ctx) {
+			ev.Metric.Exemptions.WithLabelValues(wl.Namespace, exemption.ResultApplied).Inc()
+		}
+	}
+
+	return exempted, nil
+}
+
+// exemptionFor returns the exemption annotated on workload wl, verified by ev, if any
+func exemptionFor(ev *exemption.Verifier, wl *Workload) (*exemption.Exemption, error) {
+	if ev == nil {
+		return nil, nil
+	}
+
+	token, ok := wl.Annotations[ev.Annotation()]
+	if !ok {
+		return nil, nil
+	}
+
+	return ev.Verify(token, wl.Namespace)
 }
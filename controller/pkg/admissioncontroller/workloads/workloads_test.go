@@ -0,0 +1,36 @@
+package workloads
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestVerifyWorkloadMalformedImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+	}{
+		{name: "uppercase", image: "Nginx"},
+		{name: "empty tag", image: "nginx:"},
+		{name: "short digest", image: "nginx@sha256:abc"},
+		{name: "space", image: "host:5000/x y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wl := &Workload{Kind: "Pod", Name: "app", Namespace: "default", Images: []string{"nginx", tt.image}}
+
+			v, denied := verifyWorkload(context.Background(), wl)
+			if v != nil {
+				t.Errorf("verification = %+v, want none", v)
+			}
+			if denied == nil || denied.Allowed {
+				t.Fatalf("result = %+v, want denial", denied)
+			}
+			if !strings.Contains(denied.Msg, tt.image+" image, in app Pod, in default namespace, is not a valid image reference") {
+				t.Errorf("denial message = %q", denied.Msg)
+			}
+		})
+	}
+}
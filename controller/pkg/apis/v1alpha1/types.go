@@ -0,0 +1,56 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group    = "notary-admission.aws"
+	Version  = "v1alpha1"
+	Kind     = "ImageVerificationPolicy"
+	Resource = "imageverificationpolicies"
+
+	ConditionAccepted = "Accepted"
+	ReasonValid       = "Valid"
+	ReasonInvalid     = "Invalid"
+)
+
+// GroupVersionResource of ImageVerificationPolicy
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// ImageVerificationPolicy is a cluster-scoped image signature verification policy
+type ImageVerificationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ImageVerificationPolicySpec   `json:"spec"`
+	Status ImageVerificationPolicyStatus `json:"status,omitempty"`
+}
+
+// ImageVerificationPolicySpec declares which images, in which namespaces, must be signed by which signers
+type ImageVerificationPolicySpec struct {
+	// RegistryScopes are registry/repository glob patterns of the images the policy applies to,
+	// a trailing /* matches all repositories below the prefix
+	RegistryScopes []string `json:"registryScopes"`
+	// Signers are the notation trusted identities required, e.g. AWS Signer signing profile ARNs
+	Signers []string `json:"signers,omitempty"`
+	// TrustStores are the notation trust stores, the configured signingAuthority trust store by default
+	TrustStores []string `json:"trustStores,omitempty"`
+	// VerificationLevel is the notation signature verification level, strict by default
+	VerificationLevel string `json:"verificationLevel,omitempty"`
+	// Enforcement is the enforcement mode of the policy, enforce, warn or audit
+	Enforcement string `json:"enforcement,omitempty"`
+	// Namespaces are namespace name glob patterns
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects namespaces by label
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Exemptions are registry/repository glob patterns of images exempted from verification
+	Exemptions []string `json:"exemptions,omitempty"`
+}
+
+// ImageVerificationPolicyStatus reports whether the policy was accepted
+type ImageVerificationPolicyStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
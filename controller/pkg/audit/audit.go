@@ -0,0 +1,342 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"notary-admission/pkg/decision"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultFile    = "/audit/decisions.log"
+	DefaultMaxSize = 100
+
+	// backupTimeFormat sorts rotated files chronologically
+	backupTimeFormat = "20060102T150405.000000000Z"
+	megabyte         = 1024 * 1024
+)
+
+// Entry is an audit log line, the decision record, numbered by its sequence in the log. With hash
+// chaining, entries also carry the hash of the previous entry, and are followed by their own hash.
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	*decision.Record
+	PrevHash string `json:"prevHash,omitempty"`
+}
+
+// Logger appends entries to a file, rotated by size and age, keeping a number of rotated files
+type Logger struct {
+	lock       sync.Mutex
+	file       string
+	f          *os.File
+	size       int64
+	opened     time.Time
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	hashChain  bool
+	seq        uint64
+	last       string
+	closed     bool
+}
+
+var al atomic.Pointer[Logger]
+
+// Al returns the audit Logger, nil if the audit log is not enabled
+func Al() *Logger {
+	return al.Load()
+}
+
+// InitAudit opens the audit Logger, if the audit log is enabled
+func InitAudit() error {
+	c := model.ServerConfig().Audit
+	if !c.Enabled {
+		return nil
+	}
+
+	l, err := NewLogger(c)
+	if err != nil {
+		return err
+	}
+	al.Store(l)
+
+	return nil
+}
+
+// NewLogger opens the audit log file of config c, continuing the sequence, and hash chain, of its
+// last entry, or of the last entry of the newest rotated file
+func NewLogger(c model.AuditConfig) (*Logger, error) {
+	l := &Logger{
+		file:       c.File,
+		maxSize:    int64(c.MaxSize) * megabyte,
+		interval:   time.Duration(c.RotateInterval) * time.Second,
+		maxBackups: c.MaxBackups,
+		hashChain:  c.HashChain,
+	}
+	if l.file == "" {
+		l.file = DefaultFile
+	}
+	if l.maxSize <= 0 {
+		l.maxSize = DefaultMaxSize * megabyte
+	}
+
+	if err := utils.CreateDirectory(filepath.Dir(l.file)); err != nil {
+		return nil, fmt.Errorf("could not create audit log directory: %w", err)
+	}
+
+	last, first, err := lastEntry(l.file)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		if backups := l.backups(); len(backups) > 0 {
+			if last, _, err = lastEntry(backups[len(backups)-1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if last != nil {
+		l.seq = last.Seq
+		l.last = last.Hash
+	}
+
+	if err = l.open(); err != nil {
+		return nil, err
+	}
+	if !first.IsZero() {
+		l.opened = first
+	}
+
+	log.Log.Infof("audit log %s opened at seq %d, hash chaining: %t", l.file, l.seq, l.hashChain)
+
+	return l, nil
+}
+
+// Write appends the entry of decision record r, rotating the file first if due
+func (l *Logger) Write(r *decision.Record) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return fmt.Errorf("audit log %s is closed", l.file)
+	}
+
+	// The file is reopened if a rotation failed
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	if l.rotateDue() {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	e := Entry{Seq: l.seq + 1, Record: r}
+	if l.hashChain {
+		e.PrevHash = l.last
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal audit entry: %w", err)
+	}
+
+	hash := ""
+	if l.hashChain {
+		hash = Hash(b)
+		b = appendHash(b, hash)
+	}
+	b = append(b, '\n')
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("could not write audit log %s: %w", l.file, err)
+	}
+
+	l.seq = e.Seq
+	l.last = hash
+
+	return nil
+}
+
+// Close closes the audit log file
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.closed = true
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+// Record writes decision record r to the audit log, if enabled. Failures are logged, not returned,
+// so they never change the admission decision.
+func Record(r *decision.Record) {
+	l := Al()
+	if l == nil {
+		return
+	}
+
+	if err := l.Write(r); err != nil {
+		log.Log.Errorf("could not audit %s decision: %v", r.UID, err)
+	}
+}
+
+// open opens, or creates, the audit log file for appending
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log %s: %w", l.file, err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not stat audit log %s: %w", l.file, err)
+	}
+
+	l.f = f
+	l.size = fi.Size()
+	l.opened = time.Now()
+
+	return nil
+}
+
+// rotateDue determines if the file exceeds the maximum size, or was opened longer than the rotation interval
+func (l *Logger) rotateDue() bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.size >= l.maxSize {
+		return true
+	}
+	return l.interval > 0 && time.Since(l.opened) >= l.interval
+}
+
+// rotate renames the file with its rotation time, opens a new file, and removes the oldest rotated files
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("could not close audit log %s: %w", l.file, err)
+	}
+	l.f = nil
+
+	backup := l.backupName(time.Now())
+	if err := os.Rename(l.file, backup); err != nil {
+		return fmt.Errorf("could not rotate audit log %s: %w", l.file, err)
+	}
+
+	// The chain head is logged, so a rewritten chain can be detected from the server logs
+	log.Log.Infof("audit log rotated to %s, seq: %d, hash: %s", backup, l.seq, l.last)
+
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.maxBackups <= 0 {
+		return nil
+	}
+	backups := l.backups()
+	for len(backups) > l.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			log.Log.Warnf("could not remove rotated audit log %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// backupName returns the name of the file rotated at t, later than t if a rotated file has that name
+func (l *Logger) backupName(t time.Time) string {
+	ext := filepath.Ext(l.file)
+	for {
+		name := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(l.file, ext), t.UTC().Format(backupTimeFormat), ext)
+		if !utils.FileExists(name) {
+			return name
+		}
+		t = t.Add(time.Nanosecond)
+	}
+}
+
+// backups returns the rotated files, oldest first
+func (l *Logger) backups() []string {
+	ext := filepath.Ext(l.file)
+	matches, _ := filepath.Glob(strings.TrimSuffix(l.file, ext) + "-*" + ext)
+	sort.Strings(matches)
+	return matches
+}
+
+// lastEntry returns the last valid entry of file, and its hash, and the time of its first entry,
+// nil if the file does not exist or has no valid entry
+func lastEntry(file string) (*chainEntry, time.Time, error) {
+	var first time.Time
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, first, nil
+	}
+	if err != nil {
+		return nil, first, fmt.Errorf("could not read audit log %s: %w", file, err)
+	}
+	defer f.Close()
+
+	var last *chainEntry
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			e, perr := parseLine(line)
+			if perr != nil {
+				log.Log.Warnf("audit log %s has a malformed entry after seq %d: %v", file, seqOf(last), perr)
+			} else {
+				if last == nil && e.Time != nil {
+					first = *e.Time
+				}
+				last = e
+			}
+		}
+		if err == io.EOF {
+			return last, first, nil
+		}
+		if err != nil {
+			return nil, first, fmt.Errorf("could not read audit log %s: %w", file, err)
+		}
+	}
+}
+
+func seqOf(e *chainEntry) uint64 {
+	if e == nil {
+		return 0
+	}
+	return e.Seq
+}
+
+// Hash returns the hex SHA-256 hash of an entry, marshalled without its hash
+func Hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
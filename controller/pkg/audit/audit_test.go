@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+
+	"notary-admission/pkg/decision"
+	"notary-admission/pkg/model"
+)
+
+// writeLog writes n decision records to a new audit log in a temporary directory, returning its file
+func writeLog(t *testing.T, n int, hashChain bool) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "decisions.log")
+	l, err := NewLogger(model.AuditConfig{File: file, HashChain: hashChain})
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < n; i++ {
+		r := decision.NewRecord(&v1.AdmissionRequest{Name: "app", Namespace: "default"})
+		r.Allowed = i%2 == 0
+		if err = l.Write(r); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	return file
+}
+
+// readLines returns the lines of file
+func readLines(t *testing.T, file string) [][]byte {
+	t.Helper()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+}
+
+// writeLines replaces the content of file with lines
+func writeLines(t *testing.T, file string, lines [][]byte) {
+	t.Helper()
+
+	b := append(bytes.Join(lines, []byte("\n")), '\n')
+	if err := os.WriteFile(file, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteVerify(t *testing.T) {
+	file := writeLog(t, 3, true)
+
+	s, err := Verify([]string{file})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if s.Entries != 3 || s.First != 1 || s.Last != 3 || s.PrevHash != "" || s.Hash == "" {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+}
+
+func TestWriteContinuesChain(t *testing.T) {
+	file := writeLog(t, 2, true)
+
+	l, err := NewLogger(model.AuditConfig{File: file, HashChain: true})
+	if err != nil {
+		t.Fatalf("NewLogger: %v", err)
+	}
+	if err = l.Write(decision.NewRecord(&v1.AdmissionRequest{Name: "app"})); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = l.Close()
+
+	s, err := Verify([]string{file})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if s.Entries != 3 || s.Last != 3 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		want   string
+	}{
+		{
+			name: "modified",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"allowed":false`), []byte(`"allowed":true`), 1)
+				return lines
+			},
+			want: "entry 2 hash does not match its content",
+		},
+		{
+			name: "removed",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			want: "entry 3 follows entry 1",
+		},
+		{
+			name: "reordered",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			want: "entry 3 follows entry 1",
+		},
+		{
+			name: "hash removed",
+			tamper: func(lines [][]byte) [][]byte {
+				i := bytes.LastIndex(lines[0], hashPrefix)
+				lines[0] = append(lines[0][:i:i], '}')
+				return lines
+			},
+			want: "entry 1 has no hash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := writeLog(t, 3, true)
+			writeLines(t, file, tt.tamper(readLines(t, file)))
+
+			_, err := Verify([]string{file})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Verify error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutHashChain(t *testing.T) {
+	file := writeLog(t, 1, false)
+
+	_, err := Verify([]string{file})
+	if err == nil || !strings.Contains(err.Error(), "hash chaining was not enabled") {
+		t.Errorf("Verify error = %v, want hash chaining not enabled", err)
+	}
+}
@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	hashPrefix = []byte(`,"hash":"`)
+	hashSuffix = []byte(`"}`)
+)
+
+// hashLength is the length of a hex SHA-256 hash
+const hashLength = 64
+
+// chainEntry is the sequence and hash chain of an audit log entry
+type chainEntry struct {
+	Seq      uint64     `json:"seq"`
+	Time     *time.Time `json:"time"`
+	PrevHash string     `json:"prevHash"`
+	Hash     string     `json:"-"`
+	// body is the entry without its hash, as hashed
+	body []byte
+}
+
+// Summary is the result of the verification of audit log files
+type Summary struct {
+	Entries int    `json:"entries"`
+	First   uint64 `json:"first"`
+	Last    uint64 `json:"last"`
+	// PrevHash is the hash preceding the first entry verified, Hash the hash of the last entry
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// appendHash appends the hash field to the marshalled entry b
+func appendHash(b []byte, hash string) []byte {
+	out := make([]byte, 0, len(b)+len(hashPrefix)+hashLength+len(hashSuffix)-1)
+	out = append(out, b[:len(b)-1]...)
+	out = append(out, hashPrefix...)
+	out = append(out, hash...)
+	return append(out, hashSuffix...)
+}
+
+// parseLine parses an audit log line, splitting the trailing hash field, if any, from the hashed entry
+func parseLine(line []byte) (*chainEntry, error) {
+	line = bytes.TrimSpace(line)
+	body := line
+	hash := ""
+
+	i := bytes.LastIndex(line, hashPrefix)
+	if i >= 0 && len(line)-i == len(hashPrefix)+hashLength+len(hashSuffix) && bytes.HasSuffix(line, hashSuffix) {
+		hash = string(line[i+len(hashPrefix) : i+len(hashPrefix)+hashLength])
+		body = append(append([]byte{}, line[:i]...), '}')
+	}
+
+	var e chainEntry
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, err
+	}
+	e.Hash = hash
+	e.body = body
+
+	return &e, nil
+}
+
+// Verify verifies the hash chain of audit log files, given oldest first: that each entry hash matches
+// its content, and that each entry follows the previous one in sequence and chain. Entries rotated
+// out before the first file are not verified, so the first entry may follow any hash.
+func Verify(files []string) (*Summary, error) {
+	s := &Summary{}
+	var prev *chainEntry
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return s, fmt.Errorf("could not read %s: %w", file, err)
+		}
+
+		r := bufio.NewReader(f)
+		for n := 1; ; n++ {
+			line, rerr := r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				e, err := verifyEntry(line, prev)
+				if err != nil {
+					_ = f.Close()
+					return s, fmt.Errorf("%s:%d: %w", file, n, err)
+				}
+				if prev == nil {
+					s.First = e.Seq
+					s.PrevHash = e.PrevHash
+				}
+				s.Entries++
+				s.Last = e.Seq
+				s.Hash = e.Hash
+				prev = e
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				_ = f.Close()
+				return s, fmt.Errorf("could not read %s: %w", file, rerr)
+			}
+		}
+		_ = f.Close()
+	}
+
+	return s, nil
+}
+
+// verifyEntry verifies an audit log line, following entry prev
+func verifyEntry(line []byte, prev *chainEntry) (*chainEntry, error) {
+	e, err := parseLine(line)
+	if err != nil {
+		return nil, fmt.Errorf("malformed entry: %w", err)
+	}
+
+	if e.Hash == "" {
+		return nil, fmt.Errorf("entry %d has no hash, hash chaining was not enabled", e.Seq)
+	}
+	if Hash(e.body) != e.Hash {
+		return nil, fmt.Errorf("entry %d hash does not match its content, the entry was modified", e.Seq)
+	}
+
+	if prev == nil {
+		return e, nil
+	}
+	if e.Seq != prev.Seq+1 {
+		return nil, fmt.Errorf("entry %d follows entry %d, entries were removed or reordered", e.Seq, prev.Seq)
+	}
+	if e.PrevHash != prev.Hash {
+		return nil, fmt.Errorf("entry %d previous hash does not match entry %d hash, the chain is broken",
+			e.Seq, prev.Seq)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,559 @@
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+)
+
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation    = "dev.cosignproject.cosign/signature"
+	CertificateAnnotation  = "dev.sigstore.cosign/certificate"
+	ChainAnnotation        = "dev.sigstore.cosign/chain"
+	BundleAnnotation       = "dev.sigstore.cosign/bundle"
+	SignatureTagSuffix     = ".sig"
+	HashedRekordKind       = "hashedrekord"
+	MaxManifestSize        = 4 * 1024 * 1024
+)
+
+var (
+	// Fulcio OIDC issuer certificate extensions, raw string (deprecated) and DER UTF8String
+	oidIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// ErrorVerificationFailed is returned when no cosign signature of an image could be verified
+type ErrorVerificationFailed struct {
+	Msg string
+}
+
+func (e ErrorVerificationFailed) Error() string {
+	return e.Msg
+}
+
+type identity struct {
+	subject       string
+	subjectRegExp *regexp.Regexp
+	issuer        string
+	issuerRegExp  *regexp.Regexp
+}
+
+// publicKey is a cosign public key, and the file it was loaded from
+type publicKey struct {
+	file string
+	key  crypto.PublicKey
+}
+
+// Verifier verifies cosign image signatures with public keys, or keyless with Fulcio
+// certificates. Transparency log inclusion is verified offline, with Rekor bundles.
+type Verifier struct {
+	keys          []publicKey
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	rekorKeys     map[string]crypto.PublicKey
+	identities    []identity
+	keyless       bool
+	ignoreTlog    bool
+}
+
+// NewVerifier creates a Verifier from cosign config
+func NewVerifier(c model.CosignConfig) (*Verifier, error) {
+	v := Verifier{
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+		rekorKeys:     make(map[string]crypto.PublicKey),
+		ignoreTlog:    c.IgnoreTlog,
+	}
+
+	for _, k := range c.Keys {
+		key, err := loadPublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, publicKey{file: k, key: key})
+	}
+
+	if c.TrustedRoot != "" {
+		if err := v.loadTrustedRoot(c.TrustedRoot); err != nil {
+			return nil, err
+		}
+		v.keyless = true
+	}
+
+	if c.FulcioRoots != "" {
+		if err := v.loadFulcioRoots(c.FulcioRoots); err != nil {
+			return nil, err
+		}
+		v.keyless = true
+	}
+
+	if c.RekorKeys != "" {
+		if err := v.loadRekorKeys(c.RekorKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, i := range c.Identities {
+		// As with cosign, an identity without a subject, or issuer, would trust any certificate
+		if i.Subject == "" && i.SubjectRegExp == "" {
+			return nil, fmt.Errorf("cosign identity requires a subject, or subject regexp")
+		}
+		if i.Issuer == "" && i.IssuerRegExp == "" {
+			return nil, fmt.Errorf("cosign identity requires an issuer, or issuer regexp")
+		}
+
+		id := identity{subject: i.Subject, issuer: i.Issuer}
+		if i.SubjectRegExp != "" {
+			re, err := regexp.Compile(i.SubjectRegExp)
+			if err != nil {
+				return nil, fmt.Errorf("malformed cosign subject regexp %s: %w", i.SubjectRegExp, err)
+			}
+			id.subjectRegExp = re
+		}
+		if i.IssuerRegExp != "" {
+			re, err := regexp.Compile(i.IssuerRegExp)
+			if err != nil {
+				return nil, fmt.Errorf("malformed cosign issuer regexp %s: %w", i.IssuerRegExp, err)
+			}
+			id.issuerRegExp = re
+		}
+		v.identities = append(v.identities, id)
+	}
+
+	switch {
+	case len(v.keys) == 0 && !v.keyless:
+		return nil, fmt.Errorf("cosign requires keys, or a trusted root or Fulcio roots")
+	case v.keyless && len(v.identities) == 0:
+		return nil, fmt.Errorf("cosign keyless verification requires identities")
+	case v.keyless && v.ignoreTlog:
+		// Without the Rekor integrated time, short-lived Fulcio certificates cannot be verified
+		return nil, fmt.Errorf("cosign keyless verification requires the transparency log")
+	case !v.ignoreTlog && len(v.rekorKeys) == 0:
+		return nil, fmt.Errorf("cosign requires Rekor keys, unless the transparency log is ignored")
+	}
+
+	return &v, nil
+}
+
+// Verify verifies the cosign signatures of the image digest reference, returning the signer identity
+// of the first valid signature, the public key file, or the keyless certificate identity. Only
+// signatures of the .sig tag are verified, not attestations, nor OCI 1.1 referrers.
+func (v *Verifier) Verify(ctx context.Context, image string, username string, password string) (string, error) {
+	repo, err := notation.NewRepository(image, username, password)
+	if err != nil {
+		return "", err
+	}
+
+	digest := repo.Reference.Reference
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("cosign verification requires a sha256 digest reference: %s", image)
+	}
+
+	tag := strings.Replace(digest, ":", "-", 1) + SignatureTagSuffix
+	desc, rc, err := repo.FetchReference(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errdef.ErrNotFound) {
+			return "", ErrorVerificationFailed{Msg: fmt.Sprintf("no cosign signature found for %s", image)}
+		}
+		return "", fmt.Errorf("could not fetch cosign signature of %s: %w", image, err)
+	}
+	defer rc.Close()
+
+	b, err := content.ReadAll(io.LimitReader(rc, MaxManifestSize), desc)
+	if err != nil {
+		return "", fmt.Errorf("could not read cosign signature of %s: %w", image, err)
+	}
+
+	var manifest ocispec.Manifest
+	if err = json.Unmarshal(b, &manifest); err != nil {
+		return "", fmt.Errorf("could not parse cosign signature of %s: %w", image, err)
+	}
+
+	var errs []string
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != SimpleSigningMediaType {
+			continue
+		}
+
+		payload, err := content.FetchAll(ctx, repo.Blobs(), layer)
+		if err != nil {
+			return "", fmt.Errorf("could not fetch cosign payload of %s: %w", image, err)
+		}
+
+		signer, err := v.verifyLayer(layer, payload, digest)
+		if err != nil {
+			log.Log.Debugf("cosign signature %s of %s not verified: %v", layer.Digest, image, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		log.Log.Debugf("cosign signature %s of %s verified, signer: %s", layer.Digest, image, signer)
+		return signer, nil
+	}
+
+	if len(errs) == 0 {
+		return "", ErrorVerificationFailed{Msg: fmt.Sprintf("no cosign signature found for %s", image)}
+	}
+
+	return "", ErrorVerificationFailed{
+		Msg: fmt.Sprintf("no valid cosign signature for %s: %s", image, strings.Join(errs, "; ")),
+	}
+}
+
+// verifyLayer verifies a simple signing payload and its signature, certificate, and Rekor bundle,
+// returning the signer identity
+func (v *Verifier) verifyLayer(layer ocispec.Descriptor, payload []byte, digest string) (string, error) {
+	var ss struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return "", fmt.Errorf("malformed payload: %w", err)
+	}
+	if ss.Critical.Image.DockerManifestDigest != digest {
+		return "", fmt.Errorf("payload digest %s does not match %s", ss.Critical.Image.DockerManifestDigest, digest)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[SignatureAnnotation])
+	if err != nil || len(sig) == 0 {
+		return "", fmt.Errorf("missing or malformed signature")
+	}
+
+	// Unverified bundles are not used, so the transparency log is ignored entirely if configured
+	var b *bundle
+	if !v.ignoreTlog {
+		s := layer.Annotations[BundleAnnotation]
+		if s == "" {
+			return "", fmt.Errorf("missing Rekor bundle")
+		}
+		b = &bundle{}
+		if err = json.Unmarshal([]byte(s), b); err != nil {
+			return "", fmt.Errorf("malformed Rekor bundle: %w", err)
+		}
+		if err = v.verifyBundle(b, payload, sig); err != nil {
+			return "", err
+		}
+	}
+
+	if certPEM := layer.Annotations[CertificateAnnotation]; certPEM != "" {
+		return v.verifyKeyless(layer, certPEM, payload, sig, b)
+	}
+
+	for _, key := range v.keys {
+		if verifySignature(key.key, payload, sig) == nil {
+			if err = b.matchesKey(key.key); err != nil {
+				return "", err
+			}
+			return key.file, nil
+		}
+	}
+
+	return "", fmt.Errorf("signature not verified with configured keys")
+}
+
+// verifyKeyless verifies the signing certificate chain at the signing time, the signature,
+// and the certificate identity, returning the identity
+func (v *Verifier) verifyKeyless(layer ocispec.Descriptor, certPEM string, payload []byte, sig []byte,
+	b *bundle) (string, error) {
+	if !v.keyless {
+		return "", fmt.Errorf("keyless signature, but keyless verification not configured")
+	}
+
+	certs, err := parseCertificates([]byte(certPEM))
+	if err != nil {
+		return "", fmt.Errorf("malformed certificate: %w", err)
+	}
+	cert := certs[0]
+
+	intermediates := v.intermediates.Clone()
+	if chain := layer.Annotations[ChainAnnotation]; chain != "" {
+		cs, err := parseCertificates([]byte(chain))
+		if err != nil {
+			return "", fmt.Errorf("malformed certificate chain: %w", err)
+		}
+		for _, c := range cs {
+			intermediates.AddCert(c)
+		}
+	}
+
+	// Fulcio certificates are short-lived, so are verified at the Rekor integrated time
+	if b == nil {
+		return "", fmt.Errorf("keyless signature requires a Rekor bundle")
+	}
+	signed := time.Unix(b.Payload.IntegratedTime, 0)
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   signed,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return "", fmt.Errorf("certificate not verified: %w", err)
+	}
+
+	if err = verifySignature(cert.PublicKey, payload, sig); err != nil {
+		return "", err
+	}
+
+	if err = b.matchesCertificate(cert); err != nil {
+		return "", err
+	}
+
+	issuer := certificateIssuer(cert)
+	subjects := append([]string{}, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+
+	for _, id := range v.identities {
+		if id.matches(subjects, issuer) {
+			return fmt.Sprintf("%s, issued by %s", strings.Join(subjects, ", "), issuer), nil
+		}
+	}
+
+	return "", fmt.Errorf("certificate identity %v issued by %s not trusted", subjects, issuer)
+}
+
+// matches determines if any certificate subject, and the issuer, match the identity
+func (i identity) matches(subjects []string, issuer string) bool {
+	if i.issuer != "" && i.issuer != issuer {
+		return false
+	}
+	if i.issuerRegExp != nil && !i.issuerRegExp.MatchString(issuer) {
+		return false
+	}
+
+	for _, s := range subjects {
+		if i.subject != "" && i.subject != s {
+			continue
+		}
+		if i.subjectRegExp != nil && !i.subjectRegExp.MatchString(s) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// certificateIssuer returns the Fulcio OIDC issuer of cert
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuer) {
+			return string(ext.Value)
+		}
+	}
+
+	return ""
+}
+
+// verifySignature verifies sig over the digest of payload, as cosign signs. ECDSA keys use the
+// hash of their curve, other keys SHA-256.
+func verifySignature(key crypto.PublicKey, payload []byte, sig []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		h, err := curveHash(k)
+		if err != nil {
+			return err
+		}
+		hh := h.New()
+		hh.Write(payload)
+		if !ecdsa.VerifyASN1(k, hh.Sum(nil), sig) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	return nil
+}
+
+// curveHash returns the hash of the curve of ECDSA key k, as sigstore signs
+func curveHash(k *ecdsa.PublicKey) (crypto.Hash, error) {
+	switch k.Curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	case elliptic.P521():
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+	}
+}
+
+// bundle is the offline Rekor bundle of a cosign signature
+type bundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+	Payload              struct {
+		// Fields are ordered as in the canonical JSON signed by Rekor
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	} `json:"Payload"`
+	entry hashedRekord
+}
+
+// hashedRekord is the Rekor entry body of a cosign signature
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyBundle verifies the Rekor signed entry timestamp, and that the entry is for payload and sig
+func (v *Verifier) verifyBundle(b *bundle, payload []byte, sig []byte) error {
+	key, ok := v.rekorKeys[b.Payload.LogID]
+	if !ok {
+		return fmt.Errorf("Rekor bundle log ID %s not trusted", b.Payload.LogID)
+	}
+
+	canonical, err := json.Marshal(b.Payload)
+	if err != nil {
+		return fmt.Errorf("could not canonicalize Rekor bundle: %w", err)
+	}
+	if err = verifySignature(key, canonical, b.SignedEntryTimestamp); err != nil {
+		return fmt.Errorf("Rekor signed entry timestamp not verified: %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return fmt.Errorf("malformed Rekor entry: %w", err)
+	}
+	if err = json.Unmarshal(body, &b.entry); err != nil {
+		return fmt.Errorf("malformed Rekor entry: %w", err)
+	}
+	if b.entry.Kind != HashedRekordKind {
+		return fmt.Errorf("unsupported Rekor entry kind %s", b.entry.Kind)
+	}
+
+	sum := sha256.Sum256(payload)
+	if !strings.EqualFold(b.entry.Spec.Data.Hash.Value, hex.EncodeToString(sum[:])) {
+		return fmt.Errorf("Rekor entry hash does not match payload")
+	}
+
+	entrySig, err := base64.StdEncoding.DecodeString(b.entry.Spec.Signature.Content)
+	if err != nil || !bytes.Equal(entrySig, sig) {
+		return fmt.Errorf("Rekor entry signature does not match")
+	}
+
+	return nil
+}
+
+// entryKey returns the DER bytes of the PEM public key or certificate of the Rekor entry
+func (b *bundle) entryKey() ([]byte, error) {
+	p, err := base64.StdEncoding.DecodeString(b.entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return nil, fmt.Errorf("malformed Rekor entry public key: %w", err)
+	}
+
+	block, _ := pem.Decode(p)
+	if block == nil {
+		return nil, fmt.Errorf("malformed Rekor entry public key")
+	}
+
+	return block.Bytes, nil
+}
+
+// matchesKey verifies the Rekor entry was made with key, if there is a bundle
+func (b *bundle) matchesKey(key crypto.PublicKey) error {
+	if b == nil {
+		return nil
+	}
+
+	der, err := b.entryKey()
+	if err != nil {
+		return err
+	}
+
+	expected, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("could not marshal cosign key: %w", err)
+	}
+
+	if !bytes.Equal(der, expected) {
+		return fmt.Errorf("Rekor entry public key does not match")
+	}
+
+	return nil
+}
+
+// matchesCertificate verifies the Rekor entry was made with cert, if there is a bundle
+func (b *bundle) matchesCertificate(cert *x509.Certificate) error {
+	if b == nil {
+		return nil
+	}
+
+	der, err := b.entryKey()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(der, cert.Raw) {
+		return fmt.Errorf("Rekor entry certificate does not match")
+	}
+
+	return nil
+}
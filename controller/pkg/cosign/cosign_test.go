@@ -0,0 +1,598 @@
+package cosign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"notary-admission/pkg/model"
+)
+
+const (
+	digest      = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	otherDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	issuer      = "https://token.actions.githubusercontent.com"
+	subject     = "https://github.com/example/app/.github/workflows/release.yml@refs/heads/main"
+)
+
+// fixture holds the keys and certificates signing test signatures and Rekor bundles
+type fixture struct {
+	t        *testing.T
+	key      *ecdsa.PrivateKey
+	rekor    *ecdsa.PrivateKey
+	rekorID  string
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	leaf     *x509.Certificate
+	leafKey  *ecdsa.PrivateKey
+	leafPEM  string
+	signedAt time.Time
+	verifier *Verifier
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	f := &fixture{t: t, signedAt: time.Now().Add(-time.Hour).Truncate(time.Second)}
+	f.key = generateKey(t, elliptic.P256())
+	f.rekor = generateKey(t, elliptic.P256())
+	f.rootKey = generateKey(t, elliptic.P256())
+	f.leafKey = generateKey(t, elliptic.P256())
+
+	der, err := x509.MarshalPKIXPublicKey(f.rekor.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	f.rekorID = hex.EncodeToString(sum[:])
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio test root"},
+		NotBefore:             f.signedAt.Add(-24 * time.Hour),
+		NotAfter:              f.signedAt.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	f.root = createCertificate(t, rootTemplate, rootTemplate, f.rootKey.Public(), f.rootKey)
+
+	// Fulcio certificates are valid for 10 minutes, long expired by now
+	issuerExt, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, _ := url.Parse(subject)
+	leafTemplate := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       f.signedAt.Add(-time.Minute),
+		NotAfter:        f.signedAt.Add(9 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{san},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuerExt}},
+	}
+	f.leaf = createCertificate(t, leafTemplate, f.root, f.leafKey.Public(), f.rootKey)
+	f.leafPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.leaf.Raw}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(f.root)
+	f.verifier = &Verifier{
+		keys:          []publicKey{{file: "cosign.pub", key: f.key.Public()}},
+		roots:         roots,
+		intermediates: x509.NewCertPool(),
+		rekorKeys:     map[string]crypto.PublicKey{f.rekorID: f.rekor.Public()},
+		identities:    []identity{{subjectRegExp: regexp.MustCompile(`^https://github.com/example/`), issuer: issuer}},
+		keyless:       true,
+	}
+
+	return f
+}
+
+func generateKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+
+	k, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func createCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, pub crypto.PublicKey,
+	priv crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// payload returns the simple signing payload of digest
+func payload(digest string) []byte {
+	return []byte(`{"critical":{"identity":{"docker-reference":"registry.example.com/app"},` +
+		`"image":{"docker-manifest-digest":"` + digest + `"},"type":"cosign container image signature"},"optional":null}`)
+}
+
+// sign signs b with key, as cosign does, with the hash of the key curve
+func sign(t *testing.T, key *ecdsa.PrivateKey, b []byte) []byte {
+	t.Helper()
+
+	var d []byte
+	switch key.Curve {
+	case elliptic.P384():
+		s := sha512.Sum384(b)
+		d = s[:]
+	case elliptic.P521():
+		s := sha512.Sum512(b)
+		d = s[:]
+	default:
+		s := sha256.Sum256(b)
+		d = s[:]
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, key, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// bundle returns a Rekor bundle of payload p and signature sig, made with the PEM public key or
+// certificate keyPEM, and signed by the fixture Rekor key
+func (f *fixture) bundle(p []byte, sig []byte, keyPEM []byte) *bundle {
+	f.t.Helper()
+
+	var e hashedRekord
+	e.Kind = HashedRekordKind
+	sum := sha256.Sum256(p)
+	e.Spec.Data.Hash.Algorithm = "sha256"
+	e.Spec.Data.Hash.Value = hex.EncodeToString(sum[:])
+	e.Spec.Signature.Content = base64.StdEncoding.EncodeToString(sig)
+	e.Spec.Signature.PublicKey.Content = base64.StdEncoding.EncodeToString(keyPEM)
+	body, err := json.Marshal(e)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	b := &bundle{}
+	b.Payload.Body = base64.StdEncoding.EncodeToString(body)
+	b.Payload.IntegratedTime = f.signedAt.Unix()
+	b.Payload.LogID = f.rekorID
+	b.Payload.LogIndex = 42
+	f.signSET(b)
+
+	return b
+}
+
+// signSET signs the signed entry timestamp of bundle b with the fixture Rekor key
+func (f *fixture) signSET(b *bundle) {
+	f.t.Helper()
+
+	canonical, err := json.Marshal(b.Payload)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	b.SignedEntryTimestamp = sign(f.t, f.rekor, canonical)
+}
+
+// keyPEM returns the PEM public key of key
+func keyPEM(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// layer returns the signature layer descriptor of sig, with bundle b and PEM certificate cert, if any
+func layer(t *testing.T, sig []byte, b *bundle, cert string) ocispec.Descriptor {
+	t.Helper()
+
+	annotations := map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	if b != nil {
+		s, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		annotations[BundleAnnotation] = string(s)
+	}
+	if cert != "" {
+		annotations[CertificateAnnotation] = cert
+	}
+
+	return ocispec.Descriptor{MediaType: SimpleSigningMediaType, Annotations: annotations}
+}
+
+func TestVerifyLayer(t *testing.T) {
+	f := newFixture(t)
+	p := payload(digest)
+
+	keySig := sign(t, f.key, p)
+	keyBundle := f.bundle(p, keySig, keyPEM(t, f.key.Public()))
+
+	certSig := sign(t, f.leafKey, p)
+	certBundle := f.bundle(p, certSig, []byte(f.leafPEM))
+
+	other := generateKey(t, elliptic.P256())
+	otherSig := sign(t, other, p)
+
+	tampered := f.bundle(p, keySig, keyPEM(t, f.key.Public()))
+	tampered.Payload.IntegratedTime++
+
+	untrusted := f.bundle(p, keySig, keyPEM(t, f.key.Public()))
+	untrusted.Payload.LogID = strings.Repeat("0", 64)
+
+	wrongKey := f.bundle(p, keySig, keyPEM(t, other.Public()))
+
+	otherIssuer := *f.verifier
+	otherIssuer.identities = []identity{{subjectRegExp: regexp.MustCompile(`.*`), issuer: "https://accounts.google.com"}}
+
+	keysOnly := *f.verifier
+	keysOnly.keyless = false
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		layer    ocispec.Descriptor
+		digest   string
+		want     string
+		wantErr  string
+	}{
+		{name: "key", layer: layer(t, keySig, keyBundle, ""), want: "cosign.pub"},
+		{
+			name:  "keyless",
+			layer: layer(t, certSig, certBundle, f.leafPEM),
+			want:  subject + ", issued by " + issuer,
+		},
+		{
+			name:    "digest mismatch",
+			layer:   layer(t, keySig, keyBundle, ""),
+			digest:  otherDigest,
+			wantErr: "does not match",
+		},
+		{name: "missing signature", layer: layer(t, nil, keyBundle, ""), wantErr: "missing or malformed signature"},
+		{name: "missing bundle", layer: layer(t, keySig, nil, ""), wantErr: "missing Rekor bundle"},
+		{
+			name:    "untrusted key",
+			layer:   layer(t, otherSig, f.bundle(p, otherSig, keyPEM(t, other.Public())), ""),
+			wantErr: "signature not verified with configured keys",
+		},
+		{
+			name:    "bundle of other key",
+			layer:   layer(t, keySig, wrongKey, ""),
+			wantErr: "Rekor entry public key does not match",
+		},
+		{
+			name:    "tampered bundle",
+			layer:   layer(t, keySig, tampered, ""),
+			wantErr: "Rekor signed entry timestamp not verified",
+		},
+		{
+			name:    "untrusted log",
+			layer:   layer(t, keySig, untrusted, ""),
+			wantErr: "not trusted",
+		},
+		{
+			name:     "keyless untrusted identity",
+			verifier: &otherIssuer,
+			layer:    layer(t, certSig, certBundle, f.leafPEM),
+			wantErr:  "not trusted",
+		},
+		{
+			name:     "keyless not configured",
+			verifier: &keysOnly,
+			layer:    layer(t, certSig, certBundle, f.leafPEM),
+			wantErr:  "keyless verification not configured",
+		},
+		{
+			name:    "keyless bundle of other certificate",
+			layer:   layer(t, certSig, f.bundle(p, certSig, keyPEM(t, f.leafKey.Public())), f.leafPEM),
+			wantErr: "Rekor entry certificate does not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.verifier
+			if v == nil {
+				v = f.verifier
+			}
+			d := tt.digest
+			if d == "" {
+				d = digest
+			}
+
+			got, err := v.verifyLayer(tt.layer, p, d)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifyLayer error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyLayer: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("verifyLayer = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyLayerIgnoreTlog(t *testing.T) {
+	p := payload(digest)
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			key := generateKey(t, curve)
+			v := &Verifier{keys: []publicKey{{file: "cosign.pub", key: key.Public()}}, ignoreTlog: true}
+
+			got, err := v.verifyLayer(layer(t, sign(t, key, p), nil, ""), p, digest)
+			if err != nil {
+				t.Fatalf("verifyLayer: %v", err)
+			}
+			if got != "cosign.pub" {
+				t.Errorf("verifyLayer = %s, want cosign.pub", got)
+			}
+		})
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	f := newFixture(t)
+	p := payload(digest)
+	sig := sign(t, f.key, p)
+	pub := keyPEM(t, f.key.Public())
+
+	tests := []struct {
+		name    string
+		bundle  func() *bundle
+		wantErr string
+	}{
+		{name: "valid", bundle: func() *bundle { return f.bundle(p, sig, pub) }},
+		{
+			name: "untrusted log",
+			bundle: func() *bundle {
+				b := f.bundle(p, sig, pub)
+				b.Payload.LogID = strings.Repeat("f", 64)
+				return b
+			},
+			wantErr: "not trusted",
+		},
+		{
+			name: "tampered log index",
+			bundle: func() *bundle {
+				b := f.bundle(p, sig, pub)
+				b.Payload.LogIndex++
+				return b
+			},
+			wantErr: "signed entry timestamp not verified",
+		},
+		{
+			name:    "other payload",
+			bundle:  func() *bundle { return f.bundle(payload(otherDigest), sig, pub) },
+			wantErr: "Rekor entry hash does not match payload",
+		},
+		{
+			name:    "other signature",
+			bundle:  func() *bundle { return f.bundle(p, sign(t, f.key, p), pub) },
+			wantErr: "Rekor entry signature does not match",
+		},
+		{
+			name: "unsupported kind",
+			bundle: func() *bundle {
+				b := f.bundle(p, sig, pub)
+				b.Payload.Body = base64.StdEncoding.EncodeToString([]byte(`{"kind":"intoto"}`))
+				f.signSET(b)
+				return b
+			},
+			wantErr: "unsupported Rekor entry kind",
+		},
+		{
+			name: "malformed body",
+			bundle: func() *bundle {
+				b := f.bundle(p, sig, pub)
+				b.Payload.Body = "!"
+				f.signSET(b)
+				return b
+			},
+			wantErr: "malformed Rekor entry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := f.verifier.verifyBundle(tt.bundle(), p, sig)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyBundle: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifyBundle error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIdentityMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity identity
+		subjects []string
+		issuer   string
+		want     bool
+	}{
+		{
+			name:     "subject and issuer",
+			identity: identity{subject: "dev@example.com", issuer: "https://accounts.google.com"},
+			subjects: []string{"dev@example.com"},
+			issuer:   "https://accounts.google.com",
+			want:     true,
+		},
+		{
+			name:     "other subject",
+			identity: identity{subject: "dev@example.com", issuer: "https://accounts.google.com"},
+			subjects: []string{"ops@example.com"},
+			issuer:   "https://accounts.google.com",
+		},
+		{
+			name:     "other issuer",
+			identity: identity{subject: "dev@example.com", issuer: "https://accounts.google.com"},
+			subjects: []string{"dev@example.com"},
+			issuer:   "https://github.com/login/oauth",
+		},
+		{
+			name:     "any subject matches",
+			identity: identity{subject: "dev@example.com", issuer: "https://accounts.google.com"},
+			subjects: []string{"ops@example.com", "dev@example.com"},
+			issuer:   "https://accounts.google.com",
+			want:     true,
+		},
+		{
+			name: "regexps",
+			identity: identity{subjectRegExp: regexp.MustCompile(`^https://github.com/example/`),
+				issuerRegExp: regexp.MustCompile(`^https://token\.actions\.githubusercontent\.com$`)},
+			subjects: []string{subject},
+			issuer:   issuer,
+			want:     true,
+		},
+		{
+			name:     "subject regexp not matching",
+			identity: identity{subjectRegExp: regexp.MustCompile(`^https://github.com/example/`), issuer: issuer},
+			subjects: []string{"https://github.com/attacker/app/.github/workflows/release.yml@refs/heads/main"},
+			issuer:   issuer,
+		},
+		{
+			name:     "issuer regexp not matching",
+			identity: identity{subject: subject, issuerRegExp: regexp.MustCompile(`^https://accounts\.google\.com$`)},
+			subjects: []string{subject},
+			issuer:   issuer,
+		},
+		{
+			name:     "no subjects",
+			identity: identity{subject: subject, issuer: issuer},
+			issuer:   issuer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.matches(tt.subjects, tt.issuer); got != tt.want {
+				t.Errorf("matches(%v, %s) = %t, want %t", tt.subjects, tt.issuer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	f := newFixture(t)
+	dir := t.TempDir()
+
+	write := func(name string, b []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	roots := write("fulcio.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.root.Raw}))
+	rekor := write("rekor.pem", keyPEM(t, f.rekor.Public()))
+	key := write("cosign.pub", keyPEM(t, f.key.Public()))
+
+	tests := []struct {
+		name    string
+		config  model.CosignConfig
+		wantErr string
+	}{
+		{name: "keys", config: model.CosignConfig{Keys: []string{key}, RekorKeys: rekor}},
+		{name: "keys ignoring tlog", config: model.CosignConfig{Keys: []string{key}, IgnoreTlog: true}},
+		{
+			name: "keyless",
+			config: model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor,
+				Identities: []model.CosignIdentity{{SubjectRegExp: "^https://github.com/example/", Issuer: issuer}}},
+		},
+		{name: "nothing", config: model.CosignConfig{RekorKeys: rekor}, wantErr: "requires keys"},
+		{name: "no rekor keys", config: model.CosignConfig{Keys: []string{key}}, wantErr: "requires Rekor keys"},
+		{
+			name:    "keyless without identities",
+			config:  model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor},
+			wantErr: "requires identities",
+		},
+		{
+			name: "keyless ignoring tlog",
+			config: model.CosignConfig{FulcioRoots: roots, IgnoreTlog: true,
+				Identities: []model.CosignIdentity{{Subject: "dev@example.com", Issuer: issuer}}},
+			wantErr: "requires the transparency log",
+		},
+		{
+			name: "identity without subject",
+			config: model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor,
+				Identities: []model.CosignIdentity{{Issuer: issuer}}},
+			wantErr: "requires a subject",
+		},
+		{
+			name: "identity without issuer",
+			config: model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor,
+				Identities: []model.CosignIdentity{{Subject: "dev@example.com"}}},
+			wantErr: "requires an issuer",
+		},
+		{
+			name: "empty identity",
+			config: model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor,
+				Identities: []model.CosignIdentity{{}}},
+			wantErr: "requires a subject",
+		},
+		{
+			name: "malformed regexp",
+			config: model.CosignConfig{FulcioRoots: roots, RekorKeys: rekor,
+				Identities: []model.CosignIdentity{{SubjectRegExp: "(", Issuer: issuer}}},
+			wantErr: "malformed cosign subject regexp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewVerifier(tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewVerifier: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewVerifier error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
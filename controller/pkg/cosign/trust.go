@@ -0,0 +1,169 @@
+package cosign
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"notary-admission/pkg/utils"
+)
+
+// TrustedRoot stores the subset of a Sigstore trusted_root.json used for offline verification
+type TrustedRoot struct {
+	Tlogs []struct {
+		PublicKey struct {
+			RawBytes string `json:"rawBytes"`
+		} `json:"publicKey"`
+	} `json:"tlogs"`
+	CertificateAuthorities []struct {
+		CertChain struct {
+			Certificates []struct {
+				RawBytes string `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"certChain"`
+	} `json:"certificateAuthorities"`
+}
+
+// loadTrustedRoot adds the Fulcio CA chains and Rekor keys of a trusted_root.json file.
+// The last certificate of each chain is the root, the others are intermediates.
+func (v *Verifier) loadTrustedRoot(file string) error {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read trusted root: %w", err)
+	}
+
+	var tr TrustedRoot
+	if err = json.Unmarshal(b, &tr); err != nil {
+		return fmt.Errorf("could not parse trusted root: %w", err)
+	}
+
+	for _, ca := range tr.CertificateAuthorities {
+		certs := ca.CertChain.Certificates
+		for i, c := range certs {
+			der, err := base64.StdEncoding.DecodeString(c.RawBytes)
+			if err != nil {
+				return fmt.Errorf("could not decode trusted root certificate: %w", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return fmt.Errorf("could not parse trusted root certificate: %w", err)
+			}
+			if i == len(certs)-1 {
+				v.roots.AddCert(cert)
+			} else {
+				v.intermediates.AddCert(cert)
+			}
+		}
+	}
+
+	for _, t := range tr.Tlogs {
+		der, err := base64.StdEncoding.DecodeString(t.PublicKey.RawBytes)
+		if err != nil {
+			return fmt.Errorf("could not decode trusted root Rekor key: %w", err)
+		}
+		if err = v.addRekorKey(der); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFulcioRoots adds the PEM Fulcio certificates of file. Self-signed certificates are roots,
+// the others are intermediates.
+func (v *Verifier) loadFulcioRoots(file string) error {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read Fulcio roots: %w", err)
+	}
+
+	certs, err := parseCertificates(b)
+	if err != nil {
+		return fmt.Errorf("could not parse Fulcio roots: %w", err)
+	}
+
+	for _, c := range certs {
+		if c.CheckSignatureFrom(c) == nil {
+			v.roots.AddCert(c)
+		} else {
+			v.intermediates.AddCert(c)
+		}
+	}
+
+	return nil
+}
+
+// loadRekorKeys adds the PEM Rekor public keys of file
+func (v *Verifier) loadRekorKeys(file string) error {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read Rekor keys: %w", err)
+	}
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if err = v.addRekorKey(block.Bytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addRekorKey adds a PKIX DER Rekor public key, by its log ID
+func (v *Verifier) addRekorKey(der []byte) error {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("could not parse Rekor key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	v.rekorKeys[hex.EncodeToString(sum[:])] = key
+
+	return nil
+}
+
+// loadPublicKey reads a PEM public key file
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read cosign key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode cosign key %s", file)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse cosign key %s: %w", file, err)
+	}
+
+	return key, nil
+}
+
+// parseCertificates parses PEM certificates
+func parseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found")
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,123 @@
+package decision
+
+import (
+	"time"
+
+	v1 "k8s.io/api/admission/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"notary-admission/pkg/admissioncontroller"
+	log "notary-admission/pkg/logging"
+)
+
+const (
+	VerdictAllowed = "allowed"
+	VerdictDenied  = "denied"
+
+	ImageVerified = "verified"
+	ImageBypassed = "bypassed"
+	ImageFailed   = "failed"
+
+	MsgDecision = "admission decision"
+)
+
+// Record is the decision record of an admission request
+type Record struct {
+	Time      time.Time `json:"time"`
+	UID       string    `json:"uid"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups,omitempty"`
+	Operation string    `json:"operation"`
+	// Object is the workload admitted, Owner its controller, if any
+	Object Object  `json:"object"`
+	Owner  *Object `json:"owner,omitempty"`
+	// Verdict is the verification decision, allowed or denied, and Allowed whether the request was
+	// admitted, per the Enforcement mode
+	Verdict     string  `json:"verdict"`
+	Allowed     bool    `json:"allowed"`
+	Enforcement string  `json:"enforcement,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+	Images      []Image `json:"images,omitempty"`
+}
+
+// Object identifies a Kubernetes object
+type Object struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// Image is the decision record of a workload image
+type Image struct {
+	Image   string `json:"image"`
+	Digest  string `json:"digest,omitempty"`
+	Verdict string `json:"verdict"`
+	Signer  string `json:"signer,omitempty"`
+	Policy  string `json:"policy,omitempty"`
+	// Reason is the verification failure, or bypass, reason
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewRecord creates the decision record of admission request ar, identifying the object from the request
+func NewRecord(ar *v1.AdmissionRequest) *Record {
+	return &Record{
+		Time:      time.Now().UTC(),
+		UID:       string(ar.UID),
+		User:      ar.UserInfo.Username,
+		Groups:    ar.UserInfo.Groups,
+		Operation: string(ar.Operation),
+		Object: Object{
+			APIVersion: meta.GroupVersion{Group: ar.Kind.Group, Version: ar.Kind.Version}.String(),
+			Kind:       ar.Kind.Kind,
+			Name:       ar.Name,
+			Namespace:  ar.Namespace,
+		},
+	}
+}
+
+// SetImages sets the image decision records from the image verification results
+func (r *Record) SetImages(results []admissioncontroller.ImageResult) {
+	r.Images = nil
+	for _, res := range results {
+		i := Image{
+			Image:  res.Image,
+			Digest: res.Digest,
+			Signer: res.Signer,
+			Policy: res.Policy,
+			Reason: res.Message,
+		}
+		switch {
+		case res.Bypassed:
+			i.Verdict = ImageBypassed
+		case res.Verified:
+			i.Verdict = ImageVerified
+			// Verified image messages are notation warnings, not reasons
+			i.Reason = ""
+		default:
+			i.Verdict = ImageFailed
+		}
+		r.Images = append(r.Images, i)
+	}
+}
+
+// Bypassed returns the images of the record whose verification was bypassed
+func (r *Record) Bypassed() []Image {
+	var images []Image
+	for _, i := range r.Images {
+		if i.Verdict == ImageBypassed {
+			images = append(images, i)
+		}
+	}
+	return images
+}
+
+// Emit logs the decision record, and emits its Kubernetes Events, if enabled
+func Emit(r *Record) {
+	log.Log.Infow(MsgDecision, "decision", r)
+
+	if er := Er(); er != nil {
+		er.emit(r)
+	}
+}
@@ -0,0 +1,137 @@
+package decision
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	pv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+)
+
+const (
+	DefaultComponent = "notary-admission"
+
+	ReasonVerificationFailed   = "ImageVerificationFailed"
+	ReasonVerificationBypassed = "ImageVerificationBypassed"
+	ReasonReverificationFailed = "ImageReverificationFailed"
+
+	// MaxEventMessage is the maximum length of an event message accepted by the API server
+	MaxEventMessage = 1024
+)
+
+// EventRecorder emits Kubernetes Events for denied workloads, and workloads with bypassed images,
+// on the workload owner, if any, as pods of controllers are denied before they exist
+type EventRecorder struct {
+	recorder record.EventRecorder
+}
+
+var er atomic.Pointer[EventRecorder]
+
+// Er returns the EventRecorder, nil if events are not enabled
+func Er() *EventRecorder {
+	return er.Load()
+}
+
+// InitEvents starts the EventRecorder, if events are enabled
+func InitEvents(stop chan struct{}) error {
+	c := model.ServerConfig()
+	if !c.Kubernetes.Events.Enabled {
+		return nil
+	}
+
+	client, err := kube.GetClient()
+	if err != nil {
+		return err
+	}
+
+	component := c.Name
+	if component == "" {
+		component = DefaultComponent
+	}
+
+	// Events are aggregated, and rate limited, by the broadcaster
+	b := record.NewBroadcaster()
+	b.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	go func() {
+		<-stop
+		b.Shutdown()
+	}()
+
+	er.Store(&EventRecorder{
+		recorder: b.NewRecorder(scheme.Scheme, pv1.EventSource{Component: component}),
+	})
+	log.Log.Infof("%s events enabled", component)
+
+	return nil
+}
+
+// emit emits the Events of decision record r
+func (e *EventRecorder) emit(r *Record) {
+	ref := r.involvedObject()
+	if ref == nil {
+		log.Log.Debugf("%s %s in %s namespace has no name, no event emitted", r.Object.Kind, r.Object.Name,
+			r.Object.Namespace)
+		return
+	}
+
+	// Denial reasons name the workload
+	if r.Verdict == VerdictDenied {
+		msg := r.Reason
+		if r.Allowed {
+			msg = fmt.Sprintf("%s mode, would be denied: %s", r.Enforcement, r.Reason)
+		}
+		e.recorder.Event(ref, pv1.EventTypeWarning, ReasonVerificationFailed, truncate(msg))
+	}
+
+	if bypassed := r.Bypassed(); len(bypassed) > 0 {
+		var reasons []string
+		for _, i := range bypassed {
+			reasons = append(reasons, i.Reason)
+		}
+		msg := fmt.Sprintf("%s: %s", strings.TrimSpace(r.Object.Kind+" "+r.Object.Name), strings.Join(reasons, "; "))
+		e.recorder.Event(ref, pv1.EventTypeNormal, ReasonVerificationBypassed, truncate(msg))
+	}
+}
+
+// Event emits an Event on the object of ref, if events are enabled
+func Event(ref *pv1.ObjectReference, eventType string, reason string, msg string) {
+	if e := Er(); e != nil {
+		e.recorder.Event(ref, eventType, reason, truncate(msg))
+	}
+}
+
+// involvedObject returns the reference of the owner of the record object, if any, otherwise of the
+// object, nil if the object has no name, such as pods with generated names
+func (r *Record) involvedObject() *pv1.ObjectReference {
+	o := r.Object
+	if r.Owner != nil {
+		o = *r.Owner
+	}
+	if o.Name == "" {
+		return nil
+	}
+
+	return &pv1.ObjectReference{
+		APIVersion: o.APIVersion,
+		Kind:       o.Kind,
+		Name:       o.Name,
+		Namespace:  o.Namespace,
+		UID:        types.UID(o.UID),
+	}
+}
+
+// truncate truncates msg to the maximum event message length
+func truncate(msg string) string {
+	if len(msg) <= MaxEventMessage {
+		return msg
+	}
+	return msg[:MaxEventMessage-3] + "..."
+}
@@ -0,0 +1,337 @@
+package exemption
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultAnnotation = "notary-admission/exemption"
+	MsgExempted       = "image verification exempted"
+
+	ResultApplied  = "applied"
+	ResultRejected = "rejected"
+
+	// ClockSkew is the tolerated clock difference of exemption issuers
+	ClockSkew = time.Minute
+)
+
+// Claims are the JWS payload of an exemption
+type Claims struct {
+	// Images are image references, or bare digests, exempted from verification
+	Images    []string `json:"images"`
+	Namespace string   `json:"namespace"`
+	Reason    string   `json:"reason"`
+	Subject   string   `json:"sub,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Expiry    int64    `json:"exp"`
+}
+
+// Exemption is a verified exemption
+type Exemption struct {
+	Claims
+	// Key is the file of the admin key that verified the exemption
+	Key string
+}
+
+type header struct {
+	Alg string `json:"alg"`
+}
+
+type adminKey struct {
+	file string
+	key  crypto.PublicKey
+}
+
+// Verifier verifies exemption annotations signed by admin keys
+type Verifier struct {
+	keys        []adminKey
+	annotation  string
+	maxLifetime time.Duration
+	Metric      *metrics.PrometheusExemptionMetric
+}
+
+var (
+	ev         atomic.Pointer[Verifier]
+	metricOnce sync.Once
+	metric     *metrics.PrometheusExemptionMetric
+)
+
+// Ev returns the active Verifier, nil if exemptions are not enabled
+func Ev() *Verifier {
+	return ev.Load()
+}
+
+// InitExemptions creates the active Verifier, if exemption keys are configured
+func InitExemptions() error {
+	swap, err := PrepareExemptions(model.ServerConfig())
+	if err != nil {
+		return err
+	}
+	swap()
+	return nil
+}
+
+// PrepareExemptions loads the exemption admin keys of config c, returning the function
+// that swaps in its Verifier
+func PrepareExemptions(c *model.Config) (func(), error) {
+	e := c.Exemptions
+	if len(e.Keys) == 0 {
+		return func() { ev.Store(nil) }, nil
+	}
+
+	metricOnce.Do(func() {
+		metric = metrics.InitPrometheusExemptionMetric(c.Prometheus.Name)
+	})
+
+	v := &Verifier{
+		annotation:  e.Annotation,
+		maxLifetime: time.Duration(e.MaxLifetime) * time.Second,
+		Metric:      metric,
+	}
+	if v.annotation == "" {
+		v.annotation = DefaultAnnotation
+	}
+
+	for _, f := range e.Keys {
+		k, err := loadPublicKey(f)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, adminKey{file: f, key: k})
+	}
+
+	return func() {
+		ev.Store(v)
+		log.Log.Infof("exemptions enabled, %s annotation, %d admin keys", v.annotation, len(v.keys))
+	}, nil
+}
+
+// Annotation returns the exemption annotation name
+func (v *Verifier) Annotation() string {
+	return v.annotation
+}
+
+// Verify verifies the JWS compact serialized token with the admin keys, and its claims
+// against namespace and the current time
+func (v *Verifier) Verify(token string, namespace string) (*Exemption, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("exemption is not a JWS compact serialization")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not decode exemption header: %w", err)
+	}
+	var h header
+	if err = json.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("could not parse exemption header: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("could not decode exemption signature: %w", err)
+	}
+
+	input := []byte(parts[0] + "." + parts[1])
+	var key string
+	for _, k := range v.keys {
+		if verifySignature(h.Alg, k.key, input, sig) == nil {
+			key = k.file
+			break
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("exemption %s signature not verified by any admin key", h.Alg)
+	}
+
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("could not decode exemption payload: %w", err)
+	}
+	e := Exemption{Key: key}
+	if err = json.Unmarshal(b, &e.Claims); err != nil {
+		return nil, fmt.Errorf("could not parse exemption payload: %w", err)
+	}
+
+	if err = v.validate(e.Claims, namespace, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// validate validates the claims of a verified exemption
+func (v *Verifier) validate(c Claims, namespace string, now time.Time) error {
+	if len(c.Images) == 0 {
+		return fmt.Errorf("exemption has no images")
+	}
+	if c.Reason == "" {
+		return fmt.Errorf("exemption has no reason")
+	}
+	if c.Namespace != namespace {
+		return fmt.Errorf("exemption is for %q namespace, not %s", c.Namespace, namespace)
+	}
+	if c.Expiry == 0 {
+		return fmt.Errorf("exemption has no expiry")
+	}
+
+	exp := time.Unix(c.Expiry, 0)
+	if !now.Before(exp) {
+		return fmt.Errorf("exemption expired at %s", exp.UTC().Format(time.RFC3339))
+	}
+
+	issued := now
+	if c.IssuedAt != 0 {
+		issued = time.Unix(c.IssuedAt, 0)
+		// A future issue time would shorten the lifetime checked below
+		if issued.After(now.Add(ClockSkew)) {
+			return fmt.Errorf("exemption issued in the future, at %s", issued.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if v.maxLifetime > 0 {
+		if exp.Sub(issued) > v.maxLifetime {
+			return fmt.Errorf("exemption lifetime exceeds %s", v.maxLifetime)
+		}
+	}
+
+	return nil
+}
+
+// Expires returns the exemption expiry time
+func (e *Exemption) Expires() time.Time {
+	return time.Unix(e.Expiry, 0).UTC()
+}
+
+// Matches determines if image is exempted, by reference, by tag, or by digest
+func (e *Exemption) Matches(image string) bool {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return false
+	}
+
+	for _, i := range e.Images {
+		if strings.HasPrefix(i, "sha256:") {
+			if ref.Digest == i {
+				return true
+			}
+			continue
+		}
+
+		er, err := utils.ParseImageReference(i)
+		if err != nil || er.Name() != ref.Name() {
+			continue
+		}
+
+		if er.Digest != "" {
+			if er.Digest == ref.Digest {
+				return true
+			}
+			continue
+		}
+
+		if er.Tag == ref.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifySignature verifies the JWS signature over input, for algorithms matching the key type, and
+// for ECDSA keys, the key curve, as RFC 7518 binds ES256 to P-256, ES384 to P-384 and ES512 to P-521
+func verifySignature(alg string, key crypto.PublicKey, input []byte, sig []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		var digest []byte
+		var curve elliptic.Curve
+		switch alg {
+		case "ES256":
+			d := sha256.Sum256(input)
+			digest, curve = d[:], elliptic.P256()
+		case "ES384":
+			d := sha512.Sum384(input)
+			digest, curve = d[:], elliptic.P384()
+		case "ES512":
+			d := sha512.Sum512(input)
+			digest, curve = d[:], elliptic.P521()
+		default:
+			return fmt.Errorf("%s not supported for ECDSA keys", alg)
+		}
+		if k.Curve != curve {
+			return fmt.Errorf("%s not supported for ECDSA %s keys", alg, k.Curve.Params().Name)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(input)
+		switch alg {
+		case "RS256":
+			return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+		case "PS256":
+			return rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil)
+		default:
+			return fmt.Errorf("%s not supported for RSA keys", alg)
+		}
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return fmt.Errorf("%s not supported for Ed25519 keys", alg)
+		}
+		if !ed25519.Verify(k, input, sig) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	return nil
+}
+
+// loadPublicKey loads a PEM encoded PKIX public key
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read exemption key: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("could not decode exemption key %s", file)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse exemption key %s: %w", file, err)
+	}
+
+	return key, nil
+}
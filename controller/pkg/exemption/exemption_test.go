@@ -0,0 +1,228 @@
+package exemption
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// sign returns the JWS compact serialization of claims, with alg in its header, signed with key. ECDSA
+// signatures use the hash of alg, whatever the key curve.
+func sign(t *testing.T, alg string, key crypto.Signer, claims Claims) string {
+	t.Helper()
+
+	h, _ := json.Marshal(header{Alg: alg})
+	p, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		var d []byte
+		switch alg {
+		case "ES384":
+			s := sha512.Sum384([]byte(input))
+			d = s[:]
+		case "ES512":
+			s := sha512.Sum512([]byte(input))
+			d = s[:]
+		default:
+			s := sha256.Sum256([]byte(input))
+			d = s[:]
+		}
+		r, s, serr := ecdsa.Sign(rand.Reader, k, d)
+		if serr != nil {
+			t.Fatal(serr)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case *rsa.PrivateKey:
+		d := sha256.Sum256([]byte(input))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, d[:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	v := &Verifier{
+		keys: []adminKey{
+			{file: "p256.pem", key: p256.Public()},
+			{file: "p384.pem", key: p384.Public()},
+			{file: "p521.pem", key: p521.Public()},
+			{file: "rsa.pem", key: rsaKey.Public()},
+			{file: "ed25519.pem", key: edKey.Public()},
+		},
+		maxLifetime: 24 * time.Hour,
+	}
+
+	now := time.Now()
+	valid := Claims{
+		Images:    []string{"nginx@" + digest},
+		Namespace: "default",
+		Reason:    "INC-1234 hotfix",
+		Subject:   "oncall@example.com",
+		IssuedAt:  now.Unix(),
+		Expiry:    now.Add(time.Hour).Unix(),
+	}
+	with := func(f func(c *Claims)) Claims {
+		c := valid
+		f(&c)
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		key     string
+		wantErr string
+	}{
+		{name: "ES256", token: sign(t, "ES256", p256, valid), key: "p256.pem"},
+		{name: "ES384", token: sign(t, "ES384", p384, valid), key: "p384.pem"},
+		{name: "ES512", token: sign(t, "ES512", p521, valid), key: "p521.pem"},
+		{name: "RS256", token: sign(t, "RS256", rsaKey, valid), key: "rsa.pem"},
+		{name: "EdDSA", token: sign(t, "EdDSA", edKey, valid), key: "ed25519.pem"},
+		{name: "no iat", token: sign(t, "ES256", p256, with(func(c *Claims) { c.IssuedAt = 0 })), key: "p256.pem"},
+		{
+			name:    "alg not bound to curve",
+			token:   sign(t, "ES384", p256, valid),
+			wantErr: "not verified by any admin key",
+		},
+		{
+			name:    "alg not matching key type",
+			token:   sign(t, "RS256", edKey, valid),
+			wantErr: "not verified by any admin key",
+		},
+		{name: "unknown key", token: sign(t, "ES256", other, valid), wantErr: "not verified by any admin key"},
+		{
+			name: "tampered payload",
+			token: func() string {
+				parts := strings.Split(sign(t, "ES256", p256, valid), ".")
+				p, _ := json.Marshal(with(func(c *Claims) { c.Namespace = "kube-system" }))
+				parts[1] = base64.RawURLEncoding.EncodeToString(p)
+				return strings.Join(parts, ".")
+			}(),
+			wantErr: "not verified by any admin key",
+		},
+		{name: "malformed", token: "not-a-jws", wantErr: "not a JWS compact serialization"},
+		{
+			name:    "other namespace",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Namespace = "kube-system" })),
+			wantErr: `exemption is for "kube-system" namespace`,
+		},
+		{
+			name:    "expired",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Expiry = now.Add(-time.Minute).Unix() })),
+			wantErr: "exemption expired",
+		},
+		{
+			name:    "no expiry",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Expiry = 0 })),
+			wantErr: "exemption has no expiry",
+		},
+		{
+			name:    "no reason",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Reason = "" })),
+			wantErr: "exemption has no reason",
+		},
+		{
+			name:    "no images",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Images = nil })),
+			wantErr: "exemption has no images",
+		},
+		{
+			name:    "lifetime exceeded",
+			token:   sign(t, "ES256", p256, with(func(c *Claims) { c.Expiry = now.Add(48 * time.Hour).Unix() })),
+			wantErr: "exemption lifetime exceeds",
+		},
+		{
+			name: "issued in the future",
+			token: sign(t, "ES256", p256, with(func(c *Claims) {
+				c.IssuedAt = now.Add(47 * time.Hour).Unix()
+				c.Expiry = now.Add(48 * time.Hour).Unix()
+			})),
+			wantErr: "exemption issued in the future",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := v.Verify(tt.token, "default")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if e.Key != tt.key || e.Reason != valid.Reason || e.Subject != valid.Subject {
+				t.Errorf("Verify = %+v, want %s key", e, tt.key)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	e := &Exemption{Claims: Claims{Images: []string{
+		"registry.example.com/app:hotfix",
+		"ghcr.io/org/tool@" + digest,
+		"sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+		"nginx",
+		"Malformed",
+	}}}
+
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{image: "registry.example.com/app:hotfix", want: true},
+		{image: "registry.example.com/app:latest", want: false},
+		{image: "registry.example.com/app", want: false},
+		{image: "registry.example.com/other:hotfix", want: false},
+		{image: "ghcr.io/org/tool@" + digest, want: true},
+		{image: "ghcr.io/org/tool:v1@" + digest, want: true},
+		{image: "ghcr.io/org/tool:v1", want: false},
+		{image: "ghcr.io/org/other@" + digest, want: false},
+		{image: "quay.io/any/image@sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210", want: true},
+		{image: "docker.io/library/nginx:latest", want: true},
+		{image: "nginx:1.27", want: false},
+		{image: "Malformed", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := e.Matches(tt.image); got != tt.want {
+				t.Errorf("Matches(%q) = %t, want %t", tt.image, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,11 +9,14 @@ import (
 
 	"notary-admission/pkg/admissioncontroller"
 
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	v1 "k8s.io/api/admission/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/tracing"
 	"sync"
 )
 
@@ -30,16 +34,70 @@ type admissionHandler struct {
 	decoder runtime.Decoder
 }
 
+var decoder = serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer()
+
 // newAdmissionHandler returns an instance of AdmissionHandler
 func newAdmissionHandler() *admissionHandler {
 	return &admissionHandler{
-		decoder: serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer(),
+		decoder: decoder,
 	}
 }
 
-// Serve returns a handlers.HandlerFunc for an admission webhook
+// DecodeReview deserializes an AdmissionReview, which must contain a request
+func DecodeReview(body []byte) (*v1.AdmissionReview, error) {
+	var review v1.AdmissionReview
+	if _, _, err := decoder.Decode(body, nil, &review); err != nil {
+		return nil, fmt.Errorf("could not deserialize request: %v", err)
+	}
+
+	if review.Request == nil {
+		return nil, fmt.Errorf("malformed admission review: request is nil")
+	}
+
+	return &review, nil
+}
+
+// Review executes hook for the request of review, returning the response AdmissionReview and the hook result
+func Review(ctx context.Context, hook admissioncontroller.Hook, review *v1.AdmissionReview) (*v1.AdmissionReview,
+	*admissioncontroller.Result, error) {
+	result, err := hook.Execute(ctx, review.Request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	admissionResponse := v1.AdmissionReview{
+		TypeMeta: meta.TypeMeta{
+			Kind:       AdmissionReviewKind,
+			APIVersion: AdmissionReviewVersion,
+		},
+		Request: nil,
+		Response: &v1.AdmissionResponse{
+			UID:      review.Request.UID,
+			Allowed:  result.Allowed,
+			Result:   &meta.Status{Message: result.Msg},
+			Warnings: result.Warnings,
+
+			AuditAnnotations: result.AuditAnnotations,
+		},
+	}
+
+	if len(result.Patch) > 0 {
+		pt := v1.PatchTypeJSONPatch
+		admissionResponse.Response.Patch = result.Patch
+		admissionResponse.Response.PatchType = &pt
+	}
+
+	return &admissionResponse, result, nil
+}
+
+// Serve returns a handlers.HandlerFunc for an admission webhook. The request span continues the trace
+// propagated by the API server, if any, but is not cancelled with the request, as verifications are shared.
 func (h *admissionHandler) Serve(hook admissioncontroller.Hook) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(tracing.Extract(context.Background(), propagation.HeaderCarrier(r.Header)),
+			"admissionHandler.Serve", semconv.URLPath(r.URL.Path))
+		defer span.End()
+
 		w.Header().Set(HeaderContentType, ContentTypeJson)
 		if r.Method != http.MethodPost {
 			http.Error(w, fmt.Sprint("invalid method, only POST requests are allowed"), http.StatusMethodNotAllowed)
@@ -59,39 +117,32 @@ func (h *admissionHandler) Serve(hook admissioncontroller.Hook) http.HandlerFunc
 
 		log.Log.Debugf("Request body: %s", string(body))
 
-		var review v1.AdmissionReview
-		if _, _, err := h.decoder.Decode(body, nil, &review); err != nil {
-			http.Error(w, fmt.Sprintf("could not deserialize request: %v", err), http.StatusBadRequest)
+		_, decodeSpan := tracing.Start(ctx, "handlers.DecodeReview")
+		review, err := DecodeReview(body)
+		decodeSpan.End()
+		if err != nil {
+			tracing.Fail(span, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		req := review.Request
+		span.SetAttributes(tracing.AttrUID.String(string(req.UID)), tracing.AttrOperation.String(string(req.Operation)),
+			tracing.AttrKind.String(req.Kind.Kind), tracing.AttrName.String(req.Name),
+			semconv.K8SNamespaceName(req.Namespace))
+
 		//log.Log.Debug("Admission Review: %v", review)
-		if review.Request == nil {
-			http.Error(w, "malformed admission review: request is nil", http.StatusBadRequest)
-			return
-		}
 		log.Log.Debugf("Admission Review object: %v", string(review.Request.Object.Raw))
 
-		result, err := hook.Execute(review.Request)
+		admissionResponse, result, err := Review(ctx, hook, review)
 		if err != nil {
+			tracing.Fail(span, err)
 			log.Log.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		admissionResponse := v1.AdmissionReview{
-			TypeMeta: meta.TypeMeta{
-				Kind:       AdmissionReviewKind,
-				APIVersion: AdmissionReviewVersion,
-			},
-			Request: nil,
-			Response: &v1.AdmissionResponse{
-				UID:      review.Request.UID,
-				Allowed:  result.Allowed,
-				Result:   &meta.Status{Message: result.Msg},
-				Warnings: result.Warnings,
-			},
-		}
+		span.SetAttributes(tracing.AttrAllowed.Bool(result.Allowed))
 
 		log.Log.Debugf("Admission Response: %v", admissionResponse)
 
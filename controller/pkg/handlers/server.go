@@ -12,18 +12,24 @@ import (
 
 // NewTlsServer creates and return a http.Server with a mux that handles endpoints over TLS
 func NewTlsServer(port string) *http.Server {
-	phm := metrics.InitPrometheusHttpMetric(model.ServerConfig.Prometheus.Name,
-		prometheus.LinearBuckets(model.ServerConfig.Prometheus.Start,
-			model.ServerConfig.Prometheus.Width, model.ServerConfig.Prometheus.Count))
+	phm := metrics.InitPrometheusHttpMetric(model.ServerConfig().Prometheus.Name,
+		prometheus.LinearBuckets(model.ServerConfig().Prometheus.Start,
+			model.ServerConfig().Prometheus.Width, model.ServerConfig().Prometheus.Count))
 
 	// Instances hooks
 	validation := workloads.NewValidationHook()
 	// Routers
 	ah := newAdmissionHandler()
 	mux := http.NewServeMux()
-	mux.Handle(model.ServerConfig.Network.Endpoints.Validation,
+	mux.Handle(model.ServerConfig().Network.Endpoints.Validation,
 		phm.WrapHandler("workload-validator", ah.Serve(validation)))
 
+	if model.ServerConfig().Network.Endpoints.Mutation != "" {
+		mutation := workloads.NewMutationHook()
+		mux.Handle(model.ServerConfig().Network.Endpoints.Mutation,
+			phm.WrapHandler("workload-mutator", ah.Serve(mutation)))
+	}
+
 	return &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: mux,
@@ -35,8 +41,8 @@ func NewServer(port string) *http.Server {
 	// Routers
 	c := controller{}
 	mux := http.NewServeMux()
-	mux.Handle(model.ServerConfig.Network.Endpoints.Metrics, promhttp.Handler())
-	mux.Handle(model.ServerConfig.Network.Endpoints.Health, c.healthz())
+	mux.Handle(model.ServerConfig().Network.Endpoints.Metrics, promhttp.Handler())
+	mux.Handle(model.ServerConfig().Network.Endpoints.Health, c.healthz())
 
 	return &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
@@ -0,0 +1,428 @@
+package imagepolicy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"notary-admission/pkg/apis/v1alpha1"
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	PolicyResync      = 10 * time.Minute
+	PolicySyncTimeout = time.Minute
+	StatusTimeout     = 10 * time.Second
+
+	DefaultVerificationLevel = "strict"
+)
+
+// ImagePolicy is an accepted ImageVerificationPolicy, compiled for matching
+type ImagePolicy struct {
+	Name        string
+	TrustPolicy string
+	Enforcement string
+	scopes      []string
+	namespaces  []string
+	selector    labels.Selector
+	exemptions  []string
+	generation  int64
+}
+
+var (
+	lock     = &sync.RWMutex{}
+	policies = make(map[string]*ImagePolicy)
+	ordered  []*ImagePolicy
+)
+
+// Start watches ImageVerificationPolicy objects, applying changes until stop is closed
+func Start(stop <-chan struct{}) error {
+	client, err := kube.GetDynamicClient()
+	if err != nil {
+		return err
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, PolicyResync)
+	informer := factory.ForResource(v1alpha1.GroupVersionResource).Informer()
+
+	reg, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onChange,
+		UpdateFunc: func(_, obj interface{}) { onChange(obj) },
+		DeleteFunc: onDelete,
+	})
+	if err != nil {
+		return fmt.Errorf("could not add policy event handler: %w", err)
+	}
+
+	factory.Start(stop)
+
+	timeout := make(chan struct{})
+	t := time.AfterFunc(PolicySyncTimeout, func() { close(timeout) })
+	defer t.Stop()
+
+	// The handler has synced once the existing policies are applied
+	if !cache.WaitForCacheSync(timeout, reg.HasSynced) {
+		return fmt.Errorf("could not sync %s informer", v1alpha1.Resource)
+	}
+
+	log.Log.Infof("watching %s, %d accepted", v1alpha1.Resource, len(Policies()))
+
+	return nil
+}
+
+// Policies returns the names of the accepted policies, in match order
+func Policies() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var names []string
+	for _, p := range ordered {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// For returns the first accepted policy, by name, that applies to image in namespace, or nil
+func For(namespace string, image string) (*ImagePolicy, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.RLock()
+	candidates := ordered
+	lock.RUnlock()
+
+	var nsLabels labels.Set
+	for _, p := range candidates {
+		if !scopeMatches(p.scopes, ref.Name()) {
+			continue
+		}
+
+		if len(p.namespaces) > 0 && !globMatches(p.namespaces, namespace) {
+			continue
+		}
+
+		if p.selector != nil {
+			if nsLabels == nil {
+				l, err := kube.NamespaceLabels(namespace)
+				if err != nil {
+					return nil, fmt.Errorf("could not select image policy for %s namespace: %w", namespace, err)
+				}
+				nsLabels = labels.Set(l)
+			}
+			if !p.selector.Matches(nsLabels) {
+				continue
+			}
+		}
+
+		return p, nil
+	}
+
+	return nil, nil
+}
+
+// Exempts determines if the policy exempts image from verification
+func (p *ImagePolicy) Exempts(image string) bool {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return false
+	}
+	return scopeMatches(p.exemptions, ref.Name())
+}
+
+// onChange compiles and applies an added or updated policy, and reports the result in its status. An
+// invalid update is not accepted, and the last accepted generation of the policy, if any, stays in force.
+func onChange(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	var ivp v1alpha1.ImageVerificationPolicy
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &ivp)
+	if err == nil && !applied(u.GetName(), u.GetGeneration()) {
+		err = apply(&ivp)
+	}
+
+	if err != nil {
+		if g, ok := acceptedGeneration(u.GetName()); ok {
+			err = fmt.Errorf("%w, generation %d in force", err, g)
+		}
+		log.Log.Errorf("%s policy generation %d not accepted: %v", u.GetName(), u.GetGeneration(), err)
+	}
+
+	updateStatus(u, &ivp, err)
+}
+
+// onDelete removes a deleted policy
+func onDelete(obj interface{}) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	remove(u.GetName())
+	log.Log.Infof("%s policy deleted", u.GetName())
+}
+
+// applied determines if the generation of the named policy is already accepted, as for status updates
+func applied(name string, generation int64) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	p, ok := policies[name]
+	return ok && p.generation == generation
+}
+
+// acceptedGeneration returns the accepted generation of the named policy, if any
+func acceptedGeneration(name string) (int64, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	p, ok := policies[name]
+	if !ok {
+		return 0, false
+	}
+	return p.generation, true
+}
+
+// apply compiles the policy and writes its generated trust policy
+func apply(ivp *v1alpha1.ImageVerificationPolicy) error {
+	p, err := compile(ivp)
+	if err != nil {
+		return err
+	}
+
+	tp, err := trustPolicy(ivp)
+	if err != nil {
+		return err
+	}
+
+	err = notation.AddPolicy(p.TrustPolicy, tp)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	policies[p.Name] = p
+	reorder()
+	lock.Unlock()
+
+	log.Log.Infof("%s policy accepted", p.Name)
+
+	return nil
+}
+
+// remove removes the named policy and its generated trust policy, if accepted
+func remove(name string) {
+	lock.Lock()
+	p, ok := policies[name]
+	delete(policies, name)
+	reorder()
+	lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err := notation.RemovePolicy(p.TrustPolicy); err != nil {
+		log.Log.Error(err)
+	}
+}
+
+// reorder rebuilds the match order, by name, with the lock held
+func reorder() {
+	o := make([]*ImagePolicy, 0, len(policies))
+	for _, p := range policies {
+		o = append(o, p)
+	}
+	sort.Slice(o, func(i, j int) bool { return o[i].Name < o[j].Name })
+	ordered = o
+}
+
+// compile validates the policy spec and compiles it for matching
+func compile(ivp *v1alpha1.ImageVerificationPolicy) (*ImagePolicy, error) {
+	s := ivp.Spec
+
+	if len(s.RegistryScopes) == 0 {
+		return nil, fmt.Errorf("registryScopes are required")
+	}
+	for _, patterns := range [][]string{s.RegistryScopes, s.Namespaces, s.Exemptions} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("malformed pattern %s: %w", p, err)
+			}
+		}
+	}
+
+	switch s.Enforcement {
+	case "", model.EnforcementEnforce, model.EnforcementWarn, model.EnforcementAudit:
+	default:
+		return nil, fmt.Errorf("enforcement %s not supported, must be %s, %s or %s", s.Enforcement,
+			model.EnforcementEnforce, model.EnforcementWarn, model.EnforcementAudit)
+	}
+
+	p := &ImagePolicy{
+		Name:        ivp.Name,
+		TrustPolicy: notation.CrdPolicyPrefix + ivp.Name,
+		Enforcement: s.Enforcement,
+		scopes:      s.RegistryScopes,
+		namespaces:  s.Namespaces,
+		exemptions:  s.Exemptions,
+		generation:  ivp.Generation,
+	}
+
+	if s.NamespaceSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(s.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespaceSelector: %w", err)
+		}
+		p.selector = sel
+	}
+
+	return p, nil
+}
+
+// trustPolicy generates the notation trust policy of the policy, applying to all registries, as
+// registry scopes are matched by the webhook
+func trustPolicy(ivp *v1alpha1.ImageVerificationPolicy) ([]byte, error) {
+	s := ivp.Spec
+
+	level := s.VerificationLevel
+	if level == "" {
+		level = DefaultVerificationLevel
+	}
+
+	stores := s.TrustStores
+	if len(stores) == 0 && model.ServerConfig().Notation.TrustStore != "" {
+		stores = []string{"signingAuthority:" + model.ServerConfig().Notation.TrustStore}
+	}
+
+	signers := s.Signers
+	if signers == nil {
+		signers = []string{}
+	}
+	if stores == nil {
+		stores = []string{}
+	}
+
+	tp := map[string]interface{}{
+		"version": "1.0",
+		"trustPolicies": []map[string]interface{}{{
+			"name":                  ivp.Name,
+			"registryScopes":        []string{"*"},
+			"signatureVerification": map[string]string{"level": level},
+			"trustStores":           stores,
+			"trustedIdentities":     signers,
+		}},
+	}
+
+	b, err := json.Marshal(tp)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate trust policy: %w", err)
+	}
+
+	return b, nil
+}
+
+// updateStatus sets the Accepted condition and observed generation of the policy, if changed
+func updateStatus(u *unstructured.Unstructured, ivp *v1alpha1.ImageVerificationPolicy, applyErr error) {
+	cond := metav1.Condition{
+		Type:               v1alpha1.ConditionAccepted,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1alpha1.ReasonValid,
+		Message:            "policy accepted",
+		ObservedGeneration: u.GetGeneration(),
+	}
+	if applyErr != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = v1alpha1.ReasonInvalid
+		cond.Message = applyErr.Error()
+	}
+
+	status := ivp.Status
+	current := meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionAccepted)
+	if current != nil && current.Status == cond.Status && current.Message == cond.Message &&
+		status.ObservedGeneration == u.GetGeneration() {
+		return
+	}
+
+	meta.SetStatusCondition(&status.Conditions, cond)
+	status.ObservedGeneration = u.GetGeneration()
+
+	s, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		log.Log.Errorf("could not convert %s policy status: %v", u.GetName(), err)
+		return
+	}
+
+	updated := u.DeepCopy()
+	updated.Object["status"] = s
+
+	ctx, cancel := context.WithTimeout(context.Background(), StatusTimeout)
+	defer cancel()
+
+	client, err := kube.GetDynamicClient()
+	if err != nil {
+		log.Log.Error(err)
+		return
+	}
+
+	_, err = client.Resource(v1alpha1.GroupVersionResource).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		// Retried on the next resync
+		log.Log.Warnf("could not update %s policy status: %v", u.GetName(), err)
+	}
+}
+
+// scopeMatches matches a registry/repository name against glob patterns. A trailing /* also
+// matches all repositories below the prefix.
+func scopeMatches(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+
+		prefix, ok := strings.CutSuffix(p, "/*")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		for i := 1; i < len(parts); i++ {
+			if ok, _ := path.Match(prefix, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatches matches name against glob patterns
+func globMatches(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
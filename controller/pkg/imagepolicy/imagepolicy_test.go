@@ -0,0 +1,92 @@
+package imagepolicy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"notary-admission/pkg/apis/v1alpha1"
+	"notary-admission/pkg/kube"
+	"notary-admission/pkg/model"
+)
+
+const image = "registry.example.com/team/app:v1"
+
+// policyObject returns the team ImageVerificationPolicy at generation, with enforcement mode
+func policyObject(generation int64, enforcement string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.Group + "/" + v1alpha1.Version,
+		"kind":       v1alpha1.Kind,
+		"metadata":   map[string]interface{}{"name": "team", "generation": generation},
+		"spec": map[string]interface{}{
+			"registryScopes": []interface{}{"registry.example.com/team/*"},
+			"trustStores":    []interface{}{"ca:team"},
+			"signers":        []interface{}{"*"},
+			"enforcement":    enforcement,
+		},
+	}}
+}
+
+// accepted returns the Accepted condition of the team policy
+func accepted(t *testing.T) *metav1.Condition {
+	t.Helper()
+
+	c, err := kube.GetDynamicClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := c.Resource(v1alpha1.GroupVersionResource).Get(context.Background(), "team", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ivp v1alpha1.ImageVerificationPolicy
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &ivp); err != nil {
+		t.Fatal(err)
+	}
+	return meta.FindStatusCondition(ivp.Status.Conditions, v1alpha1.ConditionAccepted)
+}
+
+func TestInvalidUpdateKeepsAcceptedPolicy(t *testing.T) {
+	c := &model.Config{}
+	c.Notation.HomeDir = t.TempDir() + "/notation"
+	c.Notation.XdgHomeVal = t.TempDir()
+	c.Notation.TrustPolicy = "trustpolicy.json"
+	model.SetServerConfig(c)
+
+	valid := policyObject(1, model.EnforcementWarn)
+	kube.SetClients(nil, dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.GroupVersionResource: v1alpha1.Kind + "List"}, valid))
+
+	onChange(valid)
+	p, err := For("default", image)
+	if err != nil || p == nil || p.Enforcement != model.EnforcementWarn {
+		t.Fatalf("For = %+v, %v, want accepted team policy", p, err)
+	}
+	if cond := accepted(t); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Fatalf("Accepted condition = %+v, want True", cond)
+	}
+
+	onChange(policyObject(2, "block"))
+	p, err = For("default", image)
+	if err != nil || p == nil || p.generation != 1 || p.Enforcement != model.EnforcementWarn {
+		t.Errorf("For = %+v, %v, want generation 1 in force", p, err)
+	}
+	cond := accepted(t)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.ObservedGeneration != 2 ||
+		!strings.Contains(cond.Message, "generation 1 in force") {
+		t.Errorf("Accepted condition = %+v, want False, with generation 1 in force", cond)
+	}
+
+	onDelete(valid)
+	if p, err = For("default", image); err != nil || p != nil {
+		t.Errorf("For = %+v, %v, want no policy once deleted", p, err)
+	}
+}
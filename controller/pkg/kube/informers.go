@@ -0,0 +1,59 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informer starts shared informers once, with setup, and serves their listers once synced. Informers
+// that have not synced keep retrying in the background, callers are not blocked.
+type informer struct {
+	name   string
+	setup  func(c kubernetes.Interface) []cache.InformerSynced
+	once   sync.Once
+	err    error
+	synced []cache.InformerSynced
+}
+
+// start starts the informers, once, returning an error if they could not be created, or have not synced
+func (i *informer) start() error {
+	i.once.Do(func() {
+		c, err := GetClient()
+		if err != nil {
+			i.err = err
+			return
+		}
+		i.synced = i.setup(c)
+	})
+	if i.err != nil {
+		return i.err
+	}
+
+	for _, s := range i.synced {
+		if !s() {
+			return fmt.Errorf("%s informer not synced", i.name)
+		}
+	}
+	return nil
+}
+
+// wait starts the informers, and waits until they have synced, or timeout
+func (i *informer) wait(timeout time.Duration) error {
+	err := i.start()
+	if err == nil || i.err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if !cache.WaitForCacheSync(ctx.Done(), i.synced...) {
+		return fmt.Errorf("could not sync %s informer", i.name)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package kube
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInformerNotSynced(t *testing.T) {
+	SetClients(fake.NewClientset(), nil)
+
+	var setups atomic.Int32
+	var synced atomic.Bool
+	i := &informer{name: "test", setup: func(kubernetes.Interface) []cache.InformerSynced {
+		setups.Add(1)
+		return []cache.InformerSynced{synced.Load}
+	}}
+
+	start := time.Now()
+	for n := 0; n < 3; n++ {
+		if err := i.start(); err == nil {
+			t.Fatal("start of unsynced informer succeeded")
+		}
+	}
+	if err := i.wait(50 * time.Millisecond); err == nil {
+		t.Fatal("wait of unsynced informer succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("unsynced informer blocked callers for %s", d)
+	}
+
+	synced.Store(true)
+	if err := i.start(); err != nil {
+		t.Errorf("start of synced informer: %v", err)
+	}
+	if n := setups.Load(); n != 1 {
+		t.Errorf("informer set up %d times, want once", n)
+	}
+}
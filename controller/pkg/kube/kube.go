@@ -0,0 +1,73 @@
+package kube
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	lock          = &sync.Mutex{}
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+)
+
+// GetClient creates singleton of the in-cluster Kubernetes client
+func GetClient() (kubernetes.Interface, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if client != nil {
+		return client, nil
+	}
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load in-cluster config: %w", err)
+	}
+
+	c, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes client: %w", err)
+	}
+
+	client = c
+
+	return client, nil
+}
+
+// GetDynamicClient creates singleton of the in-cluster Kubernetes dynamic client, for custom resources
+func GetDynamicClient() (dynamic.Interface, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if dynamicClient != nil {
+		return dynamicClient, nil
+	}
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load in-cluster config: %w", err)
+	}
+
+	c, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes dynamic client: %w", err)
+	}
+
+	dynamicClient = c
+
+	return dynamicClient, nil
+}
+
+// SetClients replaces the Kubernetes clients, to run offline against fake clients
+func SetClients(c kubernetes.Interface, dc dynamic.Interface) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	client = c
+	dynamicClient = dc
+}
@@ -0,0 +1,60 @@
+package kube
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	NamespaceResync      = 10 * time.Minute
+	NamespaceSyncTimeout = time.Minute
+)
+
+var (
+	nsLister listers.NamespaceLister
+
+	namespaces = &informer{name: "namespace", setup: func(c kubernetes.Interface) []cache.InformerSynced {
+		factory := informers.NewSharedInformerFactory(c, NamespaceResync)
+		informer := factory.Core().V1().Namespaces()
+		nsLister = informer.Lister()
+		factory.Start(wait.NeverStop)
+
+		return []cache.InformerSynced{informer.Informer().HasSynced}
+	}}
+)
+
+// StartNamespaceInformer starts the shared namespace informer, waiting until it has synced, or
+// NamespaceSyncTimeout
+func StartNamespaceInformer() error {
+	return namespaces.wait(NamespaceSyncTimeout)
+}
+
+// NamespaceLister starts, once, the shared namespace informer and returns its lister, or an error while
+// it has not synced
+func NamespaceLister() (listers.NamespaceLister, error) {
+	if err := namespaces.start(); err != nil {
+		return nil, err
+	}
+	return nsLister, nil
+}
+
+// NamespaceLabels returns the labels of namespace, from the namespace informer cache
+func NamespaceLabels(namespace string) (map[string]string, error) {
+	l, err := NamespaceLister()
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := l.Get(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s namespace: %w", namespace, err)
+	}
+
+	return ns.Labels, nil
+}
@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"fmt"
+	"time"
+
+	pv1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	PullSecretResync      = 10 * time.Minute
+	PullSecretSyncTimeout = time.Minute
+)
+
+var (
+	saLister      listers.ServiceAccountLister
+	secretListers []listers.SecretLister
+
+	pullSecrets = &informer{name: "pull secret", setup: func(c kubernetes.Interface) []cache.InformerSynced {
+		factory := informers.NewSharedInformerFactory(c, PullSecretResync)
+		sa := factory.Core().V1().ServiceAccounts()
+		saLister = sa.Lister()
+		synced := []cache.InformerSynced{sa.Informer().HasSynced}
+		factory.Start(wait.NeverStop)
+
+		// Only pull secrets are cached, not every Secret of the cluster
+		for _, t := range []pv1.SecretType{pv1.SecretTypeDockerConfigJson, pv1.SecretTypeDockercfg} {
+			selector := "type=" + string(t)
+			sf := informers.NewSharedInformerFactoryWithOptions(c, PullSecretResync,
+				informers.WithTweakListOptions(func(o *meta.ListOptions) { o.FieldSelector = selector }))
+			s := sf.Core().V1().Secrets()
+			secretListers = append(secretListers, s.Lister())
+			synced = append(synced, s.Informer().HasSynced)
+			sf.Start(wait.NeverStop)
+		}
+
+		return synced
+	}}
+)
+
+// StartPullSecretInformers starts the ServiceAccount and pull secret informers, waiting until they have
+// synced, or PullSecretSyncTimeout
+func StartPullSecretInformers() error {
+	return pullSecrets.wait(PullSecretSyncTimeout)
+}
+
+// ServiceAccount returns the named ServiceAccount of namespace, from the informer cache
+func ServiceAccount(namespace string, name string) (*pv1.ServiceAccount, error) {
+	if err := pullSecrets.start(); err != nil {
+		return nil, err
+	}
+
+	sa, err := saLister.ServiceAccounts(namespace).Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s service account in %s namespace: %w", name, namespace, err)
+	}
+	return sa, nil
+}
+
+// PullSecret returns the named dockerconfigjson or dockercfg Secret of namespace, from the informer cache
+func PullSecret(namespace string, name string) (*pv1.Secret, error) {
+	if err := pullSecrets.start(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range secretListers {
+		if s, err := l.Secrets(namespace).Get(name); err == nil {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%s pull secret not found in %s namespace", name, namespace)
+}
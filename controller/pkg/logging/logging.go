@@ -10,7 +10,8 @@ const (
 )
 
 var (
-	Log    *zap.SugaredLogger
+	// Log discards entries until Start builds the configured logger
+	Log    = zap.NewNop().Sugar()
 	Config *zap.Config
 )
 
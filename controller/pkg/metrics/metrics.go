@@ -5,6 +5,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"time"
 )
 
 type PrometheusHttpMetric struct {
@@ -47,3 +48,205 @@ func (phm *PrometheusHttpMetric) WrapHandler(handlerLabel string, handlerFunc ht
 	)
 	return wrappedHandler
 }
+
+type PrometheusDecisionMetric struct {
+	Prefix    string
+	Decisions *prometheus.CounterVec
+}
+
+func InitPrometheusDecisionMetric(prefix string) *PrometheusDecisionMetric {
+	pdm := PrometheusDecisionMetric{
+		Prefix: prefix,
+		Decisions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_admission_decisions_total",
+			Help: "total admission decisions, by namespace, enforcement mode and decision",
+		}, []string{"namespace", "mode", "decision"},
+		),
+	}
+
+	return &pdm
+}
+
+type PrometheusExemptionMetric struct {
+	Prefix     string
+	Exemptions *prometheus.CounterVec
+}
+
+func InitPrometheusExemptionMetric(prefix string) *PrometheusExemptionMetric {
+	pem := PrometheusExemptionMetric{
+		Prefix: prefix,
+		Exemptions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_verification_exemptions_total",
+			Help: "total break-glass exemptions, by namespace and result",
+		}, []string{"namespace", "result"},
+		),
+	}
+
+	return &pem
+}
+
+type PrometheusReloadMetric struct {
+	Prefix  string
+	Reloads *prometheus.CounterVec
+	Active  *prometheus.GaugeVec
+}
+
+func InitPrometheusReloadMetric(prefix string) *PrometheusReloadMetric {
+	prm := PrometheusReloadMetric{
+		Prefix: prefix,
+		Reloads: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_config_reloads_total",
+			Help: "total config, trust policy and trust store reloads, by component and result",
+		}, []string{"component", "result"},
+		),
+		Active: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prefix + "_active_config_info",
+			Help: "active config, trust policy and trust store hashes, by component",
+		}, []string{"component", "hash"},
+		),
+	}
+
+	return &prm
+}
+
+// SetActive sets the active hash of component
+func (prm *PrometheusReloadMetric) SetActive(component string, hash string) {
+	prm.Active.DeletePartialMatch(prometheus.Labels{"component": component})
+	prm.Active.WithLabelValues(component, hash).Set(1)
+}
+
+type PrometheusRescanMetric struct {
+	Prefix    string
+	Workloads *prometheus.GaugeVec
+	Completed prometheus.Gauge
+}
+
+func InitPrometheusRescanMetric(prefix string) *PrometheusRescanMetric {
+	prm := PrometheusRescanMetric{
+		Prefix: prefix,
+		Workloads: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prefix + "_rescan_workloads",
+			Help: "running workloads of the last re-verification scan, by namespace and decision",
+		}, []string{"namespace", "decision"},
+		),
+		Completed: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: prefix + "_rescan_last_completed_timestamp_seconds",
+			Help: "completion time of the last re-verification scan",
+		}),
+	}
+
+	return &prm
+}
+
+type PrometheusCacheMetric struct {
+	Prefix string
+	Hits   *prometheus.CounterVec
+	Misses prometheus.Counter
+}
+
+func InitPrometheusCacheMetric(prefix string) *PrometheusCacheMetric {
+	pcm := PrometheusCacheMetric{
+		Prefix: prefix,
+		Hits: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_verification_cache_hits_total",
+			Help: "total verification cache hits",
+		}, []string{"result"},
+		),
+		Misses: promauto.NewCounter(prometheus.CounterOpts{
+			Name: prefix + "_verification_cache_misses_total",
+			Help: "total verification cache misses",
+		}),
+	}
+
+	return &pcm
+}
+
+type PrometheusVerifierMetric struct {
+	Prefix        string
+	Verifications *prometheus.CounterVec
+	Failures      *prometheus.CounterVec
+	Duration      *prometheus.HistogramVec
+}
+
+func InitPrometheusVerifierMetric(prefix string) *PrometheusVerifierMetric {
+	pvm := PrometheusVerifierMetric{
+		Prefix: prefix,
+		Verifications: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_verifications_total",
+			Help: "total image verifications, by registry and result",
+		}, []string{"registry", "result"},
+		),
+		Failures: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_verification_failures_total",
+			Help: "total failed and errored image verifications, by registry and reason",
+		}, []string{"registry", "reason"},
+		),
+		Duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "_verification_duration_seconds",
+			Help:    "Histogram of signature verification time, by registry and signature format",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"registry", "signatures"}),
+	}
+
+	return &pvm
+}
+
+type PrometheusTokenMetric struct {
+	Prefix        string
+	FetchDuration *prometheus.HistogramVec
+	FetchErrors   *prometheus.CounterVec
+	Hits          *prometheus.CounterVec
+	Misses        *prometheus.CounterVec
+}
+
+// InitPrometheusTokenMetric creates the registry auth token metrics, reporting the seconds until each
+// token returned by expiries expires when collected
+func InitPrometheusTokenMetric(prefix string, expiries func() map[string]time.Time) *PrometheusTokenMetric {
+	ptm := PrometheusTokenMetric{
+		Prefix: prefix,
+		FetchDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "_ecr_token_fetch_duration_seconds",
+			Help:    "Histogram of ECR auth token fetch time, by registry and result",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"registry", "result"}),
+		FetchErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_ecr_token_fetch_errors_total",
+			Help: "total ECR auth token fetch errors, by registry and reason",
+		}, []string{"registry", "reason"},
+		),
+		Hits: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_ecr_token_cache_hits_total",
+			Help: "total ECR auth token cache hits, by registry",
+		}, []string{"registry"},
+		),
+		Misses: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_ecr_token_cache_misses_total",
+			Help: "total ECR auth token cache misses, by registry",
+		}, []string{"registry"},
+		),
+	}
+
+	prometheus.MustRegister(&tokenExpiryCollector{
+		desc: prometheus.NewDesc(prefix+"_ecr_token_expiry_seconds",
+			"seconds until the cached ECR auth token expires, by registry", []string{"registry"}, nil),
+		expiries: expiries,
+	})
+
+	return &ptm
+}
+
+// tokenExpiryCollector collects the seconds until cached tokens expire, as of the collection
+type tokenExpiryCollector struct {
+	desc     *prometheus.Desc
+	expiries func() map[string]time.Time
+}
+
+func (c *tokenExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *tokenExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	for r, t := range c.expiries() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(t).Seconds(), r)
+	}
+}
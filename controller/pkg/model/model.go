@@ -6,11 +6,26 @@ import (
 	"gopkg.in/yaml.v3"
 	"notary-admission/pkg/utils"
 	"sort"
+	"sync/atomic"
 )
 
-//const (
-//	BinaryMode string = "binary"
-//)
+const (
+	BinaryMode  string = "binary"
+	LibraryMode string = "library"
+
+	VerifierTypeEcr string = "ecr"
+	VerifierTypeOci string = "oci"
+
+	VerifierTypeEcrPublic string = "ecrpublic"
+
+	EnforcementEnforce string = "enforce"
+	EnforcementWarn    string = "warn"
+	EnforcementAudit   string = "audit"
+
+	SignaturesNotation string = "notation"
+	SignaturesCosign   string = "cosign"
+	SignaturesEither   string = "either"
+)
 
 // Config stores server YAML configuration
 type Config struct {
@@ -29,6 +44,7 @@ type Config struct {
 			Health     string `yaml:"health"`
 			Metrics    string `yaml:"metrics"`
 			Validation string `yaml:"validation"`
+			Mutation   string `yaml:"mutation"`
 		} `yaml:"endpoints"`
 		TLS struct {
 			CertFile string `yaml:"crtFile"`
@@ -43,9 +59,15 @@ type Config struct {
 			CacheTimeoutInterval int      `yaml:"cacheTimeoutInterval"`
 		} `yaml:"credentialCache"`
 		IgnoreRegistries []string `yaml:"ignoreRegistries"`
+		// AccountRoles maps AWS account IDs to the IAM roles assumed for their registries
+		AccountRoles map[string]string `yaml:"accountRoles"`
+		// VpceAccounts maps the IDs of ECR VPC endpoints to the AWS account IDs of their registries
+		VpceAccounts map[string]string `yaml:"vpceAccounts"`
 	} `yaml:"ecr"`
 	Notation struct {
 		Mode           string `yaml:"mode"`
+		MaxSigAttempts int    `yaml:"maxSignatureAttempts"`
+		MaxConcurrency int    `yaml:"maxConcurrency"`
 		DebugEnabled   bool   `yaml:"debugEnabled"`
 		DebugFlag      string `yaml:"debugFlag"`
 		BinaryDir      string `yaml:"binaryDir"`
@@ -65,17 +87,157 @@ type Config struct {
 		PluginFile     string `yaml:"pluginFile"`
 		SignerEndpoint string `yaml:"signerEndpoint"`
 		SignerDebug    bool   `yaml:"signerDebug"`
+		Cache          struct {
+			Enabled     bool `yaml:"enabled"`
+			PositiveTTL int  `yaml:"positiveTTL"`
+			NegativeTTL int  `yaml:"negativeTTL"`
+			MaxEntries  int  `yaml:"maxEntries"`
+		} `yaml:"verificationCache"`
+		NamespacePolicies []NamespacePolicy `yaml:"namespacePolicies"`
+		// InsecureRegistries are accessed with plain HTTP, such as local development registries
+		InsecureRegistries []string `yaml:"insecureRegistries"`
 	} `yaml:"notation"`
+	// Enforcement mode is enforce (default), warn or audit, overridable by namespace label
+	Enforcement struct {
+		Mode           string `yaml:"mode"`
+		NamespaceLabel string `yaml:"namespaceLabel"`
+	} `yaml:"enforcement"`
+	// Reload watches the config, trust policy and trust store files, applying valid changes
+	Reload struct {
+		Enabled  bool `yaml:"enabled"`
+		Interval int  `yaml:"interval"`
+	} `yaml:"reload"`
+	// ImageVerificationPolicies are watched and applied when enabled
+	ImageVerificationPolicies struct {
+		Enabled bool `yaml:"enabled"`
+	} `yaml:"imageVerificationPolicies"`
+	// Exemptions are signed break-glass workload annotations, enabled when keys are set
+	Exemptions struct {
+		Annotation  string   `yaml:"annotation"`
+		Keys        []string `yaml:"keys"`
+		MaxLifetime int      `yaml:"maxLifetime"`
+	} `yaml:"exemptions"`
+	// Rescan periodically re-verifies the images of running pods, at a rate of verifications per second,
+	// in namespaces matching the label selector
+	Rescan struct {
+		Enabled           bool    `yaml:"enabled"`
+		Interval          int     `yaml:"interval"`
+		Rate              float64 `yaml:"rate"`
+		NamespaceSelector string  `yaml:"namespaceSelector"`
+	} `yaml:"rescan"`
+	// Audit writes a JSON decision record per admission to an append-only, rotated file
+	Audit      AuditConfig      `yaml:"audit"`
+	Verifiers  []VerifierConfig `yaml:"verifiers"`
+	Kubernetes struct {
+		PullSecrets struct {
+			Enabled bool `yaml:"enabled"`
+			// Fallback uses the registry verifier credentials for images matching no pull secret of
+			// workloads with pull secrets
+			Fallback bool `yaml:"fallback"`
+		} `yaml:"pullSecrets"`
+		// Events are emitted for denied workloads, and workloads with bypassed images
+		Events struct {
+			Enabled bool `yaml:"enabled"`
+		} `yaml:"events"`
+	} `yaml:"kubernetes"`
 	Prometheus struct {
 		Name  string  `yaml:"name"`
 		Start float64 `yaml:"start"`
 		Width float64 `yaml:"width"`
 		Count int     `yaml:"count"`
 	} `yaml:"prometheus"`
+	// Tracing exports OpenTelemetry spans of admission requests to an OTLP HTTP endpoint, or stdout,
+	// sampling a ratio of traces not started by the caller
+	Tracing struct {
+		Enabled     bool    `yaml:"enabled"`
+		Exporter    string  `yaml:"exporter"`
+		Endpoint    string  `yaml:"endpoint"`
+		SampleRatio float64 `yaml:"sampleRatio"`
+	} `yaml:"tracing"`
 	AwsAccountId     string
 	AwsRegion        string
 	AwsRole          string
 	AwsTokenFilePath string
+	// BypassRegistries indexes ecr.ignoreRegistries
+	BypassRegistries map[string]string `yaml:"-"`
+}
+
+// AuditConfig stores the audit log file, its rotation by size in megabytes, and by age in seconds,
+// and the number of rotated files kept, all if 0
+type AuditConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	File           string `yaml:"file"`
+	MaxSize        int    `yaml:"maxSize"`
+	RotateInterval int    `yaml:"rotateInterval"`
+	MaxBackups     int    `yaml:"maxBackups"`
+	HashChain      bool   `yaml:"hashChain"`
+}
+
+// NamespacePolicy stores a trust policy, and the namespaces it is enforced in. Namespaces match
+// by name pattern and by labels; each criterion provided must match.
+type NamespacePolicy struct {
+	Name        string            `yaml:"name"`
+	Namespaces  []string          `yaml:"namespaces"`
+	Labels      map[string]string `yaml:"namespaceLabels"`
+	TrustPolicy string            `yaml:"trustPolicy"`
+	TrustStore  string            `yaml:"trustStore"`
+	RootCert    string            `yaml:"rootCert"`
+}
+
+// VerifierConfig stores the config of a verifier, and the registry patterns it verifies
+type VerifierConfig struct {
+	Name       string   `yaml:"name"`
+	Type       string   `yaml:"type"`
+	Registries []string `yaml:"registries"`
+	Auth       struct {
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"passwordFile"`
+		DockerConfig string `yaml:"dockerConfig"`
+		// CredentialProvider is a kubelet credential provider plugin
+		CredentialProvider struct {
+			Name       string   `yaml:"name"`
+			Path       string   `yaml:"path"`
+			Args       []string `yaml:"args"`
+			ApiVersion string   `yaml:"apiVersion"`
+			Env        []struct {
+				Name  string `yaml:"name"`
+				Value string `yaml:"value"`
+			} `yaml:"env"`
+			DefaultCacheDuration string `yaml:"defaultCacheDuration"`
+		} `yaml:"credentialProvider"`
+	} `yaml:"auth"`
+	// Signatures is the signature format required: notation (default), cosign, or either
+	Signatures string       `yaml:"signatures"`
+	Cosign     CosignConfig `yaml:"cosign"`
+	// SignatureRepositories map image repositories, such as pull-through cache repositories,
+	// to the repositories where their signatures are looked up
+	SignatureRepositories []SignatureRepository `yaml:"signatureRepositories"`
+}
+
+// SignatureRepository maps repositories with prefix to the repository where signatures are looked up,
+// replacing the prefix
+type SignatureRepository struct {
+	Prefix     string `yaml:"prefix"`
+	Repository string `yaml:"repository"`
+}
+
+// CosignConfig stores the cosign public keys, and the keyless trusted roots and identities
+type CosignConfig struct {
+	Keys        []string         `yaml:"keys"`
+	TrustedRoot string           `yaml:"trustedRoot"`
+	FulcioRoots string           `yaml:"fulcioRoots"`
+	RekorKeys   string           `yaml:"rekorKeys"`
+	IgnoreTlog  bool             `yaml:"ignoreTlog"`
+	Identities  []CosignIdentity `yaml:"identities"`
+}
+
+// CosignIdentity stores a keyless signing certificate identity constraint
+type CosignIdentity struct {
+	Subject       string `yaml:"subject"`
+	SubjectRegExp string `yaml:"subjectRegExp"`
+	Issuer        string `yaml:"issuer"`
+	IssuerRegExp  string `yaml:"issuerRegExp"`
 }
 
 // TrustPolicyModel stores JSON trust policy
@@ -97,13 +259,26 @@ type TrustPolicyModel struct {
 }
 
 var (
-	ServerConfig     Config
-	ConfigFile       string
-	TrustPolicyFile  string
-	TrustPolicy      TrustPolicyModel
-	BypassRegistries map[string]string
+	serverConfig    atomic.Pointer[Config]
+	ConfigFile      string
+	TrustPolicyFile string
+	TrustPolicy     TrustPolicyModel
 )
 
+func init() {
+	serverConfig.Store(&Config{})
+}
+
+// ServerConfig returns the active server config, which must not be modified once swapped in
+func ServerConfig() *Config {
+	return serverConfig.Load()
+}
+
+// SetServerConfig atomically swaps in config c
+func SetServerConfig(c *Config) {
+	serverConfig.Store(c)
+}
+
 // Yaml marshals config for YAML output
 func (c *Config) Yaml() ([]byte, error) {
 	out, err := yaml.Marshal(&c)
@@ -142,9 +317,9 @@ func (c *Config) LoadConfig(configFile string) error {
 	}
 
 	sort.Strings(c.Ecr.IgnoreRegistries)
-	BypassRegistries = make(map[string]string)
+	c.BypassRegistries = make(map[string]string)
 	for _, s := range c.Ecr.IgnoreRegistries {
-		BypassRegistries[s] = s
+		c.BypassRegistries[s] = s
 	}
 
 	return nil
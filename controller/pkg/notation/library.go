@@ -0,0 +1,199 @@
+package notation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	_ "github.com/notaryproject/notation-core-go/signature/cose"
+	_ "github.com/notaryproject/notation-core-go/signature/jws"
+	notationgo "github.com/notaryproject/notation-go"
+	"github.com/notaryproject/notation-go/dir"
+	notationlog "github.com/notaryproject/notation-go/log"
+	"github.com/notaryproject/notation-go/plugin"
+	"github.com/notaryproject/notation-go/registry"
+	"github.com/notaryproject/notation-go/verifier"
+	"github.com/notaryproject/notation-go/verifier/trustpolicy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultMaxSignatureAttempts = 50
+	// SignerProfileVersionAttribute is the signed attribute of AWS Signer signatures naming the signing profile version
+	SignerProfileVersionAttribute = "com.amazonaws.signer.signingProfileVersion"
+)
+
+var (
+	libLock      = &sync.RWMutex{}
+	libVerifiers map[string]notationgo.Verifier
+)
+
+// InitLibrary builds the in-process notation verifiers of the default and namespace trust policies
+// written by init. All verifiers share the plugins of the notation home, and a validated snapshot
+// of its trust store.
+func InitLibrary() error {
+	homeDir := model.ServerConfig().Notation.HomeDir
+	dir.UserConfigDir = homeDir
+	dir.UserLibexecDir = homeDir
+
+	swap, _, err := PrepareTrustStore()
+	if err != nil {
+		return err
+	}
+	swap()
+
+	verifiers := make(map[string]notationgo.Verifier)
+	for _, name := range PolicyNames() {
+		v, err := newLibraryVerifier(PolicyFile(name))
+		if err != nil {
+			return fmt.Errorf("could not create notation verifier for %q trust policy: %w", name, err)
+		}
+		verifiers[name] = v
+	}
+
+	libLock.Lock()
+	defer libLock.Unlock()
+	libVerifiers = verifiers
+
+	return nil
+}
+
+// setLibraryVerifiers swaps in the in-process notation verifiers of the named trust policies
+func setLibraryVerifiers(verifiers map[string]notationgo.Verifier) {
+	libLock.Lock()
+	defer libLock.Unlock()
+	if libVerifiers == nil {
+		libVerifiers = make(map[string]notationgo.Verifier)
+	}
+	for name, v := range verifiers {
+		libVerifiers[name] = v
+	}
+}
+
+// removeLibraryVerifier removes the in-process notation verifier of the named trust policy
+func removeLibraryVerifier(name string) {
+	libLock.Lock()
+	defer libLock.Unlock()
+	delete(libVerifiers, name)
+}
+
+// newLibraryVerifier creates a notation verifier from a trust policy file
+func newLibraryVerifier(file string) (notationgo.Verifier, error) {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust policy: %w", err)
+	}
+
+	doc, err := ValidateTrustPolicy(b)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := verifier.New(doc, snapshotTrustStore{}, plugin.NewCLIManager(dir.PluginFS()))
+	if err != nil {
+		return nil, fmt.Errorf("could not create notation verifier: %w", err)
+	}
+
+	return v, nil
+}
+
+// ValidateTrustPolicy parses and validates a JSON trust policy document
+func ValidateTrustPolicy(b []byte) (*trustpolicy.Document, error) {
+	var doc trustpolicy.Document
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse trust policy: %w", err)
+	}
+
+	if err := doc.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid trust policy: %w", err)
+	}
+
+	return &doc, nil
+}
+
+// VerifyImage verifies image signatures in-process with the named trust policy, using registry
+// basic auth creds, and returns the descriptor of the verified manifest, and the identity of its signer
+func VerifyImage(ctx context.Context, image string, username string, password string,
+	trustPolicy string) (ocispec.Descriptor, string, error) {
+	libLock.RLock()
+	v, ok := libVerifiers[trustPolicy]
+	libLock.RUnlock()
+
+	if !ok {
+		return ocispec.Descriptor{}, "", fmt.Errorf("notation library verifier for %q trust policy not initialized",
+			trustPolicy)
+	}
+
+	repo, err := NewRepository(image, username, password)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+
+	if model.ServerConfig().Notation.DebugEnabled {
+		ctx = notationlog.WithLogger(ctx, log.Log)
+	}
+
+	attempts := model.ServerConfig().Notation.MaxSigAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxSignatureAttempts
+	}
+
+	desc, outcomes, err := notationgo.Verify(ctx, v, registry.NewRepository(repo), notationgo.VerifyOptions{
+		ArtifactReference:    image,
+		PluginConfig:         PluginConfig(),
+		MaxSignatureAttempts: attempts,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+
+	signer := signerIdentity(outcomes)
+	log.Log.Debugf("notation library verified %s, digest: %s, signer: %s", image, desc.Digest, signer)
+
+	return desc, signer, nil
+}
+
+// signerIdentity returns the identity of the signer of the verified signature in outcomes, the AWS Signer
+// signing profile version ARN, if signed by AWS Signer, otherwise the signing certificate subject
+func signerIdentity(outcomes []*notationgo.VerificationOutcome) string {
+	for _, o := range outcomes {
+		if o.Error != nil || o.EnvelopeContent == nil {
+			continue
+		}
+
+		info := o.EnvelopeContent.SignerInfo
+		for _, a := range info.SignedAttributes.ExtendedAttributes {
+			if a.Key == SignerProfileVersionAttribute {
+				if arn, ok := a.Value.(string); ok {
+					return arn
+				}
+			}
+		}
+
+		if len(info.CertificateChain) > 0 {
+			return info.CertificateChain[0].Subject.String()
+		}
+	}
+
+	return ""
+}
+
+// PluginConfig builds the signer plugin config from server config
+func PluginConfig() map[string]string {
+	pc := make(map[string]string)
+
+	if model.ServerConfig().Notation.SignerEndpoint != "" {
+		pc["signer-endpoint-url"] = model.ServerConfig().Notation.SignerEndpoint
+	}
+
+	if model.ServerConfig().Notation.SignerDebug {
+		pc["debug"] = "true"
+	}
+
+	return pc
+}
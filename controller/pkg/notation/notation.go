@@ -18,9 +18,13 @@ const (
 
 // TrustStore builds the notation trust store
 func TrustStore() (string, error) {
+	return AddCertificate(model.ServerConfig().Notation.TrustStore, model.ServerConfig().Notation.RootCert)
+}
+
+// AddCertificate adds a root certificate to the named signingAuthority trust store
+func AddCertificate(store string, cert string) (string, error) {
 	nc := Command{}
-	nc.Args = []string{"certificate", "add", "--type", "signingAuthority",
-		"--store", model.ServerConfig.Notation.TrustStore, model.ServerConfig.Notation.RootCert}
+	nc.Args = []string{"certificate", "add", "--type", "signingAuthority", "--store", store, cert}
 	nc.Execute()
 
 	if nc.Error != nil {
@@ -31,7 +35,9 @@ func TrustStore() (string, error) {
 }
 
 type Command struct {
-	Args    []string
+	Args []string
+	// XdgHome overrides the notation XDG config home, to select a namespace policy
+	XdgHome string
 	Subject string
 	Out     string
 	Err     string
@@ -44,10 +50,13 @@ func (nc *Command) Execute() {
 	//defer lock.Unlock()
 
 	var stderr, stdout bytes.Buffer
-	cmd := exec.Command(model.ServerConfig.Notation.BinaryDst, nc.Args...)
+	cmd := exec.Command(model.ServerConfig().Notation.BinaryDst, nc.Args...)
 	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", model.ServerConfig.Notation.XdgHomeVar,
-		model.ServerConfig.Notation.XdgHomeVal))
+	xdgHome := model.ServerConfig().Notation.XdgHomeVal
+	if nc.XdgHome != "" {
+		xdgHome = nc.XdgHome
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", model.ServerConfig().Notation.XdgHomeVar, xdgHome))
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
 	err := cmd.Run()
@@ -0,0 +1,322 @@
+package notation
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	notationgo "github.com/notaryproject/notation-go"
+
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	PoliciesDir = "policies"
+	// CrdPoliciesDir holds the trust policies generated from ImageVerificationPolicy objects
+	CrdPoliciesDir = "imageverificationpolicies"
+	// CrdPolicyPrefix prefixes generated trust policy names, which cannot clash with namespace policy names
+	CrdPolicyPrefix = "ivp:"
+)
+
+var (
+	policyName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+	dynamicLock     = &sync.RWMutex{}
+	dynamicPolicies = make(map[string]bool)
+)
+
+// ValidatePolicies validates the namespace policies of config c
+func ValidatePolicies(c *model.Config) error {
+	names := make(map[string]bool)
+
+	for _, p := range c.Notation.NamespacePolicies {
+		if !policyName.MatchString(p.Name) {
+			return fmt.Errorf("namespace policy name %q must be a lowercase DNS label", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("namespace policy %s is duplicated", p.Name)
+		}
+		names[p.Name] = true
+
+		if len(p.Namespaces) == 0 && len(p.Labels) == 0 {
+			return fmt.Errorf("namespace policy %s requires namespaces or namespaceLabels", p.Name)
+		}
+		for _, n := range p.Namespaces {
+			if _, err := path.Match(n, ""); err != nil {
+				return fmt.Errorf("namespace policy %s has malformed namespace pattern %s: %w", p.Name, n, err)
+			}
+		}
+		if p.TrustPolicy == "" {
+			return fmt.Errorf("namespace policy %s requires a trust policy", p.Name)
+		}
+		if (p.TrustStore == "") != (p.RootCert == "") {
+			return fmt.Errorf("namespace policy %s requires both trustStore and rootCert, or neither", p.Name)
+		}
+	}
+
+	return nil
+}
+
+// PolicyNames returns the default (""), namespace and generated policy names
+func PolicyNames() []string {
+	names := []string{""}
+	for _, p := range model.ServerConfig().Notation.NamespacePolicies {
+		names = append(names, p.Name)
+	}
+
+	dynamicLock.RLock()
+	var dynamic []string
+	for n := range dynamicPolicies {
+		dynamic = append(dynamic, n)
+	}
+	dynamicLock.RUnlock()
+	sort.Strings(dynamic)
+
+	return append(names, dynamic...)
+}
+
+// PolicyXdgHome returns the XDG config home of the named policy, the notation XDG home by default
+func PolicyXdgHome(name string) string {
+	if name == "" {
+		return model.ServerConfig().Notation.XdgHomeVal
+	}
+	if n, ok := strings.CutPrefix(name, CrdPolicyPrefix); ok {
+		return model.ServerConfig().Notation.XdgHomeVal + "/" + CrdPoliciesDir + "/" + n
+	}
+	return model.ServerConfig().Notation.XdgHomeVal + "/" + PoliciesDir + "/" + name
+}
+
+// PolicyHome returns the notation home of the named policy. Namespace policy homes link to the
+// trust store and plugins of the default notation home.
+func PolicyHome(name string) string {
+	if name == "" {
+		return model.ServerConfig().Notation.HomeDir
+	}
+	return PolicyXdgHome(name) + "/" + path.Base(model.ServerConfig().Notation.HomeDir)
+}
+
+// PolicyFile returns the trust policy file of the named policy
+func PolicyFile(name string) string {
+	return PolicyHome(name) + "/" + model.ServerConfig().Notation.TrustPolicy
+}
+
+// WritePolicy writes the trust policy of the named policy to its own notation home, linked to the
+// trust store and plugins of the default notation home. The trust policy file is replaced atomically.
+func WritePolicy(name string, trustPolicy []byte) error {
+	tmp, err := stagePolicy(name, trustPolicy)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, PolicyFile(name))
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("could not replace trust policy: %w", err)
+	}
+
+	return nil
+}
+
+// stagePolicy writes the trust policy of the named policy next to its trust policy file, in its own
+// notation home, returning the staged file
+func stagePolicy(name string, trustPolicy []byte) (string, error) {
+	home := PolicyHome(name)
+	err := utils.CreateDirectory(home)
+	if err != nil {
+		return "", fmt.Errorf("could not create notation home %s: %w", home, err)
+	}
+
+	// The default notation home holds the trust store and plugins
+	if name != "" {
+		for _, d := range []string{"truststore", "plugins"} {
+			if _, err = os.Lstat(home + "/" + d); err == nil {
+				continue
+			}
+			err = os.Symlink(model.ServerConfig().Notation.HomeDir+"/"+d, home+"/"+d)
+			if err != nil {
+				return "", fmt.Errorf("could not link %s: %w", d, err)
+			}
+		}
+	}
+
+	tmp := PolicyFile(name) + ".tmp"
+	err = utils.CreateFile(tmp, trustPolicy)
+	if err != nil {
+		return "", fmt.Errorf("could not write trust policy: %w", err)
+	}
+
+	return tmp, nil
+}
+
+// ReplacePolicy validates and writes, or replaces, the trust policy of the named policy, and rebuilds its
+// in-process verifier. An invalid trust policy is not written.
+func ReplacePolicy(name string, trustPolicy []byte) error {
+	return ReplacePolicies(map[string][]byte{name: trustPolicy})
+}
+
+// ReplacePolicies validates and stages the trust policies of the named policies, with their in-process
+// verifiers, then replaces them together. If any is invalid, or could not be staged, none is replaced.
+func ReplacePolicies(policies map[string][]byte) error {
+	staged := make(map[string]string)
+	defer func() {
+		for _, tmp := range staged {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	library := model.ServerConfig().Notation.Mode == model.LibraryMode
+	verifiers := make(map[string]notationgo.Verifier)
+	for name, b := range policies {
+		_, err := ValidateTrustPolicy(b)
+		if err != nil {
+			return fmt.Errorf("%q trust policy: %w", name, err)
+		}
+
+		tmp, err := stagePolicy(name, b)
+		if err != nil {
+			return fmt.Errorf("could not stage %q trust policy: %w", name, err)
+		}
+		staged[name] = tmp
+
+		if library {
+			v, err := newLibraryVerifier(tmp)
+			if err != nil {
+				return fmt.Errorf("could not create notation verifier for %q trust policy: %w", name, err)
+			}
+			verifiers[name] = v
+		}
+	}
+
+	for name, tmp := range staged {
+		err := os.Rename(tmp, PolicyFile(name))
+		if err != nil {
+			return fmt.Errorf("could not replace %q trust policy: %w", name, err)
+		}
+		delete(staged, name)
+	}
+
+	setLibraryVerifiers(verifiers)
+	refreshPolicyHash()
+
+	return nil
+}
+
+// AddPolicy writes, or replaces, the named generated trust policy and makes it available for verification
+func AddPolicy(name string, trustPolicy []byte) error {
+	err := ReplacePolicy(name, trustPolicy)
+	if err != nil {
+		return err
+	}
+
+	dynamicLock.Lock()
+	dynamicPolicies[name] = true
+	dynamicLock.Unlock()
+	refreshPolicyHash()
+
+	log.Log.Debugf("%s trust policy added", name)
+
+	return nil
+}
+
+// RemovePolicy removes the named generated trust policy
+func RemovePolicy(name string) error {
+	dynamicLock.Lock()
+	delete(dynamicPolicies, name)
+	dynamicLock.Unlock()
+
+	if model.ServerConfig().Notation.Mode == model.LibraryMode {
+		removeLibraryVerifier(name)
+	}
+
+	err := os.RemoveAll(PolicyXdgHome(name))
+	if err != nil {
+		return fmt.Errorf("could not remove %s trust policy: %w", name, err)
+	}
+	refreshPolicyHash()
+
+	log.Log.Debugf("%s trust policy removed", name)
+
+	return nil
+}
+
+// refreshPolicyHash refreshes the trust policy hash after a trust policy change. Verification results are
+// not cached while the hash cannot be computed.
+func refreshPolicyHash() {
+	if err := RefreshPolicyHash(); err != nil {
+		log.Log.Errorf("could not hash trust policies, verification cache skipped: %v", err)
+	}
+}
+
+// PolicyFor returns the name of the first namespace policy matching namespace,
+// or "" for the default trust policy
+func PolicyFor(namespace string) (string, error) {
+	var labels map[string]string
+
+	for _, p := range model.ServerConfig().Notation.NamespacePolicies {
+		if len(p.Namespaces) > 0 && !namespaceMatches(p.Namespaces, namespace) {
+			continue
+		}
+
+		if len(p.Labels) > 0 && labels == nil {
+			l, err := kube.NamespaceLabels(namespace)
+			if err != nil {
+				return "", fmt.Errorf("could not select trust policy for %s namespace: %w", namespace, err)
+			}
+			labels = l
+			if labels == nil {
+				labels = map[string]string{}
+			}
+		}
+
+		if PolicyMatches(p, namespace, labels) {
+			return p.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// PolicyMatches determines if namespace policy p matches namespace, with labels
+func PolicyMatches(p model.NamespacePolicy, namespace string, labels map[string]string) bool {
+	if len(p.Namespaces) > 0 && !namespaceMatches(p.Namespaces, namespace) {
+		return false
+	}
+	return len(p.Labels) == 0 || labelsMatch(p.Labels, labels)
+}
+
+// UsesNamespaceLabels determines if any namespace policy selects namespaces by labels
+func UsesNamespaceLabels() bool {
+	for _, p := range model.ServerConfig().Notation.NamespacePolicies {
+		if len(p.Labels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceMatches matches namespace against name patterns
+func namespaceMatches(patterns []string, namespace string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// labelsMatch determines if labels contain all selector labels
+func labelsMatch(selector map[string]string, labels map[string]string) bool {
+	for k, v := range selector {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package notation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/utils"
+)
+
+type policyFileHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+var (
+	policyLock   = &sync.Mutex{}
+	policyHashes = make(map[string]policyFileHash)
+	// policyHash is the combined trust policy hash, refreshed when trust policies are written or removed
+	policyHash atomic.Pointer[string]
+)
+
+// NewRepository creates a remote repository client for the normalized image reference,
+// using registry basic auth creds
+func NewRepository(image string, username string, password string) (*remote.Repository, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := remote.NewRepository(ref.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image reference %s: %w", image, err)
+	}
+	repo.Reference.Registry = utils.RegistryHost(ref.Registry)
+	repo.PlainHTTP = InsecureRegistry(ref.Registry)
+
+	repo.Client = &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+		Credential: auth.StaticCredential(repo.Reference.Registry, auth.Credential{
+			Username: username,
+			Password: password,
+		}),
+	}
+
+	return repo, nil
+}
+
+// InsecureRegistry determines if registry is accessed with plain HTTP
+func InsecureRegistry(registry string) bool {
+	for _, r := range model.ServerConfig().Notation.InsecureRegistries {
+		if r == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve resolves the image tag to its manifest descriptor
+func Resolve(ctx context.Context, image string, username string, password string) (ocispec.Descriptor, error) {
+	repo, err := NewRepository(image, username, password)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	desc, err := repo.Resolve(ctx, repo.Reference.Reference)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("could not resolve %s: %w", image, err)
+	}
+
+	return desc, nil
+}
+
+// DigestReference builds the normalized registry/repository@digest form of image
+func DigestReference(image string, digest string) (string, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	return ref.WithDigest(digest), nil
+}
+
+// PolicyHash returns the combined trust policy hash computed by the last RefreshPolicyHash
+func PolicyHash() (string, error) {
+	h := policyHash.Load()
+	if h == nil {
+		return "", fmt.Errorf("trust policy hash not computed")
+	}
+	return *h, nil
+}
+
+// RefreshPolicyHash computes and stores the SHA-256 hash of the default, namespace and generated trust
+// policy files, each re-hashed when the file changes. The hash is cleared if any file cannot be hashed.
+func RefreshPolicyHash() error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	h := sha256.New()
+	for _, name := range PolicyNames() {
+		fh, err := fileHash(PolicyFile(name))
+		if err != nil {
+			policyHash.Store(nil)
+			return err
+		}
+		h.Write([]byte(name + ":" + fh + "\n"))
+	}
+
+	s := hex.EncodeToString(h.Sum(nil))
+	policyHash.Store(&s)
+
+	return nil
+}
+
+// fileHash returns the cached SHA-256 hash of a trust policy file, re-hashed when the file changes
+func fileHash(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("could not stat trust policy: %w", err)
+	}
+
+	if ph, ok := policyHashes[path]; ok && fi.ModTime().Equal(ph.modTime) && fi.Size() == ph.size {
+		return ph.hash, nil
+	}
+
+	b, err := utils.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read trust policy: %w", err)
+	}
+
+	sum := sha256.Sum256(b)
+	ph := policyFileHash{modTime: fi.ModTime(), size: fi.Size(), hash: hex.EncodeToString(sum[:])}
+	policyHashes[path] = ph
+
+	return ph.hash, nil
+}
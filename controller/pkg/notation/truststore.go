@@ -0,0 +1,100 @@
+package notation
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/notaryproject/notation-go/dir"
+	"github.com/notaryproject/notation-go/verifier/truststore"
+
+	"notary-admission/pkg/model"
+)
+
+// trustStoreSnapshot holds the validated certificates of every named trust store, by type/name
+type trustStoreSnapshot struct {
+	certs map[string][]*x509.Certificate
+}
+
+var activeTrustStore atomic.Pointer[trustStoreSnapshot]
+
+// snapshotTrustStore is the trust store of the in-process notation verifiers, serving the active snapshot
+type snapshotTrustStore struct{}
+
+// GetCertificates returns the certificates of the named trust store from the active snapshot
+func (snapshotTrustStore) GetCertificates(_ context.Context, storeType truststore.Type,
+	namedStore string) ([]*x509.Certificate, error) {
+	s := activeTrustStore.Load()
+	if s == nil {
+		return nil, truststore.TrustStoreError{Msg: "trust store not loaded"}
+	}
+
+	certs, ok := s.certs[string(storeType)+"/"+namedStore]
+	if !ok {
+		return nil, truststore.TrustStoreError{
+			Msg: fmt.Sprintf("the trust store %q of type %q does not exist", namedStore, storeType)}
+	}
+
+	return certs, nil
+}
+
+// PrepareTrustStore validates every named trust store in the notation home, returning the function
+// that swaps in its snapshot, and the trust store hash
+func PrepareTrustStore() (func(), string, error) {
+	home := model.ServerConfig().Notation.HomeDir
+	fsys := dir.NewSysFS(home)
+	store := truststore.NewX509TrustStore(fsys)
+
+	s := &trustStoreSnapshot{certs: make(map[string][]*x509.Certificate)}
+	h := sha256.New()
+
+	root, err := fsys.SysPath(dir.TrustStoreDir, "x509")
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get trust store path: %w", err)
+	}
+
+	types, err := os.ReadDir(root)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read trust store: %w", err)
+	}
+
+	for _, t := range types {
+		if !t.IsDir() {
+			continue
+		}
+
+		stores, err := os.ReadDir(filepath.Join(root, t.Name()))
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read %s trust stores: %w", t.Name(), err)
+		}
+
+		for _, n := range stores {
+			certs, err := store.GetCertificates(context.Background(), truststore.Type(t.Name()), n.Name())
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid %s/%s trust store: %w", t.Name(), n.Name(), err)
+			}
+			s.certs[t.Name()+"/"+n.Name()] = certs
+		}
+	}
+
+	keys := make([]string, 0, len(s.certs))
+	for k := range s.certs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k + "\n"))
+		for _, c := range s.certs[k] {
+			sum := sha256.Sum256(c.Raw)
+			h.Write([]byte(hex.EncodeToString(sum[:]) + "\n"))
+		}
+	}
+
+	return func() { activeTrustStore.Store(s) }, hex.EncodeToString(h.Sum(nil)), nil
+}
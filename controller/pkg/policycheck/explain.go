@@ -0,0 +1,183 @@
+package policycheck
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+// placeholderDigest stands in for the manifest digest, which is resolved from the registry
+var placeholderDigest = "sha256:" + strings.Repeat("0", 64)
+
+// Explanation describes how an image admitted in a namespace would be verified
+type Explanation struct {
+	Image     string
+	Reference string
+	Namespace string
+	// Bypass is the ecr.ignoreRegistries entry bypassing verification, if any
+	Bypass string
+	// Verifier is the verifier selected by the image registry, and Pattern its matching registry pattern
+	Verifier   string
+	Pattern    string
+	Signatures string
+	// SignatureReference is where the signatures are looked up, after signature repository mapping
+	SignatureReference string
+	// NamespacePolicy is the namespace policy selected, "" for the default trust policy
+	NamespacePolicy string
+	// Statement is the trust policy statement applicable to the signature reference, and Scope its
+	// matching registry scope
+	Statement         string
+	Scope             string
+	Level             string
+	TrustStores       []string
+	TrustedIdentities []string
+	Enforcement       string
+	Notes             []string
+}
+
+// Explain explains which bypass rule, verifier, trust policy, trust stores and trusted identities apply
+// to image in namespace, with labels. ImageVerificationPolicy objects are not evaluated offline.
+func Explain(p *Policies, image string, namespace string, labels map[string]string) (*Explanation, error) {
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	c := p.Config
+	e := &Explanation{Image: image, Reference: ref.String(), Namespace: namespace, Enforcement: enforcement(c, labels)}
+
+	if c.ImageVerificationPolicies.Enabled {
+		e.Notes = append(e.Notes, "ImageVerificationPolicy objects are not evaluated offline, "+
+			"a matching policy takes precedence over the trust policy below")
+	}
+
+	if contains(c.Ecr.IgnoreRegistries, ref.Registry) {
+		e.Bypass = ref.Registry
+		return e, nil
+	}
+
+	vc, pattern := verifierFor(c, ref.Registry)
+	if vc == nil {
+		return nil, fmt.Errorf("no verifier registered for registry %s", ref.Registry)
+	}
+	e.Verifier = fmt.Sprintf("%s (%s)", vc.Name, vc.Type)
+	e.Pattern = pattern
+	e.Signatures = vc.Signatures
+	if e.Signatures == "" {
+		e.Signatures = model.SignaturesNotation
+	}
+
+	policy, err := verifier.NewPolicy(model.VerifierConfig{Name: vc.Name, SignatureRepositories: vc.SignatureRepositories})
+	if err != nil {
+		return nil, err
+	}
+	sigRef, err := policy.SignatureReference(ref.String(), placeholderDigest)
+	if err != nil {
+		return nil, err
+	}
+	e.SignatureReference = strings.TrimSuffix(sigRef, "@"+placeholderDigest)
+
+	if e.Signatures == model.SignaturesCosign {
+		e.Notes = append(e.Notes, fmt.Sprintf("verified with %s cosign keys and identities, "+
+			"not a notation trust policy", vc.Name))
+		return e, nil
+	}
+
+	for _, np := range c.Notation.NamespacePolicies {
+		if notation.PolicyMatches(np, namespace, labels) {
+			e.NamespacePolicy = np.Name
+			break
+		}
+	}
+
+	doc, err := notation.ValidateTrustPolicy(p.TrustPolicies[e.NamespacePolicy])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", policySource(e.NamespacePolicy), err)
+	}
+
+	tp, err := doc.GetApplicableTrustPolicy(sigRef)
+	if err != nil {
+		e.Notes = append(e.Notes, "no applicable trust policy statement, verification fails")
+		return e, nil
+	}
+
+	e.Statement = tp.Name
+	e.Scope = wildcard
+	if contains(tp.RegistryScopes, e.SignatureReference) {
+		e.Scope = e.SignatureReference
+	}
+	e.Level = tp.SignatureVerification.VerificationLevel
+	e.TrustStores = tp.TrustStores
+	e.TrustedIdentities = tp.TrustedIdentities
+
+	if e.Signatures == model.SignaturesEither {
+		e.Notes = append(e.Notes, fmt.Sprintf("%s cosign keys and identities are verified if notation "+
+			"verification fails", vc.Name))
+	}
+
+	return e, nil
+}
+
+// Write writes the explanation as aligned fields
+func (e *Explanation) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", name, value)
+		}
+	}
+
+	field("image", e.Image)
+	field("reference", e.Reference)
+	field("namespace", e.Namespace)
+	field("enforcement", e.Enforcement)
+
+	if e.Bypass != "" {
+		field("bypass", fmt.Sprintf("%s in ecr.ignoreRegistries, verification bypassed", e.Bypass))
+	} else {
+		field("verifier", fmt.Sprintf("%s, registry pattern %s", e.Verifier, e.Pattern))
+		field("signatures", e.Signatures)
+		field("signature repository", e.SignatureReference)
+		if e.Signatures != model.SignaturesCosign {
+			np := e.NamespacePolicy
+			if np == "" {
+				np = DefaultPolicy
+			}
+			field("trust policy", np)
+		}
+		if e.Statement != "" {
+			field("statement", fmt.Sprintf("%s, registry scope %s", e.Statement, e.Scope))
+			field("verification level", e.Level)
+			field("trust stores", strings.Join(e.TrustStores, ", "))
+			field("trusted identities", strings.Join(e.TrustedIdentities, ", "))
+		}
+	}
+
+	for _, n := range e.Notes {
+		field("note", n)
+	}
+
+	return tw.Flush()
+}
+
+// enforcement returns the enforcement mode, from the namespace enforcement label, if set in labels
+func enforcement(c *model.Config, labels map[string]string) string {
+	mode := c.Enforcement.Mode
+	if mode == "" {
+		mode = model.EnforcementEnforce
+	}
+
+	if l, ok := labels[c.Enforcement.NamespaceLabel]; ok && c.Enforcement.NamespaceLabel != "" &&
+		workloads.ValidEnforcementMode(l) {
+		return fmt.Sprintf("%s, from %s namespace label", l, c.Enforcement.NamespaceLabel)
+	}
+
+	return mode
+}
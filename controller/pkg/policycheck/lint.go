@@ -0,0 +1,387 @@
+package policycheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+
+	// DefaultPolicy names the default trust policy in findings
+	DefaultPolicy = "default"
+
+	wildcard = "*"
+)
+
+var (
+	signerArn     = regexp.MustCompile(`^arn:aws(-cn|-us-gov)?:signer:[a-z0-9-]+:[0-9]{12}:/signing-profiles/[A-Za-z0-9_]{2,64}$`)
+	storeTypes    = map[string]bool{"ca": true, "signingAuthority": true, "tsa": true}
+	dockerHubHost = map[string]bool{"index.docker.io": true, utils.DockerHubHost: true}
+)
+
+// Finding is a config or trust policy problem found by Lint
+type Finding struct {
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// String formats the finding for CLI output
+func (f Finding) String() string {
+	return fmt.Sprintf("%-7s %s: %s", f.Severity, f.Source, f.Message)
+}
+
+// Policies are a server config and the trust policies it references
+type Policies struct {
+	Config *model.Config
+	// TrustPolicies are the trust policy documents by namespace policy name, "" for the default trust policy
+	TrustPolicies map[string][]byte
+	// Home is the notation home whose existing trust stores are known, if set
+	Home string
+}
+
+// Load loads the server config and default trust policy files, and the namespace trust policy files
+// they reference. Namespace trust policy files not found are looked up next to the default trust policy,
+// as they are mounted from the same ConfigMap.
+func Load(configFile string, trustPolicyFile string) (*Policies, error) {
+	c := &model.Config{}
+	if err := c.LoadConfig(configFile); err != nil {
+		return nil, fmt.Errorf("could not load config: %w", err)
+	}
+
+	p := &Policies{Config: c, TrustPolicies: make(map[string][]byte)}
+
+	b, err := utils.ReadFile(trustPolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read trust policy: %w", err)
+	}
+	p.TrustPolicies[""] = b
+
+	for _, np := range c.Notation.NamespacePolicies {
+		if np.TrustPolicy == "" {
+			continue
+		}
+
+		file := np.TrustPolicy
+		if !utils.FileExists(file) {
+			file = filepath.Join(filepath.Dir(trustPolicyFile), filepath.Base(np.TrustPolicy))
+		}
+
+		b, err = utils.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s namespace trust policy: %w", np.Name, err)
+		}
+		p.TrustPolicies[np.Name] = b
+	}
+
+	return p, nil
+}
+
+// Lint validates the config and trust policies, returning errors that fail admissions or server
+// startup, and warnings of settings that do not take effect
+func Lint(p *Policies) []Finding {
+	var findings []Finding
+	add := func(severity string, source string, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Source: source, Message: fmt.Sprintf(format, args...)})
+	}
+
+	c := p.Config
+	if err := notation.ValidatePolicies(c); err != nil {
+		add(SeverityError, "notation.namespacePolicies", "%v", err)
+	}
+	if err := workloads.ValidateEnforcement(c); err != nil {
+		add(SeverityError, "enforcement", "%v", err)
+	}
+
+	lintNamespacePolicies(c, add)
+	lintVerifiers(c, add)
+	lintIgnoreRegistries(c, add)
+
+	stores := p.trustStores()
+	names := make([]string, 0, len(p.TrustPolicies))
+	for n := range p.TrustPolicies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		lintTrustPolicy(c, policySource(n), p.TrustPolicies[n], stores, add)
+	}
+
+	return findings
+}
+
+// HasErrors determines if any finding is an error
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+type addFunc func(severity string, source string, format string, args ...interface{})
+
+// lintNamespacePolicies warns of namespace policies never selected, after a policy matching all namespaces
+func lintNamespacePolicies(c *model.Config, add addFunc) {
+	for i, np := range c.Notation.NamespacePolicies {
+		if len(np.Labels) > 0 || !contains(np.Namespaces, wildcard) {
+			continue
+		}
+		for _, shadowed := range c.Notation.NamespacePolicies[i+1:] {
+			add(SeverityWarning, "notation.namespacePolicies",
+				"%s policy is never selected, %s policy matches all namespaces first", shadowed.Name, np.Name)
+		}
+		return
+	}
+}
+
+// lintVerifiers validates the verifier types, registry patterns and signature formats, and warns of
+// verifiers never selected, after a verifier matching all registries
+func lintVerifiers(c *model.Config, add addFunc) {
+	catchAll := ""
+	for _, vc := range c.Verifiers {
+		source := "verifier " + vc.Name
+
+		switch vc.Type {
+		case model.VerifierTypeEcr, model.VerifierTypeOci, model.VerifierTypeEcrPublic:
+		default:
+			add(SeverityError, source, "unsupported type %q", vc.Type)
+		}
+
+		switch vc.Signatures {
+		case "", model.SignaturesNotation, model.SignaturesCosign, model.SignaturesEither:
+		default:
+			add(SeverityError, source, "unsupported signatures %q", vc.Signatures)
+		}
+
+		for _, m := range vc.SignatureRepositories {
+			if m.Prefix == "" || m.Repository == "" {
+				add(SeverityError, source, "signature repositories require prefix and repository")
+			}
+		}
+
+		if catchAll != "" {
+			add(SeverityWarning, source, "never selected, %s verifier matches all registries first", catchAll)
+			continue
+		}
+
+		for _, r := range vc.Registries {
+			if _, err := path.Match(r, ""); err != nil {
+				add(SeverityError, source, "malformed registry pattern %q: %v", r, err)
+			}
+			if r == wildcard {
+				catchAll = vc.Name
+			}
+		}
+	}
+}
+
+// lintIgnoreRegistries warns of ignored registries that never match, as images are bypassed by exact
+// registry host, after normalization
+func lintIgnoreRegistries(c *model.Config, add addFunc) {
+	const source = "ecr.ignoreRegistries"
+	seen := make(map[string]bool)
+
+	for _, r := range c.Ecr.IgnoreRegistries {
+		switch {
+		case seen[r]:
+			add(SeverityWarning, source, "%s is duplicated", r)
+		case strings.ContainsAny(r, "*?["):
+			add(SeverityWarning, source, "%s is unused, registries are matched by exact host, not pattern", r)
+		case strings.Contains(r, "/"):
+			add(SeverityWarning, source, "%s is unused, registries are matched by host, without scheme or repository", r)
+		case dockerHubHost[r]:
+			add(SeverityWarning, source, "%s is unused, Docker Hub images are normalized to %s", r, utils.DockerHubRegistry)
+		case r != strings.ToLower(r):
+			add(SeverityWarning, source, "%s is unused, image registries are lowercase", r)
+		}
+		seen[r] = true
+	}
+}
+
+// lintTrustPolicy validates a trust policy document with notation, and checks its registry scopes,
+// trust stores and trusted identities against the config
+func lintTrustPolicy(c *model.Config, source string, b []byte, stores map[string]bool, add addFunc) {
+	var tp model.TrustPolicyModel
+	if err := json.Unmarshal(b, &tp); err != nil {
+		add(SeverityError, source, "could not parse trust policy: %v", err)
+		return
+	}
+
+	if _, err := notation.ValidateTrustPolicy(b); err != nil {
+		add(SeverityError, source, "%v", err)
+	}
+
+	scopes := make(map[string]string)
+	hasWildcard := false
+
+	for _, s := range tp.TrustPolicies {
+		statement := source + "/" + s.Name
+
+		for _, scope := range s.RegistryScopes {
+			if other, ok := scopes[scope]; ok {
+				add(SeverityError, statement, "registry scope %s overlaps %s statement", scope, other)
+				continue
+			}
+			scopes[scope] = s.Name
+
+			if scope == wildcard {
+				hasWildcard = true
+				continue
+			}
+			lintScope(c, statement, scope, add)
+		}
+
+		if s.SignatureVerification.Level == "skip" {
+			continue
+		}
+
+		for _, ts := range s.TrustStores {
+			t, name, ok := strings.Cut(ts, ":")
+			switch {
+			case !ok || !storeTypes[t]:
+				add(SeverityError, statement, "trust store %q must be ca, signingAuthority or tsa:<name>", ts)
+			case name == "":
+				add(SeverityError, statement, "trust store %q has no name", ts)
+			case !stores[ts]:
+				add(SeverityError, statement, "unknown trust store %s, not configured by notation.trustStore "+
+					"or a namespace policy trustStore", ts)
+			}
+		}
+
+		for _, id := range s.TrustedIdentities {
+			switch {
+			case id == wildcard || strings.HasPrefix(id, "x509.subject:"):
+			case strings.ContainsAny(id, "<>"):
+				add(SeverityError, statement, "trusted identity %s has unreplaced placeholders", id)
+			case strings.HasPrefix(id, "arn:"):
+				if !signerArn.MatchString(id) {
+					add(SeverityError, statement, "trusted identity %s is not a valid AWS Signer signing profile ARN, "+
+						"arn:<partition>:signer:<region>:<account>:/signing-profiles/<name>", id)
+				}
+			default:
+				add(SeverityWarning, statement, "trusted identity %s is neither an AWS Signer signing profile ARN "+
+					"nor an x509.subject", id)
+			}
+		}
+	}
+
+	if !hasWildcard && len(tp.TrustPolicies) > 0 {
+		add(SeverityWarning, source, "no %q registry scope, images of other repositories have no applicable "+
+			"trust policy and fail verification", wildcard)
+	}
+}
+
+// lintScope warns of registry scopes never verified, as their registry is bypassed or has no verifier
+func lintScope(c *model.Config, statement string, scope string, add addFunc) {
+	named, err := reference.ParseNamed(scope)
+	if err != nil {
+		// Reported by notation validation
+		return
+	}
+
+	registry := reference.Domain(named)
+	if contains(c.Ecr.IgnoreRegistries, registry) {
+		add(SeverityWarning, statement, "registry scope %s is unused, %s is in ecr.ignoreRegistries", scope, registry)
+		return
+	}
+
+	if vc, _ := verifierFor(c, registry); vc == nil {
+		add(SeverityWarning, statement, "registry scope %s is unused, no verifier is registered for %s", scope, registry)
+	}
+}
+
+// trustStores returns the known type:name trust stores, those the init container creates, and those
+// existing in the notation home, if set
+func (p *Policies) trustStores() map[string]bool {
+	stores := make(map[string]bool)
+	c := p.Config
+
+	if c.Notation.TrustStore != "" {
+		stores["signingAuthority:"+c.Notation.TrustStore] = true
+	}
+	for _, np := range c.Notation.NamespacePolicies {
+		if np.TrustStore != "" {
+			stores["signingAuthority:"+np.TrustStore] = true
+		}
+	}
+
+	if p.Home == "" {
+		return stores
+	}
+
+	root := filepath.Join(p.Home, "truststore", "x509")
+	types, err := os.ReadDir(root)
+	if err != nil {
+		return stores
+	}
+	for _, t := range types {
+		named, err := os.ReadDir(filepath.Join(root, t.Name()))
+		if err != nil {
+			continue
+		}
+		for _, n := range named {
+			stores[t.Name()+":"+n.Name()] = true
+		}
+	}
+
+	return stores
+}
+
+// verifierFor returns the first verifier config with a registry pattern matching registry, and the
+// pattern, mirroring verifier.Lookup. The defaults are returned if no verifiers are configured.
+func verifierFor(c *model.Config, registry string) (*model.VerifierConfig, string) {
+	if len(c.Verifiers) == 0 {
+		vc := &model.VerifierConfig{Name: model.VerifierTypeEcr, Type: model.VerifierTypeEcr}
+		if registry == verifier.EcrPublicRegistry {
+			vc = &model.VerifierConfig{Name: model.VerifierTypeEcrPublic, Type: model.VerifierTypeEcrPublic}
+			return vc, verifier.EcrPublicRegistry
+		}
+		return vc, wildcard
+	}
+
+	for i, vc := range c.Verifiers {
+		for _, r := range vc.Registries {
+			if ok, _ := path.Match(r, registry); ok {
+				return &c.Verifiers[i], r
+			}
+		}
+	}
+
+	return nil, ""
+}
+
+// policySource returns the findings source of the named trust policy
+func policySource(name string) string {
+	if name == "" {
+		return "trust policy " + DefaultPolicy
+	}
+	return "trust policy " + name
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
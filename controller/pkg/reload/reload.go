@@ -0,0 +1,435 @@
+package reload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/exemption"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+
+	ComponentConfig      = "config"
+	ComponentTrustPolicy = "trustpolicy"
+	ComponentTrustStore  = "truststore"
+
+	ResultApplied  = "applied"
+	ResultRejected = "rejected"
+)
+
+// Watcher polls the config file, trust policy files and trust store directory, validating changes
+// before swapping them in. Invalid changes are logged and counted, and the active version is kept.
+type Watcher struct {
+	configFile      string
+	trustPolicyFile string
+	// hashes are the hashes of the active config and trust store, and of each trust policy source
+	hashes map[string]string
+	// rejected are the hashes of rejected changes, which are not retried until changed again
+	rejected map[string]string
+	metric   *metrics.PrometheusReloadMetric
+}
+
+// NewWatcher creates a Watcher of the active config and trust policy files, and trust store
+func NewWatcher(configFile string, trustPolicyFile string) (*Watcher, error) {
+	w := &Watcher{
+		configFile:      configFile,
+		trustPolicyFile: trustPolicyFile,
+		hashes:          make(map[string]string),
+		rejected:        make(map[string]string),
+		metric:          metrics.InitPrometheusReloadMetric(model.ServerConfig().Prometheus.Name),
+	}
+
+	h, err := fileHash(configFile)
+	if err != nil {
+		return nil, err
+	}
+	w.hashes[ComponentConfig] = h
+	w.metric.SetActive(ComponentConfig, h)
+
+	policies, err := w.trustPolicies(model.ServerConfig())
+	if err != nil {
+		return nil, err
+	}
+	for name, b := range policies {
+		w.hashes[policyKey(name)] = bytesHash(b)
+	}
+	w.metric.SetActive(ComponentTrustPolicy, w.trustPolicyHash())
+
+	fp, err := trustStoreFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	_, ts, err := notation.PrepareTrustStore()
+	if err != nil {
+		return nil, err
+	}
+	w.hashes[ComponentTrustStore] = fp
+	w.metric.SetActive(ComponentTrustStore, ts)
+
+	return w, nil
+}
+
+// Start checks for changes every interval, until stop is closed
+func (w *Watcher) Start(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	log.Log.Infof("watching %s, trust policies and trust store for changes, every %s", w.configFile, interval)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		w.Check()
+	}
+}
+
+// Check applies changes to the config, trust policies and trust store, if valid
+func (w *Watcher) Check() {
+	w.checkConfig()
+	w.checkTrustPolicies(model.ServerConfig())
+	w.checkTrustStore()
+}
+
+// checkConfig validates and swaps in a changed config file. Settings only read at startup keep
+// their active values until restart.
+func (w *Watcher) checkConfig() {
+	h, err := fileHash(w.configFile)
+	if err != nil {
+		log.Log.Errorf("could not check config: %v", err)
+		return
+	}
+	if !w.changed(ComponentConfig, h) {
+		return
+	}
+
+	err = w.reloadConfig()
+	if err != nil {
+		w.reject(ComponentConfig, h, err)
+		return
+	}
+
+	w.hashes[ComponentConfig] = h
+	w.metric.Reloads.WithLabelValues(ComponentConfig, ResultApplied).Inc()
+	w.metric.SetActive(ComponentConfig, h)
+	log.Log.Infof("config %s reloaded, hash %s", w.configFile, h)
+}
+
+// reloadConfig loads, validates and swaps in the config file, with its verifiers, exemptions and
+// namespace trust policies
+func (w *Watcher) reloadConfig() error {
+	active := model.ServerConfig()
+
+	c := &model.Config{}
+	err := c.LoadConfig(w.configFile)
+	if err != nil {
+		return fmt.Errorf("could not load config: %w", err)
+	}
+
+	for _, f := range keepStartupSettings(active, c) {
+		log.Log.Warnf("config %s changed, applied on restart", f)
+	}
+
+	err = notation.ValidatePolicies(c)
+	if err != nil {
+		return fmt.Errorf("invalid namespace policies: %w", err)
+	}
+
+	err = workloads.ValidateEnforcement(c)
+	if err != nil {
+		return fmt.Errorf("invalid enforcement config: %w", err)
+	}
+
+	policies, err := w.trustPolicies(c)
+	if err != nil {
+		return err
+	}
+
+	swapVerifiers, err := verifier.PrepareVerifiers(c)
+	if err != nil {
+		return fmt.Errorf("could not register verifiers: %w", err)
+	}
+
+	swapExemptions, err := exemption.PrepareExemptions(c)
+	if err != nil {
+		return fmt.Errorf("could not load exemption keys: %w", err)
+	}
+
+	// Trust policies of added namespace policies are written before they can be selected, and the
+	// config is not swapped in if any could not be written
+	err = w.applyTrustPolicies(policies)
+	if err != nil {
+		return err
+	}
+
+	model.SetServerConfig(c)
+	swapVerifiers()
+	swapExemptions()
+
+	if verifier.Vc != nil {
+		// Namespace policies may have been added or removed
+		err = notation.RefreshPolicyHash()
+		if err != nil {
+			log.Log.Errorf("could not hash trust policies, verification cache skipped: %v", err)
+		}
+		verifier.Vc.Purge("config changed")
+	}
+
+	return nil
+}
+
+// checkTrustPolicies validates and swaps in changed trust policy files, all or none
+func (w *Watcher) checkTrustPolicies(c *model.Config) {
+	policies, err := w.trustPolicies(c)
+	if err != nil {
+		if w.rejected[ComponentTrustPolicy] != err.Error() {
+			w.rejected[ComponentTrustPolicy] = err.Error()
+			w.reject(ComponentTrustPolicy, "", err)
+		}
+		return
+	}
+	delete(w.rejected, ComponentTrustPolicy)
+
+	err = w.applyTrustPolicies(policies)
+	if err != nil {
+		w.reject(ComponentTrustPolicy, "", err)
+	}
+}
+
+// applyTrustPolicies writes the validated trust policies that changed together, all or none, returning
+// an error if any could not be written
+func (w *Watcher) applyTrustPolicies(policies map[string][]byte) error {
+	changed := make(map[string][]byte)
+	for name, b := range policies {
+		if w.hashes[policyKey(name)] != bytesHash(b) {
+			changed[name] = b
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	err := notation.ReplacePolicies(changed)
+	if err != nil {
+		return fmt.Errorf("could not write trust policies: %w", err)
+	}
+
+	for name, b := range changed {
+		w.hashes[policyKey(name)] = bytesHash(b)
+		w.metric.Reloads.WithLabelValues(ComponentTrustPolicy, ResultApplied).Inc()
+		log.Log.Infof("%q trust policy reloaded", name)
+	}
+	w.metric.SetActive(ComponentTrustPolicy, w.trustPolicyHash())
+
+	return nil
+}
+
+// trustPolicies reads and validates the default and namespace trust policy source files of config c,
+// by policy name
+func (w *Watcher) trustPolicies(c *model.Config) (map[string][]byte, error) {
+	files := make(map[string]string)
+	if w.trustPolicyFile != "" {
+		files[""] = w.trustPolicyFile
+	}
+	for _, np := range c.Notation.NamespacePolicies {
+		files[np.Name] = np.TrustPolicy
+	}
+
+	policies := make(map[string][]byte)
+	for name, f := range files {
+		var tp model.TrustPolicyModel
+		err := tp.LoadTrustpolicy(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not load %q trust policy: %w", name, err)
+		}
+
+		b, err := tp.Json()
+		if err != nil {
+			return nil, fmt.Errorf("could not read %q trust policy: %w", name, err)
+		}
+
+		_, err = notation.ValidateTrustPolicy(b)
+		if err != nil {
+			return nil, fmt.Errorf("%q trust policy: %w", name, err)
+		}
+
+		policies[name] = b
+	}
+
+	return policies, nil
+}
+
+// checkTrustStore validates and swaps in the trust store when its files change
+func (w *Watcher) checkTrustStore() {
+	fp, err := trustStoreFingerprint()
+	if err != nil {
+		log.Log.Errorf("could not check trust store: %v", err)
+		return
+	}
+	if !w.changed(ComponentTrustStore, fp) {
+		return
+	}
+
+	swap, h, err := notation.PrepareTrustStore()
+	if err != nil {
+		w.reject(ComponentTrustStore, fp, err)
+		return
+	}
+
+	swap()
+	if verifier.Vc != nil {
+		verifier.Vc.Purge("trust store changed")
+	}
+
+	w.hashes[ComponentTrustStore] = fp
+	w.metric.Reloads.WithLabelValues(ComponentTrustStore, ResultApplied).Inc()
+	w.metric.SetActive(ComponentTrustStore, h)
+	log.Log.Infof("trust store reloaded, hash %s", h)
+}
+
+// changed determines if a component hash differs from the active and last rejected hashes
+func (w *Watcher) changed(component string, hash string) bool {
+	return hash != w.hashes[component] && hash != w.rejected[component]
+}
+
+// reject logs and counts a rejected change, remembering its hash so it is not retried
+func (w *Watcher) reject(component string, hash string, err error) {
+	log.Log.Errorf("%s change rejected, keeping active version: %v", component, err)
+	w.metric.Reloads.WithLabelValues(component, ResultRejected).Inc()
+	if hash != "" {
+		w.rejected[component] = hash
+	}
+}
+
+// trustPolicyHash combines the active trust policy source hashes
+func (w *Watcher) trustPolicyHash() string {
+	var keys []string
+	for k := range w.hashes {
+		if strings.HasPrefix(k, ComponentTrustPolicy+"/") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + ":" + w.hashes[k] + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keepStartupSettings keeps the active values of settings only read at startup in config c,
+// returning the names of those that changed
+func keepStartupSettings(active *model.Config, c *model.Config) []string {
+	var changed []string
+	keep := func(name string, a interface{}, b interface{}) {
+		av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+		if !reflect.DeepEqual(av.Interface(), bv.Interface()) {
+			changed = append(changed, name)
+			bv.Set(av)
+		}
+	}
+
+	keep("log", &active.Log, &c.Log)
+	keep("network", &active.Network, &c.Network)
+	keep("prometheus", &active.Prometheus, &c.Prometheus)
+	keep("ecr.credentialCache", &active.Ecr.CredentialCache, &c.Ecr.CredentialCache)
+	keep("notation.mode", &active.Notation.Mode, &c.Notation.Mode)
+	keep("notation.homeDirectory", &active.Notation.HomeDir, &c.Notation.HomeDir)
+	keep("notation.trustPolicy", &active.Notation.TrustPolicy, &c.Notation.TrustPolicy)
+	keep("notation.xdgHomeVariable", &active.Notation.XdgHomeVar, &c.Notation.XdgHomeVar)
+	keep("notation.xdgHomeValue", &active.Notation.XdgHomeVal, &c.Notation.XdgHomeVal)
+	keep("notation.binaryDst", &active.Notation.BinaryDst, &c.Notation.BinaryDst)
+	keep("notation.verificationCache.enabled", &active.Notation.Cache.Enabled, &c.Notation.Cache.Enabled)
+	keep("imageVerificationPolicies", &active.ImageVerificationPolicies, &c.ImageVerificationPolicies)
+	keep("reload", &active.Reload, &c.Reload)
+	keep("kubernetes.events", &active.Kubernetes.Events, &c.Kubernetes.Events)
+	keep("audit", &active.Audit, &c.Audit)
+	keep("rescan", &active.Rescan, &c.Rescan)
+	keep("tracing", &active.Tracing, &c.Tracing)
+
+	// Namespace trust store certificates are added by init
+	stores := func(cfg *model.Config) map[string]string {
+		m := make(map[string]string)
+		for _, np := range cfg.Notation.NamespacePolicies {
+			if np.TrustStore != "" {
+				m[np.TrustStore] = np.RootCert
+			}
+		}
+		return m
+	}
+	if !reflect.DeepEqual(stores(active), stores(c)) {
+		changed = append(changed, "notation.namespacePolicies trustStore")
+	}
+
+	c.AwsAccountId = active.AwsAccountId
+	c.AwsRegion = active.AwsRegion
+	c.AwsRole = active.AwsRole
+	c.AwsTokenFilePath = active.AwsTokenFilePath
+
+	return changed
+}
+
+// trustStoreFingerprint hashes the names, sizes and modification times of the trust store files
+func trustStoreFingerprint() (string, error) {
+	root := model.ServerConfig().Notation.HomeDir + "/truststore"
+	h := sha256.New()
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(fmt.Sprintf("%s:%d:%d\n", path, fi.Size(), fi.ModTime().UnixNano())))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not walk trust store: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileHash returns the SHA-256 hash of a file
+func fileHash(file string) (string, error) {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %w", file, err)
+	}
+	return bytesHash(b), nil
+}
+
+func bytesHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// policyKey returns the hashes key of the named trust policy source
+func policyKey(name string) string {
+	return ComponentTrustPolicy + "/" + name
+}
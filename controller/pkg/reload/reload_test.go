@@ -0,0 +1,180 @@
+package reload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/utils"
+)
+
+// trustPolicyFormat is a trust policy trusting identity, at verification level
+const trustPolicyFormat = `{"version":"1.0","trustPolicies":[{"name":"default","registryScopes":["*"],
+"signatureVerification":{"level":%q},"trustStores":["signingAuthority:aws-signer-ts"],"trustedIdentities":[%q]}]}`
+
+// configFormat is a config with prometheus name, enforcement mode, notation home and the team namespace policy
+const configFormat = `prometheus:
+  name: %s
+enforcement:
+  mode: %s
+notation:
+  homeDirectory: %s
+  trustPolicy: trustpolicy.json
+  xdgHomeValue: %s
+  namespacePolicies:
+    - name: team
+      namespaces: ["team-*"]
+      trustPolicy: %s
+`
+
+// testFiles are the config and trust policy source files of a test Watcher
+type testFiles struct {
+	dir, config, defaultPolicy, teamPolicy string
+}
+
+// writeConfig writes the config, with prometheus name and enforcement mode, and notation home
+func (f testFiles) writeConfig(t *testing.T, name string, mode string, home string) {
+	t.Helper()
+
+	c := fmt.Sprintf(configFormat, name, mode, home, f.dir+"/xdg", f.teamPolicy)
+	if err := os.WriteFile(f.config, []byte(c), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePolicy writes trust policy source file, trusting identity, at verification level
+func writePolicy(t *testing.T, file string, level string, identity string) {
+	t.Helper()
+
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(trustPolicyFormat, level, identity)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestWatcher loads a config with the team namespace policy, writes the default and team trust
+// policies to a temporary notation home, and returns their Watcher, its metrics prefixed with name
+func newTestWatcher(t *testing.T, name string) (*Watcher, testFiles) {
+	t.Helper()
+
+	dir := t.TempDir()
+	f := testFiles{
+		dir:           dir,
+		config:        dir + "/config.yaml",
+		defaultPolicy: dir + "/default.json",
+		teamPolicy:    dir + "/team.json",
+	}
+	f.writeConfig(t, name, model.EnforcementEnforce, dir+"/notation")
+	writePolicy(t, f.defaultPolicy, "strict", "*")
+	writePolicy(t, f.teamPolicy, "strict", "*")
+
+	if err := utils.CreateDirectory(dir + "/notation/truststore/x509"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &model.Config{}
+	if err := c.LoadConfig(f.config); err != nil {
+		t.Fatal(err)
+	}
+	model.SetServerConfig(c)
+
+	w, err := NewWatcher(f.config, f.defaultPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := w.trustPolicies(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = notation.ReplacePolicies(policies); err != nil {
+		t.Fatal(err)
+	}
+
+	return w, f
+}
+
+// activeHash returns the hash of the active trust policy file of the named policy
+func activeHash(t *testing.T, name string) string {
+	t.Helper()
+
+	h, err := fileHash(notation.PolicyFile(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestTrustPoliciesSwappedTogether(t *testing.T) {
+	w, f := newTestWatcher(t, "reload_swap_test")
+	before := map[string]string{"": activeHash(t, ""), "team": activeHash(t, "team")}
+
+	writePolicy(t, f.defaultPolicy, "strict", "x509.subject: C=US, ST=WA, O=Example, CN=default")
+	writePolicy(t, f.teamPolicy, "audit", "x509.subject: C=US, ST=WA, O=Example, CN=team")
+	w.Check()
+
+	for name, h := range before {
+		got := activeHash(t, name)
+		if got == h || got != w.hashes[policyKey(name)] {
+			t.Errorf("%q trust policy hash = %s, want reloaded %s", name, got, w.hashes[policyKey(name)])
+		}
+	}
+	if _, ok := w.rejected[ComponentTrustPolicy]; ok {
+		t.Errorf("trust policy change rejected: %s", w.rejected[ComponentTrustPolicy])
+	}
+}
+
+func TestTrustPoliciesRejectedTogether(t *testing.T) {
+	w, _ := newTestWatcher(t, "reload_reject_test")
+
+	before := map[string]string{"": activeHash(t, ""), "team": activeHash(t, "team")}
+
+	// The valid default trust policy is not written, as the team trust policy is invalid
+	err := w.applyTrustPolicies(map[string][]byte{
+		"":     []byte(fmt.Sprintf(trustPolicyFormat, "strict", "x509.subject: C=US, ST=WA, O=Example, CN=default")),
+		"team": []byte(fmt.Sprintf(trustPolicyFormat, "unknown", "*")),
+	})
+	if err == nil {
+		t.Fatal("invalid trust policy applied")
+	}
+
+	for name, h := range before {
+		if got := activeHash(t, name); got != h {
+			t.Errorf("%q trust policy replaced", name)
+		}
+		if w.hashes[policyKey(name)] != h {
+			t.Errorf("%q trust policy hash updated", name)
+		}
+		if _, err = os.Stat(notation.PolicyFile(name) + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("%q staged trust policy left behind: %v", name, err)
+		}
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	w, f := newTestWatcher(t, "reload_config_test")
+	active := model.ServerConfig()
+
+	// An invalid config is rejected, and not retried until changed again
+	f.writeConfig(t, "reload_config_test", "block", active.Notation.HomeDir)
+	w.Check()
+	if model.ServerConfig() != active {
+		t.Fatal("invalid config swapped in")
+	}
+	h, _ := fileHash(f.config)
+	if w.rejected[ComponentConfig] != h {
+		t.Errorf("invalid config not remembered as rejected")
+	}
+
+	// Settings only read at startup keep their active values
+	f.writeConfig(t, "reload_config_test", model.EnforcementWarn, filepath.Join(f.dir, "moved"))
+	w.Check()
+	c := model.ServerConfig()
+	if c == active || c.Enforcement.Mode != model.EnforcementWarn {
+		t.Fatalf("enforcement mode = %q, want reloaded %q", c.Enforcement.Mode, model.EnforcementWarn)
+	}
+	if c.Notation.HomeDir != active.Notation.HomeDir {
+		t.Errorf("notation home = %s, want %s until restart", c.Notation.HomeDir, active.Notation.HomeDir)
+	}
+}
@@ -0,0 +1,228 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	v1 "k8s.io/api/admission/v1"
+
+	"notary-admission/pkg/admissioncontroller"
+	"notary-admission/pkg/handlers"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	ResultVerified = "verified"
+	ResultBypassed = "bypassed"
+	ResultFailed   = "failed"
+)
+
+// Review is the replay of a recorded AdmissionReview
+type Review struct {
+	File string `json:"file"`
+	// Index is the position of the review in its file
+	Index     int    `json:"index"`
+	Operation string `json:"operation,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Recorded is the response recorded with the review, if any
+	Recorded *v1.AdmissionResponse             `json:"recorded,omitempty"`
+	Response *v1.AdmissionResponse             `json:"response,omitempty"`
+	Images   []admissioncontroller.ImageResult `json:"images,omitempty"`
+	Error    string                            `json:"error,omitempty"`
+}
+
+// Changed determines if the replayed decision differs from the recorded one
+func (r *Review) Changed() bool {
+	return r.Recorded != nil && r.Response != nil && r.Recorded.Allowed != r.Response.Allowed
+}
+
+// Files expands paths to the files to replay, directories to their sorted .json files
+func Files(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p, "*.json"))
+		if err != nil {
+			return nil, fmt.Errorf("could not list %s: %w", p, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// Namespaces returns the request namespaces of the AdmissionReviews recorded in files
+func Namespaces(files []string) ([]string, error) {
+	var namespaces []string
+	for _, f := range files {
+		b, err := utils.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", f, err)
+		}
+
+		raws, err := splitReviews(b)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", f, err)
+		}
+
+		for _, raw := range raws {
+			if review, err := handlers.DecodeReview(raw); err == nil {
+				namespaces = append(namespaces, review.Request.Namespace)
+			}
+		}
+	}
+
+	return namespaces, nil
+}
+
+// Replay replays the AdmissionReviews recorded in file, a single review, concatenated reviews or a JSON
+// array of reviews, through the admission handler path of hook
+func Replay(hook admissioncontroller.Hook, file string) ([]Review, error) {
+	b, err := utils.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", file, err)
+	}
+
+	raws, err := splitReviews(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", file, err)
+	}
+
+	var reviews []Review
+	for i, raw := range raws {
+		reviews = append(reviews, replayReview(hook, file, i, raw))
+	}
+
+	return reviews, nil
+}
+
+// replayReview replays a single AdmissionReview
+func replayReview(hook admissioncontroller.Hook, file string, index int, raw []byte) Review {
+	r := Review{File: file, Index: index}
+
+	review, err := handlers.DecodeReview(raw)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	req := review.Request
+	r.Operation = string(req.Operation)
+	r.Kind = req.Kind.Kind
+	r.Namespace = req.Namespace
+	r.Name = req.Name
+	r.Recorded = review.Response
+
+	response, result, err := handlers.Review(context.Background(), hook, review)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	r.Response = response.Response
+	r.Images = result.Images
+
+	return r
+}
+
+// splitReviews splits concatenated JSON values, or the elements of a JSON array
+func splitReviews(b []byte) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, err
+		}
+		return raws, nil
+	}
+
+	var raws []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return raws, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raws = append(raws, raw)
+	}
+}
+
+// Write writes the replayed decision, the AdmissionResponse and the per-image breakdown
+func (r *Review) Write(w io.Writer) error {
+	name := r.Name
+	if r.Namespace != "" {
+		name = r.Namespace + "/" + name
+	}
+	fmt.Fprintf(w, "%s[%d]: %s %s %s\n", r.File, r.Index, r.Operation, r.Kind, name)
+
+	if r.Error != "" {
+		_, err := fmt.Fprintf(w, "  error: %s\n\n", r.Error)
+		return err
+	}
+
+	decision := fmt.Sprintf("allowed: %t", r.Response.Allowed)
+	if r.Recorded != nil {
+		decision = fmt.Sprintf("%s, recorded: %t", decision, r.Recorded.Allowed)
+		if r.Changed() {
+			decision += ", CHANGED"
+		}
+	}
+	fmt.Fprintf(w, "  %s\n", decision)
+
+	b, err := json.MarshalIndent(r.Response, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "  response: %s\n", b)
+
+	if len(r.Images) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  IMAGE\tRESULT\tDIGEST\tPOLICY\tMESSAGE")
+		for _, i := range r.Images {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", i.Image, imageResult(i), i.Digest, i.Policy,
+				strings.TrimSpace(i.Message))
+		}
+		if err = tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// imageResult returns the result of an image verification
+func imageResult(i admissioncontroller.ImageResult) string {
+	switch {
+	case i.Bypassed:
+		return ResultBypassed
+	case i.Verified:
+		return ResultVerified
+	default:
+		return ResultFailed
+	}
+}
@@ -0,0 +1,304 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	pv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"notary-admission/pkg/admissioncontroller"
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/apis/v1alpha1"
+	"notary-admission/pkg/exemption"
+	"notary-admission/pkg/imagepolicy"
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/notation"
+	"notary-admission/pkg/policycheck"
+	"notary-admission/pkg/utils"
+)
+
+const DefaultTrustPolicy = "trustpolicy.json"
+
+// Options configure the offline replay environment
+type Options struct {
+	ConfigFile      string
+	TrustPolicyFile string
+	// NotationHome is the local notation home, whose trust store and plugins are used
+	NotationHome string
+	// Mode overrides the notation verification mode of the config, if set
+	Mode string
+	// Objects are files, or directories of files, of the Namespace, ServiceAccount, Secret and
+	// ImageVerificationPolicy objects the server would read from the cluster
+	Objects []string
+	// Namespaces are the namespaces of the replayed requests, created without labels if not in Objects
+	Namespaces []string
+}
+
+// Setup initializes the server config, verifiers and fake Kubernetes clients as the server would,
+// with a temporary notation home holding the trust policies, linked to the local trust store and plugins.
+// It returns the validation hook, and the function that removes the temporary notation home.
+func Setup(o Options) (admissioncontroller.Hook, func(), error) {
+	var hook admissioncontroller.Hook
+
+	p, err := policycheck.Load(o.ConfigFile, o.TrustPolicyFile)
+	if err != nil {
+		return hook, nil, err
+	}
+
+	xdgHome, err := os.MkdirTemp("", "notary-admission-replay")
+	if err != nil {
+		return hook, nil, fmt.Errorf("could not create notation home: %w", err)
+	}
+	stop := make(chan struct{})
+	cleanup := func() {
+		close(stop)
+		_ = os.RemoveAll(xdgHome)
+	}
+
+	if err = setup(o, p, xdgHome, stop); err != nil {
+		cleanup()
+		return hook, nil, err
+	}
+
+	return workloads.NewValidationHook(), cleanup, nil
+}
+
+func setup(o Options, p *policycheck.Policies, xdgHome string, stop chan struct{}) error {
+	c := p.Config
+	if o.Mode != "" {
+		c.Notation.Mode = o.Mode
+	}
+	if c.Notation.TrustPolicy == "" {
+		c.Notation.TrustPolicy = DefaultTrustPolicy
+	}
+	if c.Notation.XdgHomeVar == "" {
+		c.Notation.XdgHomeVar = "XDG_CONFIG_HOME"
+	}
+	c.Notation.XdgHomeVal = xdgHome
+	c.Notation.HomeDir = filepath.Join(xdgHome, "notation")
+	c.Reload.Enabled = false
+	model.SetServerConfig(c)
+
+	if err := notation.ValidatePolicies(c); err != nil {
+		return fmt.Errorf("invalid namespace policies: %w", err)
+	}
+	if err := workloads.ValidateEnforcement(c); err != nil {
+		return fmt.Errorf("invalid enforcement config: %w", err)
+	}
+
+	if err := writeHome(o.NotationHome, p); err != nil {
+		return err
+	}
+	if err := os.Setenv(c.Notation.XdgHomeVar, xdgHome); err != nil {
+		return fmt.Errorf("could not set %s: %w", c.Notation.XdgHomeVar, err)
+	}
+
+	if c.Notation.Mode == model.LibraryMode {
+		if err := notation.InitLibrary(); err != nil {
+			return fmt.Errorf("notation library init failed: %w", err)
+		}
+	}
+
+	objects, policies, err := loadObjects(o.Objects)
+	if err != nil {
+		return err
+	}
+	objects = addNamespaces(objects, o.Namespaces)
+	kube.SetClients(fake.NewClientset(objects...), dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			v1alpha1.GroupVersionResource: v1alpha1.Kind + "List",
+		}, policies...))
+
+	if notation.UsesNamespaceLabels() || c.Enforcement.NamespaceLabel != "" || c.ImageVerificationPolicies.Enabled {
+		if err = kube.StartNamespaceInformer(); err != nil {
+			return fmt.Errorf("could not start namespace informer: %w", err)
+		}
+	}
+	if c.Kubernetes.PullSecrets.Enabled {
+		if err = kube.StartPullSecretInformers(); err != nil {
+			return fmt.Errorf("could not start pull secret informers: %w", err)
+		}
+	}
+	if c.ImageVerificationPolicies.Enabled {
+		if err = imagepolicy.Start(stop); err != nil {
+			return fmt.Errorf("could not load image verification policies: %w", err)
+		}
+	}
+
+	if err = verifier.InitVerifiers(); err != nil {
+		return fmt.Errorf("could not register verifiers: %w", err)
+	}
+	verifier.InitVerificationCache()
+
+	if err = exemption.InitExemptions(); err != nil {
+		return fmt.Errorf("could not load exemption keys: %w", err)
+	}
+
+	return nil
+}
+
+// writeHome writes the default and namespace trust policies to the notation home, as init would,
+// linking the trust store and plugins of the local notation home
+func writeHome(localHome string, p *policycheck.Policies) error {
+	home := model.ServerConfig().Notation.HomeDir
+	if err := utils.CreateDirectory(home); err != nil {
+		return fmt.Errorf("could not create notation home: %w", err)
+	}
+
+	for _, d := range []string{"truststore", "plugins"} {
+		src := filepath.Join(localHome, d)
+		if !utils.FileExists(src) {
+			log.Log.Warnf("%s not found, not linked", src)
+			continue
+		}
+		src, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+		if err = os.Symlink(src, filepath.Join(home, d)); err != nil {
+			return fmt.Errorf("could not link %s: %w", src, err)
+		}
+	}
+
+	for _, name := range notation.PolicyNames() {
+		// Trust policies are round-tripped through the model, as init writes them
+		var tp model.TrustPolicyModel
+		if err := json.Unmarshal(p.TrustPolicies[name], &tp); err != nil {
+			return fmt.Errorf("could not parse %q trust policy: %w", name, err)
+		}
+		b, err := tp.Json()
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			err = utils.CreateFile(notation.PolicyFile(name), b)
+		} else {
+			err = notation.WritePolicy(name, b)
+		}
+		if err != nil {
+			return fmt.Errorf("could not write %q trust policy: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadObjects loads the Kubernetes objects of YAML or JSON files, or directories of files, returning the
+// Namespace, ServiceAccount and Secret objects, and the ImageVerificationPolicy objects
+func loadObjects(paths []string) ([]runtime.Object, []runtime.Object, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		for _, ext := range []string{"*.yaml", "*.yml", "*.json"} {
+			matches, _ := filepath.Glob(filepath.Join(p, ext))
+			files = append(files, matches...)
+		}
+	}
+
+	var objects, policies []runtime.Object
+	for _, f := range files {
+		r, err := os.Open(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read %s: %w", f, err)
+		}
+
+		dec := yaml.NewYAMLOrJSONDecoder(r, 4096)
+		for {
+			u := &unstructured.Unstructured{}
+			err = dec.Decode(&u.Object)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				_ = r.Close()
+				return nil, nil, fmt.Errorf("could not parse %s: %w", f, err)
+			}
+			if len(u.Object) == 0 {
+				continue
+			}
+
+			obj, isPolicy, err := typedObject(u)
+			if err != nil {
+				_ = r.Close()
+				return nil, nil, fmt.Errorf("could not load %s: %w", f, err)
+			}
+			switch {
+			case isPolicy:
+				policies = append(policies, obj)
+			case obj != nil:
+				objects = append(objects, obj)
+			default:
+				log.Log.Warnf("%s %s in %s not used, skipped", u.GetKind(), u.GetName(), f)
+			}
+		}
+		_ = r.Close()
+	}
+
+	return objects, policies, nil
+}
+
+// addNamespaces adds unlabeled Namespace objects for namespaces not in objects
+func addNamespaces(objects []runtime.Object, namespaces []string) []runtime.Object {
+	found := make(map[string]bool)
+	for _, obj := range objects {
+		if ns, ok := obj.(*pv1.Namespace); ok {
+			found[ns.Name] = true
+		}
+	}
+
+	for _, n := range namespaces {
+		if n == "" || found[n] {
+			continue
+		}
+		found[n] = true
+		ns := &pv1.Namespace{}
+		ns.Name = n
+		objects = append(objects, ns)
+	}
+
+	return objects
+}
+
+// typedObject converts the objects read by the server to their typed form, ImageVerificationPolicy
+// objects are kept unstructured, for the dynamic client
+func typedObject(u *unstructured.Unstructured) (runtime.Object, bool, error) {
+	var obj runtime.Object
+	switch u.GroupVersionKind() {
+	case pv1.SchemeGroupVersion.WithKind("Namespace"):
+		obj = &pv1.Namespace{}
+	case pv1.SchemeGroupVersion.WithKind("ServiceAccount"):
+		obj = &pv1.ServiceAccount{}
+	case pv1.SchemeGroupVersion.WithKind("Secret"):
+		obj = &pv1.Secret{}
+	case v1alpha1.GroupVersionResource.GroupVersion().WithKind(v1alpha1.Kind):
+		return u, true, nil
+	default:
+		return nil, false, nil
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		return nil, false, fmt.Errorf("could not convert %s %s: %w", u.GetKind(), u.GetName(), err)
+	}
+
+	return obj, false, nil
+}
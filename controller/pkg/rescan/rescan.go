@@ -0,0 +1,346 @@
+package rescan
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	pv1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"notary-admission/pkg/admissioncontroller/verifier"
+	"notary-admission/pkg/admissioncontroller/workloads"
+	"notary-admission/pkg/decision"
+	"notary-admission/pkg/kube"
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/metrics"
+	"notary-admission/pkg/model"
+	"notary-admission/pkg/tracing"
+	"notary-admission/pkg/utils"
+)
+
+const (
+	DefaultInterval = 3600
+	DefaultRate     = 2
+
+	PodResync      = 10 * time.Minute
+	PodSyncTimeout = time.Minute
+
+	// LeaseName is the Lease held by the replica running the Scanner
+	LeaseName          = "notary-admission-rescan"
+	LeaseDuration      = 30 * time.Second
+	LeaseRenewDeadline = 20 * time.Second
+	LeaseRetryPeriod   = 5 * time.Second
+)
+
+// Scanner periodically re-verifies the images of running pods, as admission would, reporting the
+// workloads that would now be denied, such as after a signing profile was revoked. Only the replica
+// holding the rescan Lease scans.
+type Scanner struct {
+	client   kubernetes.Interface
+	pods     listers.PodLister
+	selector labels.Selector
+	interval time.Duration
+	limiter  flowcontrol.RateLimiter
+	metric   *metrics.PrometheusRescanMetric
+}
+
+// Start starts the Scanner, if enabled, until stop is closed
+func Start(stop chan struct{}) error {
+	c := model.ServerConfig()
+	if !c.Rescan.Enabled {
+		return nil
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		return fmt.Errorf("could not elect rescan leader: POD_NAMESPACE not set")
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		var err error
+		if identity, err = os.Hostname(); err != nil {
+			return fmt.Errorf("could not elect rescan leader: %w", err)
+		}
+	}
+
+	s, err := NewScanner(c, stop)
+	if err != nil {
+		return err
+	}
+	go s.Lead(namespace, identity, stop)
+
+	log.Log.Infof("running pods re-verified every %s, in namespaces matching %q, by the %s Lease holder",
+		s.interval, s.selector, LeaseName)
+
+	return nil
+}
+
+// NewScanner creates the Scanner of config c, starting, and syncing, its running pods informer
+func NewScanner(c *model.Config, stop chan struct{}) (*Scanner, error) {
+	selector, err := labels.Parse(c.Rescan.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("malformed rescan namespace selector %q: %w", c.Rescan.NamespaceSelector, err)
+	}
+
+	interval := c.Rescan.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	rate := c.Rescan.Rate
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+
+	client, err := kube.GetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, PodResync,
+		informers.WithTweakListOptions(func(o *meta.ListOptions) {
+			o.FieldSelector = "status.phase=" + string(pv1.PodRunning)
+		}))
+	informer := factory.Core().V1().Pods()
+	lister := informer.Lister()
+
+	factory.Start(stop)
+
+	timeout := make(chan struct{})
+	t := time.AfterFunc(PodSyncTimeout, func() { close(timeout) })
+	defer t.Stop()
+
+	if !cache.WaitForCacheSync(timeout, informer.Informer().HasSynced) {
+		return nil, fmt.Errorf("could not sync pod informer")
+	}
+
+	return &Scanner{
+		client:   client,
+		pods:     lister,
+		selector: selector,
+		interval: time.Duration(interval) * time.Second,
+		limiter:  flowcontrol.NewTokenBucketRateLimiter(float32(rate), 1),
+		metric:   metrics.InitPrometheusRescanMetric(c.Prometheus.Name),
+	}, nil
+}
+
+// Lead runs the Scanner while identity holds the rescan Lease of namespace, so running pods are
+// re-verified by one replica, until stop is closed
+func (s *Scanner) Lead(namespace string, identity string, stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  meta.ObjectMeta{Name: LeaseName, Namespace: namespace},
+		Client:     s.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	// Leadership lost is contended for again
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   LeaseDuration,
+			RenewDeadline:   LeaseRenewDeadline,
+			RetryPeriod:     LeaseRetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					log.Log.Infof("%s holds the %s Lease, re-verifying running pods", identity, LeaseName)
+					s.Run(ctx)
+				},
+				OnStoppedLeading: func() {
+					log.Log.Infof("%s released the %s Lease", identity, LeaseName)
+				},
+			},
+		})
+	}
+}
+
+// Run scans running pods, then every interval, until ctx is done
+func (s *Scanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Scan(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan re-verifies the images of the running pods of selected namespaces, once per controller, at the
+// configured rate, emitting an Event for each workload that would now be denied
+func (s *Scanner) Scan(ctx context.Context) {
+	start := time.Now()
+
+	pods, err := s.pods.List(labels.Everything())
+	if err != nil {
+		log.Log.Errorf("could not list running pods: %v", err)
+		return
+	}
+
+	counts := make(map[string]map[string]int)
+	seen := make(map[string]bool)
+	denied := 0
+
+	for _, p := range pods {
+		if p.DeletionTimestamp != nil || !s.selected(p.Namespace) {
+			continue
+		}
+
+		wl := workloads.FromPod(p)
+		wl.Images = runningImages(p)
+		key := workloadKey(wl)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if err = s.limiter.Wait(ctx); err != nil {
+			log.Log.Infof("re-verification scan stopped: %v", err)
+			return
+		}
+
+		if counts[wl.Namespace] == nil {
+			counts[wl.Namespace] = make(map[string]int)
+		}
+
+		// Cached results are not reused, so revoked signatures are detected on the next scan. Outcomes are
+		// only recorded on the rescan metrics.
+		vctx, span := tracing.Start(verifier.WithoutOutcomes(verifier.WithoutCache(ctx)), "rescan.verify", semconv.K8SNamespaceName(wl.Namespace),
+			tracing.AttrKind.String(wl.Kind), tracing.AttrName.String(wl.Name))
+		result, mode := workloads.Verify(vctx, wl)
+		span.End()
+		if result == nil {
+			counts[wl.Namespace][workloads.DecisionAllowed]++
+			continue
+		}
+
+		counts[wl.Namespace][workloads.DecisionDenied]++
+		denied++
+
+		log.Log.Warnf("running %s would now be denied, %s mode: %s", key, mode, result.Msg)
+		decision.Event(reference(wl), pv1.EventTypeWarning, decision.ReasonReverificationFailed,
+			fmt.Sprintf("%s mode, running workload would now be denied: %s", mode, result.Msg))
+	}
+
+	// Namespaces without running workloads are removed
+	s.metric.Workloads.Reset()
+	for ns, c := range counts {
+		for d, n := range c {
+			s.metric.Workloads.WithLabelValues(ns, d).Set(float64(n))
+		}
+	}
+	s.metric.Completed.SetToCurrentTime()
+
+	log.Log.Infof("re-verified %d running workloads in %s, %d would now be denied", len(seen),
+		time.Since(start).Round(time.Millisecond), denied)
+}
+
+// selected determines if namespace labels match the namespace selector
+func (s *Scanner) selected(namespace string) bool {
+	if s.selector.Empty() {
+		return true
+	}
+
+	l, err := kube.NamespaceLabels(namespace)
+	if err != nil {
+		log.Log.Warnf("could not get %s namespace labels, not re-verified: %v", namespace, err)
+		return false
+	}
+
+	return s.selector.Matches(labels.Set(l))
+}
+
+// runningImages returns the images of pod p, pinned to the digests its container statuses report, so
+// the digests that were admitted are re-verified, not tags resolved again. Containers without a
+// reported digest are re-verified by their Pod spec reference.
+func runningImages(p *pv1.Pod) []string {
+	ids := make(map[string]string)
+	for _, statuses := range [][]pv1.ContainerStatus{p.Status.ContainerStatuses, p.Status.InitContainerStatuses,
+		p.Status.EphemeralContainerStatuses} {
+		for _, cs := range statuses {
+			ids[cs.Name] = cs.ImageID
+		}
+	}
+
+	var images []string
+	for _, c := range p.Spec.Containers {
+		images = append(images, pinnedImage(c.Image, ids[c.Name]))
+	}
+	for _, c := range p.Spec.InitContainers {
+		images = append(images, pinnedImage(c.Image, ids[c.Name]))
+	}
+	for _, c := range p.Spec.EphemeralContainers {
+		images = append(images, pinnedImage(c.Image, ids[c.Name]))
+	}
+
+	return images
+}
+
+// pinnedImage returns image pinned to the digest of container image ID id, such as
+// docker-pullable://registry/repository@sha256:..., or image if id has no repository digest
+func pinnedImage(image string, id string) string {
+	_, digest, ok := strings.Cut(id, "@")
+	if !ok || !strings.HasPrefix(digest, "sha256:") {
+		return image
+	}
+
+	ref, err := utils.ParseImageReference(image)
+	if err != nil {
+		return image
+	}
+	ref.Digest = digest
+
+	return ref.String()
+}
+
+// workloadKey identifies the controller of the workload, or the workload if it has none
+func workloadKey(wl *workloads.Workload) string {
+	if wl.Owner != nil {
+		return fmt.Sprintf("%s/%s/%s", wl.Namespace, wl.Owner.Kind, wl.Owner.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", wl.Namespace, wl.Kind, wl.Name)
+}
+
+// reference returns the reference of the controller of the workload, or of the workload if it has none
+func reference(wl *workloads.Workload) *pv1.ObjectReference {
+	if wl.Owner != nil {
+		return &pv1.ObjectReference{
+			APIVersion: wl.Owner.APIVersion,
+			Kind:       wl.Owner.Kind,
+			Name:       wl.Owner.Name,
+			Namespace:  wl.Namespace,
+			UID:        wl.Owner.UID,
+		}
+	}
+
+	return &pv1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       wl.Kind,
+		Name:       wl.Name,
+		Namespace:  wl.Namespace,
+		UID:        types.UID(wl.UID),
+	}
+}
@@ -0,0 +1,49 @@
+package rescan
+
+import (
+	"reflect"
+	"testing"
+
+	pv1 "k8s.io/api/core/v1"
+)
+
+const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestRunningImages(t *testing.T) {
+	p := &pv1.Pod{
+		Spec: pv1.PodSpec{
+			Containers: []pv1.Container{
+				{Name: "app", Image: "registry.example.com/app:v1"},
+				{Name: "sidecar", Image: "registry.example.com/sidecar:v1"},
+				{Name: "pending", Image: "registry.example.com/pending:v1"},
+			},
+			InitContainers: []pv1.Container{{Name: "init", Image: "registry.example.com/init:v1"}},
+			EphemeralContainers: []pv1.EphemeralContainer{
+				{EphemeralContainerCommon: pv1.EphemeralContainerCommon{Name: "debug", Image: "busybox"}},
+			},
+		},
+		Status: pv1.PodStatus{
+			ContainerStatuses: []pv1.ContainerStatus{
+				{Name: "app", ImageID: "docker-pullable://registry.example.com/app@" + digest},
+				{Name: "sidecar", ImageID: "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"},
+			},
+			InitContainerStatuses: []pv1.ContainerStatus{
+				{Name: "init", ImageID: "registry.example.com/init@" + digest},
+			},
+			EphemeralContainerStatuses: []pv1.ContainerStatus{
+				{Name: "debug", ImageID: "docker.io/library/busybox@" + digest},
+			},
+		},
+	}
+
+	want := []string{
+		"registry.example.com/app:v1@" + digest,
+		"registry.example.com/sidecar:v1",
+		"registry.example.com/pending:v1",
+		"registry.example.com/init:v1@" + digest,
+		"docker.io/library/busybox:latest@" + digest,
+	}
+	if got := runningImages(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("runningImages = %v, want %v", got, want)
+	}
+}
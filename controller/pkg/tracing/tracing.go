@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	log "notary-admission/pkg/logging"
+	"notary-admission/pkg/model"
+)
+
+const (
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+
+	DefaultServiceName = "notary-admission"
+	DefaultSampleRatio = 1.0
+
+	// TracerName is the instrumentation scope of the spans
+	TracerName = "notary-admission"
+)
+
+// Span attributes
+const (
+	AttrUID       = attribute.Key("k8s.admission.uid")
+	AttrOperation = attribute.Key("k8s.admission.operation")
+	AttrKind      = attribute.Key("k8s.object.kind")
+	AttrName      = attribute.Key("k8s.object.name")
+	AttrRegistry  = attribute.Key("oci.registry")
+	AttrDigest    = attribute.Key("oci.digest")
+	AttrPolicy    = attribute.Key("verification.policy")
+	AttrResult    = attribute.Key("verification.result")
+	AttrReason    = attribute.Key("verification.reason")
+	AttrMode      = attribute.Key("notation.mode")
+	AttrAllowed   = attribute.Key("admission.allowed")
+)
+
+var tp atomic.Pointer[sdktrace.TracerProvider]
+
+// InitTracing sets the global tracer provider exporting spans, if tracing is enabled. Otherwise,
+// spans are not recorded.
+func InitTracing() error {
+	c := model.ServerConfig()
+	if !c.Tracing.Enabled {
+		return nil
+	}
+
+	exporter, err := newExporter(c)
+	if err != nil {
+		return err
+	}
+
+	name := c.Name
+	if name == "" {
+		name = DefaultServiceName
+	}
+	r, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(name)))
+	if err != nil {
+		return fmt.Errorf("could not create trace resource: %w", err)
+	}
+
+	ratio := c.Tracing.SampleRatio
+	if ratio <= 0 {
+		ratio = DefaultSampleRatio
+	}
+
+	p := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(r),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	tp.Store(p)
+
+	otel.SetTracerProvider(p)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	log.Log.Infof("tracing enabled, %s exporter, sample ratio %g", c.Tracing.Exporter, ratio)
+
+	return nil
+}
+
+// newExporter creates the span exporter of config c. The OTLP endpoint defaults to the
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variable, or localhost.
+func newExporter(c *model.Config) (sdktrace.SpanExporter, error) {
+	switch c.Tracing.Exporter {
+	case ExporterOtlp, "":
+		var opts []otlptracehttp.Option
+		if c.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(c.Tracing.Endpoint))
+		}
+		e, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create OTLP trace exporter: %w", err)
+		}
+		return e, nil
+	case ExporterStdout:
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("could not create stdout trace exporter: %w", err)
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %s", c.Tracing.Exporter)
+	}
+}
+
+// Shutdown exports the remaining spans, and stops the tracer provider, if tracing is enabled
+func Shutdown(ctx context.Context) error {
+	p := tp.Load()
+	if p == nil {
+		return nil
+	}
+	return p.Shutdown(ctx)
+}
+
+// Start starts a span, the child of the span of ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Extract returns a context with the trace propagated in the headers of a request, if any
+func Extract(ctx context.Context, headers propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headers)
+}
+
+// Fail records err on span, setting its status to error
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	PartitionAws    = "aws"
+	PartitionAwsCn  = "aws-cn"
+	PartitionAwsGov = "aws-us-gov"
+	PartitionAwsIso = "aws-iso"
+	PartitionIsoB   = "aws-iso-b"
+
+	EcrVariantStandard  = "standard"
+	EcrVariantFips      = "fips"
+	EcrVariantDualStack = "dualstack"
+	EcrVariantVpce      = "vpce"
+)
+
+// ecrHost matches ECR registry hosts, including FIPS, dual-stack and VPC endpoint DNS names:
+// <account|vpce-id>.<dkr.ecr|dkr.ecr-fips|dkr-ecr|dkr-ecr-fips>.<region>.[vpce.]<dns suffix>
+var ecrHost = regexp.MustCompile(`^(?:(\d{12})|(vpce-[a-z0-9-]+))\.(dkr\.ecr|dkr\.ecr-fips|dkr-ecr|dkr-ecr-fips)\.` +
+	`([a-z0-9-]+)\.(vpce\.)?(amazonaws\.com|amazonaws\.com\.cn|on\.aws|on\.amazonwebservices\.com\.cn|` +
+	`c2s\.ic\.gov|sc2s\.sgov\.gov)$`)
+
+// EcrRegistry stores the parts of an ECR registry host
+type EcrRegistry struct {
+	Host        string
+	Account     string
+	Region      string
+	Partition   string
+	Variant     string
+	Fips        bool
+	VpcEndpoint string
+}
+
+// ParseEcrRegistry parses the account, region, partition and endpoint variant of an ECR registry host.
+// VPC endpoint DNS names have no account, but the VPC endpoint ID.
+func ParseEcrRegistry(registry string) (EcrRegistry, error) {
+	host := strings.ToLower(registry)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	m := ecrHost.FindStringSubmatch(host)
+	if m == nil {
+		return EcrRegistry{}, fmt.Errorf("%s is not an ECR registry", registry)
+	}
+
+	r := EcrRegistry{
+		Host:    registry,
+		Account: m[1],
+		Region:  m[4],
+		Fips:    strings.HasSuffix(m[3], "-fips"),
+	}
+
+	// VPC endpoint DNS names are the endpoint ID, and a suffix: vpce-0123456789abcdef0-abcdefgh
+	if m[2] != "" {
+		parts := strings.SplitN(m[2], "-", 3)
+		r.VpcEndpoint = parts[0] + "-" + parts[1]
+	}
+
+	switch {
+	case m[2] != "" || m[5] != "":
+		r.Variant = EcrVariantVpce
+	case strings.HasPrefix(m[3], "dkr-ecr"):
+		r.Variant = EcrVariantDualStack
+	case r.Fips:
+		r.Variant = EcrVariantFips
+	default:
+		r.Variant = EcrVariantStandard
+	}
+
+	switch m[6] {
+	case "amazonaws.com.cn", "on.amazonwebservices.com.cn":
+		r.Partition = PartitionAwsCn
+	case "c2s.ic.gov":
+		r.Partition = PartitionAwsIso
+	case "sc2s.sgov.gov":
+		r.Partition = PartitionIsoB
+	default:
+		r.Partition = partitionFromRegion(r.Region)
+	}
+
+	return r, nil
+}
+
+// EcrRegistryHost builds the standard ECR registry host of account in region
+func EcrRegistryHost(account string, region string) string {
+	return fmt.Sprintf("%s.dkr.ecr.%s.%s", account, region, dnsSuffix(partitionFromRegion(region)))
+}
+
+// partitionFromRegion returns the partition of region
+func partitionFromRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return PartitionAwsCn
+	case strings.HasPrefix(region, "us-gov-"):
+		return PartitionAwsGov
+	case strings.HasPrefix(region, "us-isob-"):
+		return PartitionIsoB
+	case strings.HasPrefix(region, "us-iso-"):
+		return PartitionAwsIso
+	default:
+		return PartitionAws
+	}
+}
+
+// dnsSuffix returns the ECR DNS suffix of partition
+func dnsSuffix(partition string) string {
+	switch partition {
+	case PartitionAwsCn:
+		return "amazonaws.com.cn"
+	case PartitionAwsIso:
+		return "c2s.ic.gov"
+	case PartitionIsoB:
+		return "sc2s.sgov.gov"
+	default:
+		return "amazonaws.com"
+	}
+}
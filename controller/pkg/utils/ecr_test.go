@@ -0,0 +1,88 @@
+package utils
+
+import "testing"
+
+func TestParseEcrRegistry(t *testing.T) {
+	tests := []struct {
+		registry string
+		want     EcrRegistry
+		wantErr  bool
+	}{
+		{
+			registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			want: EcrRegistry{Account: "123456789012", Region: "us-east-1", Partition: PartitionAws,
+				Variant: EcrVariantStandard},
+		},
+		{
+			registry: "123456789012.dkr.ecr-fips.us-gov-west-1.amazonaws.com",
+			want: EcrRegistry{Account: "123456789012", Region: "us-gov-west-1", Partition: PartitionAwsGov,
+				Variant: EcrVariantFips, Fips: true},
+		},
+		{
+			registry: "123456789012.dkr-ecr.eu-west-1.on.aws",
+			want: EcrRegistry{Account: "123456789012", Region: "eu-west-1", Partition: PartitionAws,
+				Variant: EcrVariantDualStack},
+		},
+		{
+			registry: "123456789012.dkr-ecr-fips.us-east-2.on.aws",
+			want: EcrRegistry{Account: "123456789012", Region: "us-east-2", Partition: PartitionAws,
+				Variant: EcrVariantDualStack, Fips: true},
+		},
+		{
+			registry: "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn",
+			want: EcrRegistry{Account: "123456789012", Region: "cn-north-1", Partition: PartitionAwsCn,
+				Variant: EcrVariantStandard},
+		},
+		{
+			registry: "123456789012.dkr.ecr.us-iso-east-1.c2s.ic.gov",
+			want: EcrRegistry{Account: "123456789012", Region: "us-iso-east-1", Partition: PartitionAwsIso,
+				Variant: EcrVariantStandard},
+		},
+		{
+			registry: "vpce-0123456789abcdef0-abcdefgh.dkr.ecr.us-east-1.vpce.amazonaws.com",
+			want: EcrRegistry{Region: "us-east-1", Partition: PartitionAws, Variant: EcrVariantVpce,
+				VpcEndpoint: "vpce-0123456789abcdef0"},
+		},
+		{
+			registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com:443",
+			want: EcrRegistry{Account: "123456789012", Region: "us-east-1", Partition: PartitionAws,
+				Variant: EcrVariantStandard},
+		},
+		{registry: "public.ecr.aws", wantErr: true},
+		{registry: "12345.dkr.ecr.us-east-1.amazonaws.com", wantErr: true},
+		{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com.evil.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.registry, func(t *testing.T) {
+			got, err := ParseEcrRegistry(tt.registry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEcrRegistry(%q) error = %v, wantErr %t", tt.registry, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Host = tt.registry
+			if got != tt.want {
+				t.Errorf("ParseEcrRegistry(%q) = %+v, want %+v", tt.registry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEcrRegistryHost(t *testing.T) {
+	tests := []struct {
+		account string
+		region  string
+		want    string
+	}{
+		{account: "123456789012", region: "us-west-2", want: "123456789012.dkr.ecr.us-west-2.amazonaws.com"},
+		{account: "123456789012", region: "cn-northwest-1", want: "123456789012.dkr.ecr.cn-northwest-1.amazonaws.com.cn"},
+	}
+
+	for _, tt := range tests {
+		if got := EcrRegistryHost(tt.account, tt.region); got != tt.want {
+			t.Errorf("EcrRegistryHost(%s, %s) = %s, want %s", tt.account, tt.region, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/distribution/reference"
+)
+
+const (
+	DockerHubRegistry = "docker.io"
+	DockerHubHost     = "registry-1.docker.io"
+)
+
+// ImageReference stores the parts of a normalized image reference
+type ImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference parses and normalizes image, as the container runtime would,
+// so nginx is docker.io/library/nginx:latest
+func ParseImageReference(image string) (ImageReference, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ImageReference{}, fmt.Errorf("malformed image reference %q: %w", image, err)
+	}
+
+	r := ImageReference{
+		Registry:   reference.Domain(named),
+		Repository: reference.Path(named),
+	}
+
+	if t, ok := named.(reference.Tagged); ok {
+		r.Tag = t.Tag()
+	}
+
+	if d, ok := named.(reference.Digested); ok {
+		r.Digest = d.Digest().String()
+	}
+
+	// Untagged references without digest are pulled as latest
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	return r, nil
+}
+
+// Name returns the registry/repository form of the reference
+func (r ImageReference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the normalized reference, registry/repository[:tag][@digest]
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s = s + ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s = s + "@" + r.Digest
+	}
+	return s
+}
+
+// WithDigest returns the registry/repository@digest form of the reference
+func (r ImageReference) WithDigest(digest string) string {
+	return r.Name() + "@" + digest
+}
+
+// RegistryHost returns the host serving registry, registry-1.docker.io for docker.io
+func RegistryHost(registry string) string {
+	if registry == DockerHubRegistry {
+		return DockerHubHost
+	}
+	return registry
+}
@@ -0,0 +1,78 @@
+package utils
+
+import "testing"
+
+func TestParseImageReference(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		image   string
+		want    ImageReference
+		wantErr bool
+	}{
+		{
+			image: "nginx",
+			want:  ImageReference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			image: "library/nginx",
+			want:  ImageReference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			image: "nginx:1.27",
+			want:  ImageReference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.27"},
+		},
+		{
+			image: "host:5000/x@" + digest,
+			want:  ImageReference{Registry: "host:5000", Repository: "x", Digest: digest},
+		},
+		{
+			image: "123456789012.dkr.ecr.us-east-1.amazonaws.com/apps/api:v1@" + digest,
+			want: ImageReference{Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Repository: "apps/api",
+				Tag: "v1", Digest: digest},
+		},
+		{image: "", wantErr: true},
+		{image: "Nginx", wantErr: true},
+		{image: "nginx:", wantErr: true},
+		{image: "nginx@sha256:abc", wantErr: true},
+		{image: "host:5000/x y", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := ParseImageReference(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseImageReference(%q) error = %v, wantErr %t", tt.image, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseImageReference(%q) = %+v, want %+v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageReferenceString(t *testing.T) {
+	tests := []struct {
+		image string
+		name  string
+		str   string
+	}{
+		{image: "nginx", name: "docker.io/library/nginx", str: "docker.io/library/nginx:latest"},
+		{image: "ghcr.io/org/app:v2", name: "ghcr.io/org/app", str: "ghcr.io/org/app:v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			r, err := ParseImageReference(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Name() != tt.name {
+				t.Errorf("Name() = %s, want %s", r.Name(), tt.name)
+			}
+			if r.String() != tt.str {
+				t.Errorf("String() = %s, want %s", r.String(), tt.str)
+			}
+		})
+	}
+}
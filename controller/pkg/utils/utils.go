@@ -8,7 +8,6 @@ import (
 	"os/exec"
 	"strings"
 
-	"net/url"
 	log "notary-admission/pkg/logging"
 )
 
@@ -118,36 +117,32 @@ func AccountFromRole(roleArn string) string {
 	return ""
 }
 
-// AccountFromImage parses AWS account ID from registry url
-//func AccountFromImage(registry string) string {
-//	a := strings.Split(registry, ".")
-//	if len(a) >= 6 {
-//		return strings.Split(a[0], "//")[1]
-//	}
-//	return ""
-//}
+// AccountFromRegistry parses AWS account ID from ECR registry url
+func AccountFromRegistry(registry string) string {
+	r, err := ParseEcrRegistry(registry)
+	if err != nil {
+		return ""
+	}
+	return r.Account
+}
 
-// RegionFromRegistry parses AWS region ID from registry url
+// RegionFromRegistry parses AWS region ID from ECR registry url
 func RegionFromRegistry(registry string) string {
-	a := strings.Split(registry, ".")
-	if len(a) >= 6 {
-		return a[3]
+	r, err := ParseEcrRegistry(registry)
+	if err != nil {
+		return ""
 	}
-	return ""
+	return r.Region
 }
 
-// RegistryFromImage parses ECR registry from image url
+// RegistryFromImage parses the normalized registry from image, docker.io if none
 func RegistryFromImage(image string) string {
-	if strings.Contains(image, "https://") {
-		u, err := url.Parse(image)
-		if err != nil {
-			log.Log.Errorf("could not get regsitry from %s, %+v", image, err)
-			return ""
-		}
-		return u.Host
+	r, err := ParseImageReference(image)
+	if err != nil {
+		log.Log.Errorf("could not get registry from %s, %v", image, err)
+		return ""
 	}
-
-	return image[:strings.IndexByte(image, '/')]
+	return r.Registry
 }
 
 // CreateDirectory creates dir at path